@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/stats"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gocv.io/x/gocv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// matProfileName is the pprof profile that tracks gocv Mat, only available when built with 'matprofile' tag
+const matProfileName = "gocv.io/x/gocv.Mat"
+
+type benchReport struct {
+	Dataset    string              `json:"dataset"`
+	Frames     int                 `json:"frames"`
+	Iterations int                 `json:"iterations"`
+	Config     part.DetectorConfig `json:"config"`
+	// Stages durations, in nanoseconds
+	Stages map[part.Stage]stats.Summary `json:"stages"`
+	Total  stats.Summary                `json:"total"`
+	// Budget is the max duration allowed by frame
+	Budget       time.Duration `json:"budget"`
+	WithinBudget bool          `json:"within_budget"`
+	// AllocsPerFrame and BytesPerFrame measure go heap allocations only
+	AllocsPerFrame float64 `json:"allocs_per_frame"`
+	BytesPerFrame  float64 `json:"bytes_per_frame"`
+	// MaxLiveMats and LeakedMats are -1 when binary is not built with 'matprofile' tag
+	MaxLiveMats int `json:"max_live_mats"`
+	LeakedMats  int `json:"leaked_mats"`
+}
+
+// stageRecorder collects stages durations and live Mats count
+type stageRecorder struct {
+	durations   map[part.Stage][]time.Duration
+	matProfile  *pprof.Profile
+	maxLiveMats int
+}
+
+func (s *stageRecorder) OnStage(stage part.Stage, _ time.Time, duration time.Duration, _ *gocv.Mat) {
+	s.durations[stage] = append(s.durations[stage], duration)
+	s.recordLiveMats()
+}
+
+func (s *stageRecorder) recordLiveMats() {
+	if s.matProfile == nil {
+		return
+	}
+	if count := s.matProfile.Count(); count > s.maxLiveMats {
+		s.maxLiveMats = count
+	}
+}
+
+// runBench runs detection pipeline over a dataset and reports stages latency
+func runBench(args []string) {
+	var dataset, detectorConfig, detectorProfile, jsonOutput string
+	var iterations, warmup int
+	var budget time.Duration
+
+	cfg := loadConfig("bench", args,
+		config.WithDefaults(quietDefaults),
+		config.WithFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(&dataset, "dataset", "pkg/part/testdata", "Directory of jpeg/png images or record file to process")
+			fs.StringVar(&detectorConfig, "detector-config", "", "Json detector config or yaml config file (ex: tune output), applied on top of detector settings")
+			fs.StringVar(&detectorProfile, "detector-profile", "", "Profile of yaml -detector-config file applied on top of its detector settings")
+			fs.IntVar(&iterations, "n", 10, "Number of passes over dataset")
+			fs.IntVar(&warmup, "warmup", 1, "Number of passes over dataset before measures")
+			fs.DurationVar(&budget, "budget", 50*time.Millisecond, "Max processing duration by frame (50ms for 20 fps)")
+			fs.StringVar(&jsonOutput, "json", "", "Write report as json to this file, '-' for stdout")
+		}),
+	)
+
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
+
+	detector, err := loadDetectorConfig(detectorConfig, detectorProfile, cfg.Detector)
+	if err != nil {
+		zap.S().Fatalf("unable to load detector config: %v", err)
+	}
+	frames, err := loadFrames(dataset)
+	if err != nil {
+		zap.S().Fatalf("unable to load dataset: %v", err)
+	}
+	if iterations < 1 {
+		zap.S().Fatalf("invalid number of iterations %v, should be >= 1", iterations)
+	}
+
+	report := bench(frames, detector, iterations, warmup)
+	report.Dataset = dataset
+	report.Budget = budget
+	report.WithinBudget = report.Total.P99 <= budget
+
+	if jsonOutput != "" {
+		if err := writeJSON(jsonOutput, &report); err != nil {
+			zap.S().Fatalf("unable to write json report: %v", err)
+		}
+	}
+	if jsonOutput != "-" {
+		printBenchReport(&report)
+	}
+}
+
+func bench(frames []encodedFrame, detector part.DetectorConfig, iterations, warmup int) benchReport {
+	rd := part.NewRoadDetectorWithConfig(detector)
+	defer func() {
+		if err := rd.Close(); err != nil {
+			zap.S().Errorf("unable to close road detector: %v", err)
+		}
+	}()
+
+	recorder := stageRecorder{
+		durations:   make(map[part.Stage][]time.Duration),
+		matProfile:  pprof.Lookup(matProfileName),
+		maxLiveMats: -1,
+	}
+	var total []time.Duration
+
+	var memBefore, memAfter runtime.MemStats
+	liveMatsBefore := -1
+	for i := -warmup; i < iterations; i++ {
+		if i == 0 {
+			// End of warmup, reset measures
+			recorder.durations = make(map[part.Stage][]time.Duration)
+			total = nil
+			if recorder.matProfile != nil {
+				liveMatsBefore = recorder.matProfile.Count()
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&memBefore)
+		}
+		for _, f := range frames {
+			start := time.Now()
+			img, err := gocv.IMDecode(f.data, gocv.IMReadUnchanged)
+			if err != nil {
+				zap.S().Fatalf("unable to decode frame %v: %v", f.name, err)
+			}
+			recorder.OnStage(part.StageDecode, start, time.Since(start), &img)
+
+			detection := rd.Detect(img, &recorder)
+			total = append(total, time.Since(start))
+
+			if err := detection.Close(); err != nil {
+				zap.S().Errorf("unable to close detection: %v", err)
+			}
+			if err := img.Close(); err != nil {
+				zap.S().Errorf("unable to close image: %v", err)
+			}
+		}
+	}
+	runtime.ReadMemStats(&memAfter)
+
+	processed := float64(iterations * len(frames))
+	report := benchReport{
+		Frames:         len(frames),
+		Iterations:     iterations,
+		Config:         detector,
+		Stages:         make(map[part.Stage]stats.Summary, len(recorder.durations)),
+		Total:          stats.Summarize(total),
+		AllocsPerFrame: float64(memAfter.Mallocs-memBefore.Mallocs) / processed,
+		BytesPerFrame:  float64(memAfter.TotalAlloc-memBefore.TotalAlloc) / processed,
+		MaxLiveMats:    recorder.maxLiveMats,
+		LeakedMats:     -1,
+	}
+	for stage, durations := range recorder.durations {
+		report.Stages[stage] = stats.Summarize(durations)
+	}
+	if recorder.matProfile != nil {
+		report.LeakedMats = recorder.matProfile.Count() - liveMatsBefore
+	}
+	return report
+}
+
+func printBenchReport(r *benchReport) {
+	fmt.Printf("dataset: %v, %v frames x %v iterations\n\n", r.Dataset, r.Frames, r.Iterations)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "stage\tp50\tp95\tp99\tmax\t")
+	for _, stage := range part.Stages {
+		s, ok := r.Stages[stage]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t\n", stage, s.P50, s.P95, s.P99, s.Max)
+	}
+	fmt.Fprintf(w, "total\t%v\t%v\t%v\t%v\t\n", r.Total.P50, r.Total.P95, r.Total.P99, r.Total.Max)
+	_ = w.Flush()
+
+	fmt.Printf("\nallocations by frame: %.1f (%.0f bytes)\n", r.AllocsPerFrame, r.BytesPerFrame)
+	if r.MaxLiveMats < 0 {
+		fmt.Println("gocv Mat counts: unavailable, build with '-tags matprofile'")
+	} else {
+		fmt.Printf("gocv Mat counts: max live %v, leaked %v\n", r.MaxLiveMats, r.LeakedMats)
+	}
+	status := "OK"
+	if !r.WithinBudget {
+		status = "OVER BUDGET"
+	}
+	fmt.Printf("p99 %v for a budget of %v: %v\n", r.Total.P99, r.Budget, status)
+}
+
+// quietDefaults sets warn log level by default, for commands that print a report
+func quietDefaults(c *config.Config) {
+	c.LogLevel = zapcore.WarnLevel
+}
+
+// loadDetectorConfig returns base config overridden by file at path if not empty: a json detector config if name ends
+// with .json, else a yaml config file with optional profile
+func loadDetectorConfig(path, profile string, base part.DetectorConfig) (part.DetectorConfig, error) {
+	if path == "" {
+		return base, base.Validate()
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		return config.LoadDetector(path, profile, base)
+	}
+	if profile != "" {
+		return base, fmt.Errorf("profile '%v' can't be applied on json detector config %v", profile, path)
+	}
+	doc, err := ioutil.ReadFile(path)
+	if err != nil {
+		return base, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	detector, err := part.ParseDetectorConfig(doc, base)
+	if err != nil {
+		return detector, err
+	}
+	return detector, detector.Validate()
+}
+
+// writeJSON writes v as indented json to path, or stdout if path is '-'
+func writeJSON(path string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal %T: %w", v, err)
+	}
+	content = append(content, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"log"
+	"os"
+)
+
+// runConfig handles config subcommands
+func runConfig(args []string) {
+	if len(args) < 1 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config print [flags]\n\nPrint effective configuration as yaml, resolved from flags:\n", os.Args[0])
+		config.PrintDefaults("config print", os.Stderr)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("config print", args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		log.Fatalf("unable to print configuration: %v", err)
+	}
+}
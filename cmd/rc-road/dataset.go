@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/record"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// encodedFrame is a jpeg or png image not yet decoded
+type encodedFrame struct {
+	name string
+	data []byte
+}
+
+// loadFrames loads images from a directory (jpeg and png files) or from a record file
+func loadFrames(path string) ([]encodedFrame, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dataset %v: %w", path, err)
+	}
+	if info.IsDir() {
+		return loadImagesDir(path)
+	}
+	return loadRecord(path)
+}
+
+func isImageFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+func loadImagesDir(dir string) ([]encodedFrame, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list dataset directory %v: %w", dir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var frames []encodedFrame
+	for _, e := range entries {
+		if e.IsDir() || !isImageFile(e.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read image %v: %w", e.Name(), err)
+		}
+		frames = append(frames, encodedFrame{name: e.Name(), data: data})
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no image found in %v", dir)
+	}
+	return frames, nil
+}
+
+func loadRecord(path string) ([]encodedFrame, error) {
+	reader, err := record.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var frames []encodedFrame
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read record %v: %w", len(frames), err)
+		}
+		var msg events.FrameMessage
+		if err := proto.Unmarshal(rec.Payload, &msg); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal record %v: %w", len(frames), err)
+		}
+		name := msg.GetId().GetId()
+		if name == "" {
+			name = fmt.Sprintf("frame-%d", len(frames))
+		}
+		frames = append(frames, encodedFrame{name: name, data: msg.GetFrame()})
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frame found in %v", path)
+	}
+	return frames, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/eval"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"go.uber.org/zap"
+	"gocv.io/x/gocv"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type evalReport struct {
+	Dataset string              `json:"dataset"`
+	Config  part.DetectorConfig `json:"config"`
+	// LostIoU is the IoU under which road is considered lost
+	LostIoU float64 `json:"lost_iou"`
+	// Unlabeled is the number of dataset images without label, not evaluated
+	Unlabeled int `json:"unlabeled"`
+	eval.Report
+}
+
+// runEval runs detector over a labeled dataset and reports detection quality
+func runEval(args []string) {
+	var dataset, detectorConfig, detectorProfile, jsonOutput string
+	var lostIoU float64
+
+	opts := []config.LoadOption{
+		config.WithDefaults(quietDefaults),
+		config.WithUsage(fmt.Sprintf("Usage: %s eval [flags]\n\n"+
+			"Each dataset image is compared to its label, a json file with road polygon (<image>%s) or a png mask with\n"+
+			"non-zero road pixels (<image>%s).\n", os.Args[0], eval.LabelExt, eval.MaskExt)),
+		config.WithFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(&dataset, "dataset", "", "Directory of labeled jpeg/png images")
+			fs.StringVar(&detectorConfig, "detector-config", "", "Json detector config or yaml config file (ex: tune output), applied on top of detector settings")
+			fs.StringVar(&detectorProfile, "detector-profile", "", "Profile of yaml -detector-config file applied on top of its detector settings")
+			fs.Float64Var(&lostIoU, "lost-iou", 0.1, "Road is lost on images where IoU is under this value")
+			fs.StringVar(&jsonOutput, "json", "", "Write report as json to this file, '-' for stdout")
+		}),
+	}
+	cfg := loadConfig("eval", args, opts...)
+
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
+
+	if dataset == "" {
+		config.PrintDefaults("eval", os.Stderr, opts...)
+		os.Exit(1)
+	}
+	detector, err := loadDetectorConfig(detectorConfig, detectorProfile, cfg.Detector)
+	if err != nil {
+		zap.S().Fatalf("unable to load detector config: %v", err)
+	}
+	report, err := evaluate(dataset, detector, lostIoU)
+	if err != nil {
+		zap.S().Fatalf("unable to evaluate detector: %v", err)
+	}
+
+	if jsonOutput != "" {
+		if err := writeJSON(jsonOutput, report); err != nil {
+			zap.S().Fatalf("unable to write json report: %v", err)
+		}
+	}
+	if jsonOutput != "-" {
+		printEvalReport(report)
+	}
+}
+
+// labeledFrame is a decoded dataset image with its label
+type labeledFrame struct {
+	name  string
+	img   gocv.Mat
+	label *eval.Label
+}
+
+// loadLabeledFrames decodes labeled images of dataset directory and returns the number of unlabeled images
+func loadLabeledFrames(dataset string) ([]labeledFrame, int, error) {
+	frames, err := loadImagesDir(dataset)
+	if err != nil {
+		return nil, 0, err
+	}
+	var labeled []labeledFrame
+	unlabeled := 0
+	for _, f := range frames {
+		if strings.HasSuffix(f.name, eval.MaskExt) {
+			continue
+		}
+		label, err := eval.ReadLabel(filepath.Join(dataset, f.name))
+		if errors.Is(err, eval.ErrNoLabel) {
+			zap.S().Warnf("skip unlabeled image: %v", err)
+			unlabeled++
+			continue
+		}
+		if err != nil {
+			closeLabeledFrames(labeled)
+			return nil, 0, err
+		}
+		img, err := gocv.IMDecode(f.data, gocv.IMReadUnchanged)
+		if err != nil || img.Empty() {
+			_ = img.Close()
+			closeLabeledFrames(labeled)
+			return nil, 0, fmt.Errorf("unable to decode image %v: %v", f.name, err)
+		}
+		labeled = append(labeled, labeledFrame{name: f.name, img: img, label: label})
+	}
+	if len(labeled) == 0 {
+		return nil, 0, fmt.Errorf("no labeled image found in %v", dataset)
+	}
+	return labeled, unlabeled, nil
+}
+
+func closeLabeledFrames(frames []labeledFrame) {
+	for _, f := range frames {
+		if err := f.img.Close(); err != nil {
+			zap.S().Errorf("unable to close image: %v", err)
+		}
+	}
+}
+
+func evaluate(dataset string, detector part.DetectorConfig, lostIoU float64) (*evalReport, error) {
+	frames, unlabeled, err := loadLabeledFrames(dataset)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLabeledFrames(frames)
+
+	results, _, err := evaluateDetector(detector, frames, lostIoU)
+	if err != nil {
+		return nil, err
+	}
+	return &evalReport{
+		Dataset:   dataset,
+		Config:    detector,
+		LostIoU:   lostIoU,
+		Unlabeled: unlabeled,
+		Report:    eval.Summarize(results),
+	}, nil
+}
+
+// evaluateDetector runs a road detector with detector configuration on each frame and returns results and detection
+// durations
+func evaluateDetector(detector part.DetectorConfig, frames []labeledFrame, lostIoU float64) ([]eval.Result, []time.Duration, error) {
+	rd := part.NewRoadDetectorWithConfig(detector)
+	defer func() {
+		if err := rd.Close(); err != nil {
+			zap.S().Errorf("unable to close road detector: %v", err)
+		}
+	}()
+
+	results := make([]eval.Result, 0, len(frames))
+	durations := make([]time.Duration, 0, len(frames))
+	for _, f := range frames {
+		start := time.Now()
+		detection := rd.Detect(f.img, nil)
+		durations = append(durations, time.Since(start))
+
+		detected := eval.Detected{Road: detection.Road.ToPoints()}
+		if e := detection.Ellipse; e != nil && e.GetCenter() != nil {
+			detected.EllipseCenter = &image.Point{X: int(e.GetCenter().GetX()), Y: int(e.GetCenter().GetY())}
+		}
+		if err := detection.Close(); err != nil {
+			zap.S().Errorf("unable to close detection: %v", err)
+		}
+
+		result, err := eval.Evaluate(f.name, f.label, image.Rect(0, 0, f.img.Cols(), f.img.Rows()), detected, lostIoU)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to evaluate %v: %w", f.name, err)
+		}
+		results = append(results, result)
+	}
+	return results, durations, nil
+}
+
+func printEvalReport(r *evalReport) {
+	fmt.Printf("dataset: %v, %v labeled images, %v unlabeled\n\n", r.Dataset, r.Images, r.Unlabeled)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "image\tiou\tprecision\trecall\tcenter error\troad lost\t")
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "%v\t%.3f\t%.3f\t%.3f\t%v\t%v\t\n", res.Name, res.IoU, res.Precision, res.Recall,
+			formatCenterError(res.CenterError), res.RoadLost)
+	}
+	_ = w.Flush()
+
+	fmt.Printf("\niou: %.3f (mean by image %.3f)\n", r.IoU, r.MeanIoU)
+	fmt.Printf("precision: %.3f, recall: %.3f\n", r.Precision, r.Recall)
+	fmt.Printf("mean ellipse center error: %v\n", formatCenterError(r.MeanCenterError))
+	fmt.Printf("road lost (iou < %v): %v/%v images (%.1f%%)\n", r.LostIoU, r.RoadLost, r.Images, 100*r.RoadLostRate)
+}
+
+func formatCenterError(e float64) string {
+	if e < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fpx", e)
+}
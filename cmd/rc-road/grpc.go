@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/rpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+)
+
+// grpcDetector converts RoadPart errors to grpc status
+type grpcDetector struct {
+	*part.RoadPart
+}
+
+func (d grpcDetector) DetectRoad(frame *events.FrameMessage) (*events.RoadMessage, error) {
+	road, err := d.RoadPart.DetectRoad(frame)
+	switch {
+	case err == nil:
+		return road, nil
+	case errors.Is(err, part.ErrStopped):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, part.ErrInvalidImage):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return nil, err
+	}
+}
+
+// serveGRPC starts a grpc server exposing road detection on addr, and returns function to stop it
+func serveGRPC(addr string, p *part.RoadPart) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := grpc.NewServer()
+	rpc.NewServer(grpcDetector{p}).Register(srv)
+	go func() {
+		zap.S().Infof("serve grpc %v service on %v", rpc.ServiceName, listener.Addr())
+		if err := srv.Serve(listener); err != nil {
+			zap.S().Errorf("grpc server stopped: %v", err)
+		}
+	}()
+	// Streams may never end, don't wait for them
+	return srv.Stop, nil
+}
@@ -1,72 +1,250 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"github.com/cyrilix/robocar-base/cli"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/diag"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"github.com/cyrilix/robocar-road/pkg/mjpeg"
 	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/telemetry"
+	"github.com/cyrilix/robocar-road/pkg/transport"
+	"github.com/cyrilix/robocar-road/pkg/webui"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-const (
-	DefaultClientId = "robocar-road"
-	DefaultHorizon  = 20
-)
+const DefaultClientId = config.DefaultClientId
 
 func main() {
-	var mqttBroker, username, password, clientId string
-	var cameraTopic, roadTopic string
-	var horizon int
-
-	err := cli.SetIntDefaultValueFromEnv(&horizon, "HORIZON", DefaultHorizon)
-	if err != nil {
-		log.Printf("unable to parse horizon value arg: %v", err)
+	if len(os.Args) <= 1 {
+		config.PrintDefaults(os.Args[0], os.Stderr)
+		os.Exit(1)
+	}
+	switch os.Args[1] {
+	case "record":
+		runRecord(os.Args[2:])
+		return
+	case "replay":
+		runReplay(os.Args[2:])
+		return
+	case "bench":
+		runBench(os.Args[2:])
+		return
+	case "eval":
+		runEval(os.Args[2:])
+		return
+	case "tune":
+		runTune(os.Args[2:])
+		return
+	case "config":
+		runConfig(os.Args[2:])
+		return
 	}
 
-	mqttQos := cli.InitIntFlag("MQTT_QOS", 0)
-	_, mqttRetain := os.LookupEnv("MQTT_RETAIN")
-
-	cli.InitMqttFlags(DefaultClientId, &mqttBroker, &username, &password, &clientId, &mqttQos, &mqttRetain)
+	cfg := loadConfig(os.Args[0], os.Args[1:])
 
-	flag.StringVar(&roadTopic, "mqtt-topic-road", os.Getenv("MQTT_TOPIC_ROAD"), "Mqtt topic to publish road detection result, use MQTT_TOPIC_ROAD if args not set")
-	flag.StringVar(&cameraTopic, "mqtt-topic-camera", os.Getenv("MQTT_TOPIC_CAMERA"), "Mqtt topic that contains camera frame values, use MQTT_TOPIC_CAMERA if args not set")
-	flag.IntVar(&horizon, "horizon", horizon, "Limit horizon in pixels from top, use HORIZON if args not set")
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
 
-	logLevel := zap.LevelFlag("log", zap.InfoLevel, "log level")
-	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if len(os.Args) <= 1 {
-		flag.PrintDefaults()
-		os.Exit(1)
+	tracing := cfg.Tracing
+	if tracing.ServiceName == "" {
+		tracing.ServiceName = cfg.MQTT.ClientId
 	}
-
-	config := zap.NewDevelopmentConfig()
-	config.Level = zap.NewAtomicLevelAt(*logLevel)
-	lgr, err := config.Build()
+	shutdownTracing, err := telemetry.Setup(ctx, tracing)
 	if err != nil {
-		log.Fatalf("unable to init logger: %v", err)
+		zap.S().Fatalf("unable to setup tracing: %v", err)
 	}
 	defer func() {
-		if err := lgr.Sync(); err != nil {
-			log.Printf("unable to Sync logger: %v\n", err)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			zap.S().Errorf("unable to flush spans: %v", err)
 		}
 	}()
-	zap.ReplaceGlobals(lgr)
 
-	client, err := cli.Connect(mqttBroker, username, password, clientId)
+	client, err := cli.Connect(cfg.MQTT.Broker, cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ClientId)
 	if err != nil {
 		zap.S().Fatalf("unable to connect to mqtt bus: %v", err)
 	}
-	defer client.Disconnect(50)
+	t := transport.NewMQTT(client, byte(cfg.MQTT.Qos), cfg.MQTT.Retain)
+	defer func() {
+		if err := t.Close(); err != nil {
+			zap.S().Errorf("unable to close transport: %v", err)
+		}
+	}()
+
+	opts := []part.Option{
+		part.WithDetectorConfig(cfg.Detector),
+		part.WithClockSkewThresholds(cfg.Frames.MaxClockSkew, cfg.Frames.MaxLatency),
+		part.WithFramePolicy(part.FramePolicy{MaxAge: cfg.Frames.MaxAge, RejectOutOfOrder: cfg.Frames.RejectOutOfOrder}),
+	}
+	var imgStream, maskStream *mjpeg.Stream
+	if cfg.Topics.DriveMode != "" {
+		policy, err := part.ParseProcessingPolicy(cfg.Processing.DriveMode, cfg.Processing.ReducedRate)
+		if err != nil {
+			zap.S().Fatalf("invalid drive mode processing: %v", err)
+		}
+		opts = append(opts, part.WithDriveModeProcessing(cfg.Topics.DriveMode, policy))
+	}
+	if cfg.Topics.RoadJSON != "" {
+		opts = append(opts, part.WithJSONTopic(cfg.Topics.RoadJSON))
+	}
+	if cfg.Topics.Config != "" {
+		opts = append(opts, part.WithConfigTopic(cfg.Topics.Config, cfg.Topics.ConfigAck))
+	}
+	if cfg.Topics.Waypoints != "" {
+		extractor, err := lane.NewExtractor(cfg.Lane)
+		if err != nil {
+			zap.S().Fatalf("invalid lane config: %v", err)
+		}
+		opts = append(opts, part.WithWaypointsTopic(cfg.Topics.Waypoints, extractor))
+	}
+	if cfg.Topics.Pose != "" {
+		opts = append(opts, part.WithPoseTopic(cfg.Topics.Pose, cfg.Lane.Homography))
+	}
+	var stageStreams map[part.Stage]*mjpeg.Stream
+	if cfg.Server.HTTPAddr != "" {
+		imgStream = mjpeg.NewStream()
+		maskStream = mjpeg.NewStream()
+		opts = append(opts, part.WithDebugStreams(imgStream, maskStream))
+		if cfg.Server.UI {
+			stageStreams = map[part.Stage]*mjpeg.Stream{
+				part.StageGray:       mjpeg.NewStream(),
+				part.StageMorphology: mjpeg.NewStream(),
+				part.StageThreshold:  mjpeg.NewStream(),
+			}
+			opts = append(opts, part.WithStageStreams(stageStreams))
+		}
+	} else if cfg.Server.UI {
+		zap.S().Warnf("tuning page disabled, http server is not configured")
+	}
+
+	if cfg.Diagnostic.File != "" {
+		sink, err := diag.NewSink(cfg.Diagnostic.File, diag.WithMaxSize(int64(cfg.Diagnostic.MaxSize)<<20), diag.WithMaxBackups(cfg.Diagnostic.MaxBackups))
+		if err != nil {
+			zap.S().Fatalf("unable to open diagnostic file: %v", err)
+		}
+		// Closed after part stop to write diagnostics of in-flight frames
+		defer func() {
+			if err := sink.Close(); err != nil {
+				zap.S().Errorf("unable to close diagnostic file: %v", err)
+			}
+			if dropped := sink.Dropped(); dropped > 0 {
+				zap.S().Warnf("%v frame diagnostics dropped", dropped)
+			}
+		}()
+		opts = append(opts, part.WithDiagnosticSink(sink))
+	}
 
-	p := part.NewRoadPart(client, horizon, cameraTopic, roadTopic)
+	p := part.NewRoadPart(t, cfg.Detector.Horizon, cfg.Topics.Camera, cfg.Topics.Road, opts...)
 	defer p.Stop()
 
-	cli.HandleExit(p)
+	if cfg.Server.HTTPAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/stream/road", imgStream)
+		mux.Handle("/stream/mask", maskStream)
+		mux.Handle("/detect", p.DetectHandler())
+		mux.Handle("/debug/vars", expvar.Handler())
+		for stage, stream := range stageStreams {
+			mux.Handle(stageStreamURL(stage), stream)
+		}
+		if cfg.Server.UI {
+			mux.Handle("/ui/", http.StripPrefix("/ui", tuningHandler(p, stageStreams)))
+		}
+		srv := &http.Server{Addr: cfg.Server.HTTPAddr, Handler: mux}
+		go func() {
+			zap.S().Infof("serve debug streams on http://%s/stream/road and http://%s/stream/mask", cfg.Server.HTTPAddr, cfg.Server.HTTPAddr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				zap.S().Errorf("http server stopped: %v", err)
+			}
+		}()
+		defer func() {
+			// Stream connections never end, don't wait for them
+			if err := srv.Close(); err != nil {
+				zap.S().Errorf("unable to close http server: %v", err)
+			}
+		}()
+	}
+
+	if cfg.Server.GRPCAddr != "" {
+		stopGRPC, err := serveGRPC(cfg.Server.GRPCAddr, p)
+		if err != nil {
+			zap.S().Fatalf("unable to start grpc server: %v", err)
+		}
+		defer stopGRPC()
+	}
 
-	err = p.Start()
+	err = p.Start(ctx)
 	if err != nil {
 		zap.S().Fatalf("unable to start service: %v", err)
 	}
 }
+
+// loadConfig resolves configuration of a command from its args and environment, it exits if help is requested or
+// configuration is invalid
+func loadConfig(name string, args []string, opts ...config.LoadOption) *config.Config {
+	cfg, err := config.Load(name, args, os.LookupEnv, opts...)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	return cfg
+}
+
+// tuningHandler returns the tuning web page handler of p, that shows debug and stage streams. Settings are
+// saved to the config file, in the selected profile if any.
+func tuningHandler(p *part.RoadPart, stageStreams map[part.Stage]*mjpeg.Stream) http.Handler {
+	streams := []webui.Stream{{Name: "road", URL: "/stream/road"}}
+	for _, stage := range part.Stages {
+		if _, ok := stageStreams[stage]; ok {
+			streams = append(streams, webui.Stream{Name: string(stage), URL: stageStreamURL(stage)})
+		}
+	}
+	streams = append(streams, webui.Stream{Name: "mask", URL: "/stream/mask"})
+
+	// Args have already been validated by config loading
+	configFile, profile, _ := config.Sources(os.Args[0], os.Args[1:], os.LookupEnv)
+	save := func(detector part.DetectorConfig) error {
+		if configFile == "" {
+			return webui.ErrSaveDisabled
+		}
+		return config.SaveDetector(configFile, profile, detector)
+	}
+	return webui.NewHandler(p, save, streams)
+}
+
+func stageStreamURL(stage part.Stage) string {
+	return "/stream/stage/" + string(stage)
+}
+
+// initLogger configures global zap logger and returns function to flush it
+func initLogger(logLevel zapcore.Level) func() {
+	config := zap.NewDevelopmentConfig()
+	config.Level = zap.NewAtomicLevelAt(logLevel)
+	lgr, err := config.Build()
+	if err != nil {
+		log.Fatalf("unable to init logger: %v", err)
+	}
+	zap.ReplaceGlobals(lgr)
+	return func() {
+		if err := lgr.Sync(); err != nil {
+			log.Printf("unable to Sync logger: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/cyrilix/robocar-base/cli"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/record"
+	"github.com/cyrilix/robocar-road/pkg/transport"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runRecord appends camera frames received on mqtt to a record file
+func runRecord(args []string) {
+	var output string
+	var duration time.Duration
+
+	cfg := loadConfig("record", args,
+		config.WithDefaults(func(c *config.Config) {
+			c.MQTT.ClientId = DefaultClientId + "-record"
+		}),
+		config.WithFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "output", "frames.rec", "Record file, frames are appended if file already exists")
+			fs.DurationVar(&duration, "duration", 0, "Stop recording after this duration, record until interrupted if 0")
+		}),
+	)
+	cameraTopic := cfg.Topics.Camera
+
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
+
+	if cameraTopic == "" {
+		zap.S().Fatalf("no camera topic to record")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	w, err := record.OpenFile(output)
+	if err != nil {
+		zap.S().Fatalf("unable to open record file: %v", err)
+	}
+
+	client, err := cli.Connect(cfg.MQTT.Broker, cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ClientId)
+	if err != nil {
+		zap.S().Fatalf("unable to connect to mqtt bus: %v", err)
+	}
+	t := transport.NewMQTT(client, byte(cfg.MQTT.Qos), cfg.MQTT.Retain)
+
+	var mu sync.Mutex
+	count := 0
+	err = t.Subscribe(cameraTopic, func(_ string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := w.Write(record.Record{ReceivedAt: time.Now(), Payload: payload}); err != nil {
+			zap.S().Errorf("unable to record frame: %v", err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			zap.S().Errorf("unable to flush record file: %v", err)
+			return
+		}
+		count++
+	})
+	if err != nil {
+		_ = t.Close()
+		zap.S().Fatalf("unable to subscribe to camera topic: %v", err)
+	}
+	zap.S().Infof("record frames from topic %v to %v", cameraTopic, output)
+
+	<-ctx.Done()
+
+	if err := t.Close(); err != nil {
+		zap.S().Errorf("unable to close transport: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := w.Close(); err != nil {
+		zap.S().Errorf("unable to close record file: %v", err)
+	}
+	zap.S().Infof("%v frames recorded to %v", count, output)
+}
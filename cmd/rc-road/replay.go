@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-base/cli"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/record"
+	"github.com/cyrilix/robocar-road/pkg/transport"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	ReplayModeLocal  = "local"
+	ReplayModeBroker = "broker"
+)
+
+// runReplay feeds frames from a record file to a local RoadPart or republishes them to camera topic
+func runReplay(args []string) {
+	var input, mode string
+	var speed float64
+	var step, retime bool
+
+	// Road topic is used to publish results in local mode, camera topic to republish frames in broker mode
+	cfg := loadConfig("replay", args,
+		config.WithDefaults(func(c *config.Config) {
+			c.MQTT.ClientId = DefaultClientId + "-replay"
+		}),
+		config.WithFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(&input, "input", "frames.rec", "Record file to replay")
+			fs.StringVar(&mode, "mode", ReplayModeLocal, "Replay mode: 'local' to process frames with an embedded road detector, 'broker' to republish frames to camera topic")
+			fs.Float64Var(&speed, "speed", 1., "Replay speed factor relative to recording (2 replays twice faster), 0 to replay as fast as possible")
+			fs.BoolVar(&step, "step", false, "Replay step by step, wait for 'Enter' key before each frame")
+			fs.BoolVar(&retime, "retime", false, "Replace frames creation date with replay date, to replay to a service that rejects stale frames")
+		}),
+	)
+	cameraTopic := cfg.Topics.Camera
+
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
+
+	if speed < 0 {
+		zap.S().Fatalf("invalid speed %v, should be >= 0", speed)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reader, err := record.Open(input)
+	if err != nil {
+		zap.S().Fatalf("unable to open record: %v", err)
+	}
+	defer reader.Close()
+
+	client, err := cli.Connect(cfg.MQTT.Broker, cfg.MQTT.Username, cfg.MQTT.Password, cfg.MQTT.ClientId)
+	if err != nil {
+		zap.S().Fatalf("unable to connect to mqtt bus: %v", err)
+	}
+	t := transport.NewMQTT(client, byte(cfg.MQTT.Qos), cfg.MQTT.Retain)
+	defer func() {
+		if err := t.Close(); err != nil {
+			zap.S().Errorf("unable to close transport: %v", err)
+		}
+	}()
+
+	var feed func(payload []byte)
+	switch mode {
+	case ReplayModeLocal:
+		// No camera topic: frames only come from record, one after the other to get deterministic output
+		p := part.NewRoadPart(t, cfg.Detector.Horizon, "", cfg.Topics.Road, part.WithDetectorConfig(cfg.Detector), part.WithSequentialProcessing())
+		defer p.Stop()
+		go func() {
+			if err := p.Start(ctx); err != nil {
+				zap.S().Errorf("unable to start road part: %v", err)
+			}
+		}()
+		feed = func(payload []byte) {
+			p.OnFrame(cameraTopic, payload)
+		}
+	case ReplayModeBroker:
+		if cameraTopic == "" {
+			zap.S().Fatalf("no camera topic to republish frames")
+		}
+		feed = func(payload []byte) {
+			if err := t.Publish(cameraTopic, payload); err != nil {
+				zap.S().Errorf("unable to publish frame: %v", err)
+			}
+		}
+	default:
+		zap.S().Fatalf("invalid replay mode '%v', should be '%v' or '%v'", mode, ReplayModeLocal, ReplayModeBroker)
+	}
+
+	if retime {
+		feed = retimed(feed, time.Now)
+	}
+
+	count, err := replay(ctx, reader, feed, speed, step, os.Stdin)
+	if err != nil {
+		zap.S().Errorf("replay interrupted: %v", err)
+	}
+	zap.S().Infof("%v frames replayed from %v", count, input)
+}
+
+// replay calls feed for each record, respecting recording pace divided by speed factor, or waiting for a new line
+// on stepInput in step by step mode
+func replay(ctx context.Context, reader *record.Reader, feed func(payload []byte), speed float64, step bool, stepInput io.Reader) (int, error) {
+	stepScanner := bufio.NewScanner(stepInput)
+	var previous time.Time
+	count := 0
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("unable to read record %v: %w", count, err)
+		}
+
+		if step {
+			fmt.Printf("frame %v received at %v, press Enter to replay", count, rec.ReceivedAt.Format(time.RFC3339Nano))
+			if !stepScanner.Scan() {
+				return count, nil
+			}
+		} else if speed > 0 && !previous.IsZero() {
+			delay := time.Duration(float64(rec.ReceivedAt.Sub(previous)) / speed)
+			select {
+			case <-ctx.Done():
+				return count, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+
+		previous = rec.ReceivedAt
+		feed(rec.Payload)
+		count++
+	}
+}
+
+// retimed wraps feed to replace frames creation date by now()
+func retimed(feed func(payload []byte), now func() time.Time) func(payload []byte) {
+	return func(payload []byte) {
+		var frame events.FrameMessage
+		if err := proto.Unmarshal(payload, &frame); err != nil {
+			zap.S().Errorf("unable to unmarshal %T message, replay it as is: %v", &frame, err)
+			feed(payload)
+			return
+		}
+		if frame.Id == nil {
+			frame.Id = &events.FrameRef{}
+		}
+		frame.Id.CreatedAt = timestamppb.New(now())
+		retimedPayload, err := proto.Marshal(&frame)
+		if err != nil {
+			zap.S().Errorf("unable to marshal %T message, replay it as is: %v", &frame, err)
+			feed(payload)
+			return
+		}
+		feed(retimedPayload)
+	}
+}
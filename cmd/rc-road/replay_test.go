@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/record"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRecordReader returns a reader of records with payloads, received every interval
+func newRecordReader(t *testing.T, interval time.Duration, payloads ...string) *record.Reader {
+	var buf bytes.Buffer
+	w, err := record.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unable to create record writer: %v", err)
+	}
+	receivedAt := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, p := range payloads {
+		if err := w.Write(record.Record{ReceivedAt: receivedAt, Payload: []byte(p)}); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+		receivedAt = receivedAt.Add(interval)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unable to flush records: %v", err)
+	}
+	r, err := record.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unable to create record reader: %v", err)
+	}
+	return r
+}
+
+func TestReplay(t *testing.T) {
+	cases := []struct {
+		name        string
+		interval    time.Duration
+		speed       float64
+		step        bool
+		stepInput   string
+		expected    []string
+		minDuration time.Duration
+		maxDuration time.Duration
+	}{
+		{name: "recording pace", interval: 50 * time.Millisecond, speed: 1.,
+			expected: []string{"a", "b", "c"}, minDuration: 100 * time.Millisecond, maxDuration: time.Second},
+		{name: "faster", interval: 400 * time.Millisecond, speed: 4.,
+			expected: []string{"a", "b", "c"}, minDuration: 200 * time.Millisecond, maxDuration: 800 * time.Millisecond},
+		{name: "as fast as possible", interval: time.Hour, speed: 0.,
+			expected: []string{"a", "b", "c"}, maxDuration: time.Second},
+		{name: "step by step ignores pace", interval: time.Hour, speed: 1., step: true, stepInput: "\n\n\n",
+			expected: []string{"a", "b", "c"}, maxDuration: time.Second},
+		{name: "step input closed", interval: time.Hour, speed: 1., step: true, stepInput: "\n",
+			expected: []string{"a"}, maxDuration: time.Second},
+	}
+
+	for _, c := range cases {
+		reader := newRecordReader(t, c.interval, "a", "b", "c")
+		var fed []string
+		feed := func(payload []byte) {
+			fed = append(fed, string(payload))
+		}
+
+		start := time.Now()
+		count, err := replay(context.Background(), reader, feed, c.speed, c.step, strings.NewReader(c.stepInput))
+		duration := time.Since(start)
+		if err != nil {
+			t.Errorf("[%v] unable to replay: %v", c.name, err)
+			continue
+		}
+		if count != len(c.expected) || strings.Join(fed, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("[%v] bad frames replayed: %v (count %v), wants %v", c.name, fed, count, c.expected)
+		}
+		if duration < c.minDuration || duration > c.maxDuration {
+			t.Errorf("[%v] bad replay duration: %v, wants between %v and %v", c.name, duration, c.minDuration, c.maxDuration)
+		}
+	}
+}
+
+func TestReplay_Cancel(t *testing.T) {
+	reader := newRecordReader(t, time.Hour, "a", "b", "c")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fed []string
+	feed := func(payload []byte) {
+		fed = append(fed, string(payload))
+		cancel()
+	}
+
+	done := make(chan struct{})
+	var count int
+	var err error
+	go func() {
+		defer close(done)
+		count, err = replay(ctx, reader, feed, 1., false, strings.NewReader(""))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("replay not interrupted by context cancellation")
+	}
+	if err != context.Canceled {
+		t.Errorf("bad error: %v, wants %v", err, context.Canceled)
+	}
+	if count != 1 || len(fed) != 1 {
+		t.Errorf("bad frames replayed: %v (count %v), wants only first one", fed, count)
+	}
+}
+
+func TestRetimed(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 30, 0, 0, time.UTC)
+	marshal := func(frame *events.FrameMessage) []byte {
+		payload, err := proto.Marshal(frame)
+		if err != nil {
+			t.Fatalf("unable to marshal frame: %v", err)
+		}
+		return payload
+	}
+
+	cases := []struct {
+		name      string
+		payload   []byte
+		wantFrame *events.FrameMessage
+	}{
+		{name: "creation date replaced",
+			payload: marshal(&events.FrameMessage{
+				Id:    &events.FrameRef{Name: "camera", Id: "42", CreatedAt: timestamppb.New(now.Add(-time.Hour))},
+				Frame: []byte("jpeg"),
+			}),
+			wantFrame: &events.FrameMessage{
+				Id:    &events.FrameRef{Name: "camera", Id: "42", CreatedAt: timestamppb.New(now)},
+				Frame: []byte("jpeg"),
+			}},
+		{name: "missing frame ref",
+			payload: marshal(&events.FrameMessage{Frame: []byte("jpeg")}),
+			wantFrame: &events.FrameMessage{
+				Id:    &events.FrameRef{CreatedAt: timestamppb.New(now)},
+				Frame: []byte("jpeg"),
+			}},
+		{name: "invalid payload replayed as is", payload: []byte{0xff, 0xff}},
+	}
+
+	for _, c := range cases {
+		var fed [][]byte
+		feed := retimed(func(payload []byte) {
+			fed = append(fed, payload)
+		}, func() time.Time { return now })
+		feed(c.payload)
+
+		if len(fed) != 1 {
+			t.Errorf("[%v] bad number of frames fed: %v, wants 1", c.name, len(fed))
+			continue
+		}
+		if c.wantFrame == nil {
+			if !bytes.Equal(fed[0], c.payload) {
+				t.Errorf("[%v] payload modified: %v, wants %v", c.name, fed[0], c.payload)
+			}
+			continue
+		}
+		var frame events.FrameMessage
+		if err := proto.Unmarshal(fed[0], &frame); err != nil {
+			t.Errorf("[%v] invalid retimed payload: %v", c.name, err)
+			continue
+		}
+		if !proto.Equal(&frame, c.wantFrame) {
+			t.Errorf("[%v] bad retimed frame: %v, wants %v", c.name, &frame, c.wantFrame)
+		}
+	}
+}
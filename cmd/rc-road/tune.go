@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/config"
+	"github.com/cyrilix/robocar-road/pkg/eval"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/stats"
+	"github.com/cyrilix/robocar-road/pkg/tune"
+	"go.uber.org/zap"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	tuneGrid    = "grid"
+	tuneRandom  = "random"
+	tuneDescent = "descent"
+)
+
+// defaultTuneParams are explored when no -param flag is given
+var defaultTuneParams = []string{
+	"horizon=10:40:10",
+	"threshold=140:220:20",
+	"kernel_size=2:8:2",
+	"morpho_iterations=1:4:1",
+	"approx_poly_epsilon_factor=0.005:0.03:0.005",
+}
+
+// paramsFlag collects repeated -param flags
+type paramsFlag []tune.Param
+
+func (p *paramsFlag) String() string {
+	values := make([]string, 0, len(*p))
+	for _, param := range *p {
+		values = append(values, param.String())
+	}
+	return strings.Join(values, " ")
+}
+
+func (p *paramsFlag) Set(value string) error {
+	param, err := tune.ParseParam(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, param)
+	return nil
+}
+
+type tuneReport struct {
+	Dataset   string              `json:"dataset"`
+	Method    string              `json:"method"`
+	Params    []string            `json:"params"`
+	Best      part.DetectorConfig `json:"best"`
+	Trials    []tune.Trial        `json:"trials"`
+	Unlabeled int                 `json:"unlabeled"`
+}
+
+// runTune searches detector parameters that maximize IoU on a labeled dataset
+func runTune(args []string) {
+	var dataset, detectorConfig, detectorProfile, method, output, jsonOutput string
+	var trials, rounds, maxTrials, top int
+	var seed int64
+	var latencyWeight float64
+	var refine bool
+	var params paramsFlag
+
+	opts := []config.LoadOption{
+		config.WithDefaults(quietDefaults),
+		config.WithFlags(func(fs *flag.FlagSet) {
+			// Args are parsed twice, don't collect params of the first pass
+			params = nil
+			fs.StringVar(&dataset, "dataset", "", "Directory of labeled jpeg/png images, see eval command")
+			fs.StringVar(&detectorConfig, "detector-config", "", "Json detector config or yaml config file (ex: a previous tune output), applied on top of detector settings. Starting point of search")
+			fs.StringVar(&detectorProfile, "detector-profile", "", "Profile of yaml -detector-config file applied on top of its detector settings")
+			fs.Var(&params, "param", fmt.Sprintf("Parameter range to explore as name=min:max:step, can be repeated (default %v)", strings.Join(defaultTuneParams, " ")))
+			fs.StringVar(&method, "method", tuneRandom, fmt.Sprintf("Search method: %v (all combinations), %v or %v (one parameter at a time)", tuneGrid, tuneRandom, tuneDescent))
+			fs.IntVar(&trials, "trials", 50, "Number of random configurations")
+			fs.Int64Var(&seed, "seed", 1, "Seed of random search")
+			fs.BoolVar(&refine, "refine", false, "Run coordinate descent from best configuration of grid or random search")
+			fs.IntVar(&rounds, "rounds", 5, "Max rounds over all parameters of coordinate descent")
+			fs.IntVar(&maxTrials, "max-trials", tune.DefaultMaxTrials, "Max number of configurations of grid search")
+			fs.Float64Var(&latencyWeight, "latency-weight", tune.DefaultLatencyWeight, "IoU loss equivalent to 1ms of mean detection latency when ranking configurations")
+			fs.StringVar(&output, "output", "rc-road-tuned.yaml", "Write best configuration to this file, as yaml config file (see -config flag) or as json detector config if name ends with .json")
+			fs.IntVar(&top, "top", 10, "Number of best configurations to display")
+			fs.StringVar(&jsonOutput, "json", "", "Write ranked configurations as json to this file, '-' for stdout")
+		}),
+	}
+	cfg := loadConfig("tune", args, opts...)
+
+	syncLogger := initLogger(cfg.LogLevel)
+	defer syncLogger()
+
+	if dataset == "" {
+		config.PrintDefaults("tune", os.Stderr, opts...)
+		os.Exit(1)
+	}
+	if trials < 1 {
+		zap.S().Fatalf("invalid number of trials %v, should be >= 1", trials)
+	}
+	if len(params) == 0 {
+		for _, p := range defaultTuneParams {
+			_ = params.Set(p)
+		}
+	}
+	base, err := loadDetectorConfig(detectorConfig, detectorProfile, cfg.Detector)
+	if err != nil {
+		zap.S().Fatalf("unable to load detector config: %v", err)
+	}
+	start, err := startPoint(base, params)
+	if err != nil {
+		zap.S().Fatalf("invalid parameters: %v", err)
+	}
+
+	frames, unlabeled, err := loadLabeledFrames(dataset)
+	if err != nil {
+		zap.S().Fatalf("unable to load dataset: %v", err)
+	}
+	defer closeLabeledFrames(frames)
+
+	objective := func(point tune.Point) (tune.Score, error) {
+		detector, err := applyPoint(base, point)
+		if err != nil {
+			return tune.Score{}, err
+		}
+		results, durations, err := evaluateDetector(detector, frames, 0)
+		if err != nil {
+			return tune.Score{}, err
+		}
+		score := tune.Score{IoU: eval.Summarize(results).IoU, Latency: stats.Summarize(durations).Mean}
+		zap.S().Infof("%v: iou %.3f, latency %v", point, score.IoU, score.Latency)
+		return score, nil
+	}
+	tuner := tune.NewTuner(params, objective, tune.WithLatencyWeight(latencyWeight), tune.WithMaxTrials(maxTrials))
+
+	switch method {
+	case tuneGrid:
+		err = tuner.Grid()
+	case tuneRandom:
+		err = tuner.Random(trials, rand.New(rand.NewSource(seed)))
+	case tuneDescent:
+		_, err = tuner.CoordinateDescent(start, rounds)
+	default:
+		zap.S().Fatalf("unknown search method '%v', should be one of %v, %v, %v", method, tuneGrid, tuneRandom, tuneDescent)
+	}
+	if err == nil && refine && method != tuneDescent {
+		_, err = tuner.CoordinateDescent(tuner.Ranked()[0].Point, rounds)
+	}
+	if err != nil {
+		zap.S().Fatalf("unable to tune detector: %v", err)
+	}
+
+	ranked := tuner.Ranked()
+	best, err := applyPoint(base, ranked[0].Point)
+	if err != nil {
+		zap.S().Fatalf("invalid best configuration: %v", err)
+	}
+	if err := writeTunedConfig(output, best, dataset, ranked[0]); err != nil {
+		zap.S().Fatalf("unable to write best configuration: %v", err)
+	}
+
+	if jsonOutput != "" {
+		report := tuneReport{Dataset: dataset, Method: method, Best: best, Trials: ranked, Unlabeled: unlabeled}
+		for _, p := range params {
+			report.Params = append(report.Params, p.String())
+		}
+		if err := writeJSON(jsonOutput, &report); err != nil {
+			zap.S().Fatalf("unable to write json report: %v", err)
+		}
+	}
+	if jsonOutput != "-" {
+		printTuneReport(dataset, len(frames), ranked, top)
+		fmt.Printf("\nbest configuration written to %v\n", output)
+	}
+}
+
+// startPoint returns values of params in base config
+func startPoint(base part.DetectorConfig, params []tune.Param) (tune.Point, error) {
+	doc, err := json.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal config: %w", err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(doc, &values); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal config: %w", err)
+	}
+	point := make(tune.Point, len(params))
+	for _, p := range params {
+		v, ok := values[p.Name].(float64)
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter '%v'", p.Name)
+		}
+		point[p.Name] = v
+	}
+	return point, nil
+}
+
+// applyPoint returns base config with values of point
+func applyPoint(base part.DetectorConfig, point tune.Point) (part.DetectorConfig, error) {
+	doc, err := json.Marshal(point)
+	if err != nil {
+		return base, fmt.Errorf("unable to marshal %v: %w", point, err)
+	}
+	detector, err := part.ParseDetectorConfig(doc, base)
+	if err != nil {
+		return base, err
+	}
+	return detector, detector.Validate()
+}
+
+// writeTunedConfig writes detector settings as a yaml config file, or as json detector config if path ends with .json
+func writeTunedConfig(path string, detector part.DetectorConfig, dataset string, trial tune.Trial) error {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return writeJSON(path, &detector)
+	}
+	comment := fmt.Sprintf("Tuned on %v: iou %.3f, mean latency %v", dataset, trial.IoU, trial.Latency)
+	return config.WriteDetector(path, comment, detector)
+}
+
+func printTuneReport(dataset string, frames int, ranked []tune.Trial, top int) {
+	fmt.Printf("dataset: %v, %v labeled images, %v configurations\n\n", dataset, frames, len(ranked))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "rank\tvalue\tiou\tlatency\tparams")
+	for i, trial := range ranked {
+		if i >= top {
+			break
+		}
+		fmt.Fprintf(w, "%v\t%.4f\t%.3f\t%v\t%v\n", i+1, trial.Value, trial.IoU, trial.Latency, trial.Point)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,532 @@
+// Package config resolves rc-road settings from built-in defaults, a yaml file, named profiles, environment
+// variables and command line flags.
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/diag"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"github.com/cyrilix/robocar-road/pkg/telemetry"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultClientId    = "robocar-road"
+	DefaultBroker      = "tcp://127.0.0.1:1883"
+	DefaultReducedRate = 5
+
+	// EnvConfigFile is the environment variable of config file path, when -config flag is not set
+	EnvConfigFile = "CONFIG_FILE"
+	// EnvProfile is the environment variable of profile name, when -profile flag is not set
+	EnvProfile = "CONFIG_PROFILE"
+)
+
+// Config gathers all rc-road settings
+type Config struct {
+	MQTT       MQTT                `yaml:"mqtt"`
+	Topics     Topics              `yaml:"topics"`
+	Detector   part.DetectorConfig `yaml:"detector"`
+	Lane       lane.Config         `yaml:"lane"`
+	Processing Processing          `yaml:"processing"`
+	Frames     Frames              `yaml:"frames"`
+	Server     Server              `yaml:"server"`
+	Tracing    telemetry.Config    `yaml:"tracing"`
+	Diagnostic Diagnostic          `yaml:"diagnostic"`
+	LogLevel   zapcore.Level       `yaml:"log_level"`
+}
+
+// MQTT defines broker connection
+type MQTT struct {
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientId string `yaml:"client_id"`
+	Qos      int    `yaml:"qos"`
+	Retain   bool   `yaml:"retain"`
+}
+
+// Topics are mqtt topics listened or published, empty optional topics disable related feature
+type Topics struct {
+	Camera    string `yaml:"camera"`
+	Road      string `yaml:"road"`
+	RoadJSON  string `yaml:"road_json"`
+	DriveMode string `yaml:"drive_mode"`
+	Config    string `yaml:"config"`
+	ConfigAck string `yaml:"config_ack"`
+	Waypoints string `yaml:"waypoints"`
+	Pose      string `yaml:"pose"`
+}
+
+// Processing defines frames processing by drive mode (see part.ParseProcessingPolicy)
+type Processing struct {
+	DriveMode   string `yaml:"drive_mode"`
+	ReducedRate int    `yaml:"reduced_rate"`
+}
+
+// Frames defines freshness checks of received frames
+type Frames struct {
+	MaxAge           time.Duration `yaml:"max_age"`
+	RejectOutOfOrder bool          `yaml:"reject_out_of_order"`
+	MaxClockSkew     time.Duration `yaml:"max_clock_skew"`
+	MaxLatency       time.Duration `yaml:"max_latency"`
+}
+
+// Server defines listen addresses of optional servers, empty addresses disable them
+type Server struct {
+	HTTPAddr string `yaml:"http_addr"`
+	GRPCAddr string `yaml:"grpc_addr"`
+	// UI enables the tuning web page on http server
+	UI bool `yaml:"ui"`
+}
+
+// Diagnostic defines per-frame diagnostic log, disabled if File is empty
+type Diagnostic struct {
+	File string `yaml:"file"`
+	// MaxSize is the file size in MB that triggers a rotation
+	MaxSize    int `yaml:"max_size"`
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// Default returns built-in configuration
+func Default() Config {
+	return Config{
+		MQTT:       MQTT{Broker: DefaultBroker, ClientId: DefaultClientId},
+		Detector:   part.DefaultDetectorConfig(),
+		Lane:       lane.DefaultConfig(),
+		Processing: Processing{ReducedRate: DefaultReducedRate},
+		Frames:     Frames{MaxClockSkew: part.DefaultMaxClockSkew, MaxLatency: part.DefaultMaxLatency},
+		Tracing: telemetry.Config{
+			Exporter:    telemetry.ExporterNone,
+			File:        "traces.jsonl",
+			Endpoint:    "localhost:4317",
+			SampleRatio: 1.,
+		},
+		Diagnostic: Diagnostic{MaxSize: diag.DefaultMaxSize >> 20, MaxBackups: diag.DefaultMaxBackups},
+		LogLevel:   zapcore.InfoLevel,
+	}
+}
+
+// Validate checks settings consistency
+func (c *Config) Validate() error {
+	if err := c.Detector.Validate(); err != nil {
+		return fmt.Errorf("invalid detector config: %w", err)
+	}
+	if err := c.Lane.Validate(); err != nil {
+		return fmt.Errorf("invalid lane config: %w", err)
+	}
+	if c.MQTT.Qos < 0 || c.MQTT.Qos > 2 {
+		return fmt.Errorf("invalid mqtt qos value %v, should be 0, 1 or 2", c.MQTT.Qos)
+	}
+	if _, err := part.ParseProcessingPolicy(c.Processing.DriveMode, c.Processing.ReducedRate); err != nil {
+		return fmt.Errorf("invalid drive mode processing: %w", err)
+	}
+	if c.Processing.DriveMode != "" && c.Topics.DriveMode == "" {
+		return fmt.Errorf("drive mode processing '%v' requires a drive mode topic", c.Processing.DriveMode)
+	}
+	if c.Frames.MaxAge < 0 {
+		return fmt.Errorf("invalid max frame age %v, should be >= 0", c.Frames.MaxAge)
+	}
+	if c.Tracing.SampleRatio < 0. || c.Tracing.SampleRatio > 1. {
+		return fmt.Errorf("invalid trace sample ratio %v, should be between 0 and 1", c.Tracing.SampleRatio)
+	}
+	if c.Diagnostic.MaxSize <= 0 || c.Diagnostic.MaxBackups < 0 {
+		return fmt.Errorf("invalid diagnostic rotation (max size %vMB, max backups %v), should be > 0 and >= 0", c.Diagnostic.MaxSize, c.Diagnostic.MaxBackups)
+	}
+	return nil
+}
+
+// Print writes configuration as yaml, mqtt password is masked
+func (c *Config) Print(w io.Writer) error {
+	cfg := *c
+	if cfg.MQTT.Password != "" {
+		cfg.MQTT.Password = "********"
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&cfg); err != nil {
+		return fmt.Errorf("unable to write config: %w", err)
+	}
+	return encoder.Close()
+}
+
+// LoadOption customizes settings resolution of a command
+type LoadOption func(o *loadOptions)
+
+type loadOptions struct {
+	defaults func(c *Config)
+	flags    func(fs *flag.FlagSet)
+	usage    string
+}
+
+// WithDefaults updates built-in defaults of a command, config file, profile, environment and flags still override
+// them
+func WithDefaults(update func(c *Config)) LoadOption {
+	return func(o *loadOptions) {
+		o.defaults = update
+	}
+}
+
+// WithFlags registers command specific flags alongside settings flags. Args are parsed twice, first to find config
+// file and profile, so a flag.Value set again with the same value should give the same result.
+func WithFlags(register func(fs *flag.FlagSet)) LoadOption {
+	return func(o *loadOptions) {
+		o.flags = register
+	}
+}
+
+// WithUsage prints text before flags on -h
+func WithUsage(text string) LoadOption {
+	return func(o *loadOptions) {
+		o.usage = text
+	}
+}
+
+func newLoadOptions(opts []LoadOption) *loadOptions {
+	o := loadOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+// defaultConfig returns built-in configuration updated by command defaults
+func (o *loadOptions) defaultConfig() Config {
+	cfg := Default()
+	if o.defaults != nil {
+		o.defaults(&cfg)
+	}
+	return cfg
+}
+
+// Load resolves configuration of a command from its args, each source overriding the previous ones:
+//
+//  1. built-in defaults (see Default and WithDefaults)
+//  2. yaml file given by -config flag or EnvConfigFile
+//  3. profile given by -profile flag or EnvProfile, from file profiles or built-in ones (see Profiles)
+//  4. environment variables
+//  5. command line flags
+//
+// lookupEnv is usually os.LookupEnv. flag.ErrHelp is returned if -h flag is set.
+func Load(name string, args []string, lookupEnv func(string) (string, bool), opts ...LoadOption) (*Config, error) {
+	o := newLoadOptions(opts)
+	configFile, profile, err := o.sources(name, args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := o.defaultConfig()
+	profiles, err := Profiles()
+	if err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		fileProfiles, err := loadFile(configFile, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		for n, p := range fileProfiles {
+			profiles[n] = p
+		}
+	}
+	if err := applyProfile(&cfg, profile, profiles); err != nil {
+		return nil, err
+	}
+
+	fs := o.newFlagSet(name, &cfg, &configFile, &profile)
+	fs.SetOutput(io.Discard)
+	if err := applyEnv(fs, cfg.settings(), lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadDetector returns base detector settings overridden by detector settings of yaml config file at path, then by
+// those of profile if not empty. Profile is looked up in file profiles and built-in ones. Other settings of file are
+// checked but ignored.
+func LoadDetector(path, profile string, base part.DetectorConfig) (part.DetectorConfig, error) {
+	cfg := Default()
+	cfg.Detector = base
+	profiles, err := Profiles()
+	if err != nil {
+		return base, err
+	}
+	fileProfiles, err := loadFile(path, &cfg)
+	if err != nil {
+		return base, err
+	}
+	for n, p := range fileProfiles {
+		profiles[n] = p
+	}
+	if err := applyProfile(&cfg, profile, profiles); err != nil {
+		return base, err
+	}
+	if err := cfg.Detector.Validate(); err != nil {
+		return base, fmt.Errorf("invalid detector config: %w", err)
+	}
+	return cfg.Detector, nil
+}
+
+// applyProfile reads profile content on top of cfg, nothing is done if profile is empty
+func applyProfile(cfg *Config, profile string, profiles map[string][]byte) error {
+	if profile == "" {
+		return nil
+	}
+	doc, ok := profiles[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile '%v', should be one of %v", profile, profileNames(profiles))
+	}
+	if err := decodeStrict(doc, cfg); err != nil {
+		return fmt.Errorf("invalid profile '%v': %w", profile, err)
+	}
+	return nil
+}
+
+// Sources returns config file and profile names of a command from its args or environment, empty if not set
+func Sources(name string, args []string, lookupEnv func(string) (string, bool), opts ...LoadOption) (string, string, error) {
+	return newLoadOptions(opts).sources(name, args, lookupEnv)
+}
+
+func (o *loadOptions) sources(name string, args []string, lookupEnv func(string) (string, bool)) (string, string, error) {
+	scratch := o.defaultConfig()
+	var configFile, profile string
+	fs := o.newFlagSet(name, &scratch, &configFile, &profile)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			o.printDefaults(name, os.Stderr)
+		}
+		return "", "", err
+	}
+	if fs.NArg() > 0 {
+		return "", "", fmt.Errorf("unexpected argument '%v'", fs.Arg(0))
+	}
+	if !isFlagSet(fs, "config") {
+		configFile, _ = lookupEnv(EnvConfigFile)
+	}
+	if !isFlagSet(fs, "profile") {
+		profile, _ = lookupEnv(EnvProfile)
+	}
+	return configFile, profile, nil
+}
+
+// PrintDefaults writes usage of command flags
+func PrintDefaults(name string, w io.Writer, opts ...LoadOption) {
+	newLoadOptions(opts).printDefaults(name, w)
+}
+
+func (o *loadOptions) printDefaults(name string, w io.Writer) {
+	cfg := o.defaultConfig()
+	var configFile, profile string
+	fs := o.newFlagSet(name, &cfg, &configFile, &profile)
+	fs.SetOutput(w)
+	if o.usage != "" {
+		fmt.Fprintln(w, o.usage)
+	}
+	fs.PrintDefaults()
+}
+
+//go:embed profiles.yaml
+var builtinProfiles []byte
+
+// Profiles returns built-in profiles as yaml documents by name
+func Profiles() (map[string][]byte, error) {
+	profiles, err := parseProfiles(builtinProfiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid built-in profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// fileContent is the layout of config file: settings at top level, alongside named profiles
+type fileContent struct {
+	Config   `yaml:",inline"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+func loadFile(path string, cfg *Config) (map[string][]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+	file := fileContent{Config: *cfg}
+	if err := decodeStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid config file %v: %w", path, err)
+	}
+	*cfg = file.Config
+
+	profiles := make(map[string][]byte, len(file.Profiles))
+	for name, node := range file.Profiles {
+		doc, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile '%v' in config file %v: %w", name, path, err)
+		}
+		profiles[name] = doc
+	}
+	return profiles, nil
+}
+
+func parseProfiles(content []byte) (map[string][]byte, error) {
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(content, &nodes); err != nil {
+		return nil, err
+	}
+	profiles := make(map[string][]byte, len(nodes))
+	for name, node := range nodes {
+		doc, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile '%v': %w", name, err)
+		}
+		profiles[name] = doc
+	}
+	return profiles, nil
+}
+
+// decodeStrict reads yaml document on top of out, unknown fields are rejected
+func decodeStrict(doc []byte, out interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(doc))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func profileNames(profiles map[string][]byte) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setting binds a command line flag and an environment variable to a Config field
+type setting struct {
+	flag  string
+	env   string
+	value interface{}
+	usage string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"mqtt-broker", "MQTT_BROKER", &c.MQTT.Broker, "Broker Uri"},
+		{"mqtt-username", "MQTT_USERNAME", &c.MQTT.Username, "Broker Username"},
+		{"mqtt-password", "MQTT_PASSWORD", &c.MQTT.Password, "Broker Password"},
+		{"mqtt-client-id", "MQTT_CLIENT_ID", &c.MQTT.ClientId, "Mqtt client id"},
+		{"mqtt-qos", "MQTT_QOS", &c.MQTT.Qos, "Qos to publish message"},
+		{"mqtt-retain", "MQTT_RETAIN", &c.MQTT.Retain, "Retain mqtt message"},
+		{"mqtt-topic-road", "MQTT_TOPIC_ROAD", &c.Topics.Road, "Mqtt topic to publish road detection result"},
+		{"mqtt-topic-road-json", "MQTT_TOPIC_ROAD_JSON", &c.Topics.RoadJSON, "Mqtt topic to publish road detection result as json, disabled if empty"},
+		{"mqtt-topic-camera", "MQTT_TOPIC_CAMERA", &c.Topics.Camera, "Mqtt topic that contains camera frame values"},
+		{"horizon", "HORIZON", &c.Detector.Horizon, "Limit horizon in pixels from top"},
+		{"mqtt-topic-drive-mode", "MQTT_TOPIC_DRIVE_MODE", &c.Topics.DriveMode, "Mqtt topic that contains DriveMode value, frames are processed whatever the drive mode if not set"},
+		{"drive-mode-processing", "DRIVE_MODE_PROCESSING", &c.Processing.DriveMode, "Frames processing by drive mode as comma separated list of DRIVE_MODE=full|reduced|pause (ex: 'INVALID=pause,USER=reduced,PILOT=full')"},
+		{"reduced-rate", "REDUCED_RATE", &c.Processing.ReducedRate, "In reduced processing mode, process only 1 frame over this value"},
+		{"mqtt-topic-config", "MQTT_TOPIC_CONFIG", &c.Topics.Config, "Mqtt topic to listen for json detector configuration updates, disabled if empty"},
+		{"mqtt-topic-config-ack", "MQTT_TOPIC_CONFIG_ACK", &c.Topics.ConfigAck, "Mqtt topic to publish result of configuration updates"},
+		{"mqtt-topic-waypoints", "MQTT_TOPIC_WAYPOINTS", &c.Topics.Waypoints, "Mqtt topic to publish json waypoints of road centerline, disabled if empty"},
+		{"mqtt-topic-pose", "MQTT_TOPIC_POSE", &c.Topics.Pose, "Mqtt topic to publish json lateral offset and heading error of car on road, disabled if empty"},
+		{"waypoints", "WAYPOINTS", &c.Lane.Waypoints, "Max number of road centerline waypoints"},
+		{"waypoints-spacing", "WAYPOINTS_SPACING", &c.Lane.Spacing, "Spacing of waypoints: rows or ground (requires ground homography)"},
+		{"waypoints-step", "WAYPOINTS_STEP", &c.Lane.Step, "Distance between waypoints in rows or cm, waypoints are spread along the whole centerline if 0"},
+		{"ground-homography", "GROUND_HOMOGRAPHY", &c.Lane.Homography, "Ground calibration as 9 comma separated values of the 3x3 matrix that projects image pixels to ground cm"},
+		{"max-clock-skew", "MAX_CLOCK_SKEW", &c.Frames.MaxClockSkew, "Report clock skew when frames are created further in the future than this duration"},
+		{"max-latency", "MAX_LATENCY", &c.Frames.MaxLatency, "Report clock skew when frames are created further in the past than this duration"},
+		{"max-frame-age", "MAX_FRAME_AGE", &c.Frames.MaxAge, "Drop frames received more than this duration after their creation by camera, disabled if 0"},
+		{"reject-out-of-order", "REJECT_OUT_OF_ORDER", &c.Frames.RejectOutOfOrder, "Drop frames created before the last processed one"},
+		{"trace-exporter", "TRACE_EXPORTER", &c.Tracing.Exporter, "OpenTelemetry spans exporter: none, stdout, file or otlp"},
+		{"trace-file", "TRACE_FILE", &c.Tracing.File, "File where spans are appended with 'file' exporter"},
+		{"otlp-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", &c.Tracing.Endpoint, "OTLP grpc collector address with 'otlp' exporter"},
+		{"otlp-insecure", "OTEL_EXPORTER_OTLP_INSECURE", &c.Tracing.Insecure, "Disable TLS to connect OTLP collector"},
+		{"trace-sample-ratio", "TRACE_SAMPLE_RATIO", &c.Tracing.SampleRatio, "Fraction of frames traced, between 0 and 1"},
+		{"diag-file", "DIAG_FILE", &c.Diagnostic.File, "File where a json diagnostic line is appended for each processed frame, disabled if empty"},
+		{"diag-max-size", "DIAG_MAX_SIZE", &c.Diagnostic.MaxSize, "Size in MB of diagnostic file that triggers a rotation"},
+		{"diag-max-backups", "DIAG_MAX_BACKUPS", &c.Diagnostic.MaxBackups, "Number of rotated diagnostic files kept"},
+		{"grpc-addr", "GRPC_ADDR", &c.Server.GRPCAddr, "Listen address (ex: ':9090') of grpc server that exposes road detection, disabled if empty"},
+		{"http-addr", "HTTP_ADDR", &c.Server.HTTPAddr, "Listen address (ex: ':8080') of http server that serves debug MJPEG streams and detect endpoint, disabled if empty"},
+		{"http-ui", "HTTP_UI", &c.Server.UI, "Serve detector tuning web page on /ui/ of http server"},
+		{"log", "LOG_LEVEL", &c.LogLevel, "log level"},
+	}
+}
+
+// newFlagSet returns flags of settings bound to c, and command specific flags
+func (o *loadOptions) newFlagSet(name string, c *Config, configFile, profile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(configFile, "config", *configFile, "Yaml config file, use "+EnvConfigFile+" if args not set")
+	fs.StringVar(profile, "profile", *profile, "Name of config profile applied on top of config file (built-in: indoor-track, outdoor-track), use "+EnvProfile+" if args not set")
+	for _, s := range c.settings() {
+		usage := s.usage + ", use " + s.env + " if args not set"
+		switch v := s.value.(type) {
+		case *string:
+			fs.StringVar(v, s.flag, *v, usage)
+		case *int:
+			fs.IntVar(v, s.flag, *v, usage)
+		case *float64:
+			fs.Float64Var(v, s.flag, *v, usage)
+		case *bool:
+			fs.BoolVar(v, s.flag, *v, usage)
+		case *time.Duration:
+			fs.DurationVar(v, s.flag, *v, usage)
+		case flag.Value:
+			fs.Var(v, s.flag, usage)
+		default:
+			panic(fmt.Sprintf("unsupported type %T of flag %v", s.value, s.flag))
+		}
+	}
+	if o.flags != nil {
+		o.flags(fs)
+	}
+	return fs
+}
+
+// applyEnv sets flags from environment variables. Empty variables are ignored, except for boolean flags that are
+// enabled by variable presence, like legacy MQTT_RETAIN: only a boolean value ("false", "0", ...) disables them.
+func applyEnv(fs *flag.FlagSet, settings []setting, lookupEnv func(string) (string, bool)) error {
+	for _, s := range settings {
+		value, ok := lookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if _, isBool := s.value.(*bool); isBool {
+			if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+				value = "true"
+			}
+		} else if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if err := fs.Set(s.flag, value); err != nil {
+			return fmt.Errorf("invalid value '%v' of %v: %w", value, s.env, err)
+		}
+	}
+	return nil
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigFile = `
+mqtt:
+  broker: tcp://broker:1883
+detector:
+  threshold: 120
+  horizon: 30
+frames:
+  max_age: 200ms
+profiles:
+  indoor-track:
+    detector:
+      threshold: 140
+  night:
+    detector:
+      threshold: 90
+      kernel_size: 8
+`
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rc-road.yaml")
+	if err := os.WriteFile(file, []byte(testConfigFile), 0644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		check     func(c *Config) bool
+		wantError bool
+	}{
+		{name: "defaults",
+			check: func(c *Config) bool {
+				return c.MQTT.Broker == DefaultBroker && c.Detector.Threshold == 180 && c.Detector.Horizon == 20 &&
+					c.Frames.MaxAge == 0 && c.Diagnostic.MaxSize == 50
+			}},
+		{name: "file", args: []string{"-config", file},
+			check: func(c *Config) bool {
+				return c.MQTT.Broker == "tcp://broker:1883" && c.Detector.Threshold == 120 && c.Detector.Horizon == 30 &&
+					c.Detector.KernelSize == 4 && c.Frames.MaxAge == 200*time.Millisecond
+			}},
+		{name: "file from env", env: map[string]string{EnvConfigFile: file},
+			check: func(c *Config) bool { return c.Detector.Threshold == 120 }},
+		{name: "built-in profile", args: []string{"-profile", "outdoor-track"},
+			check: func(c *Config) bool {
+				return c.Detector.Threshold == 200 && c.Detector.KernelSize == 6 && c.Frames.RejectOutOfOrder
+			}},
+		{name: "file profile replaces built-in one", args: []string{"-config", file, "-profile", "indoor-track"},
+			check: func(c *Config) bool {
+				return c.Detector.Threshold == 140 && c.Detector.Horizon == 30 && c.Detector.MorphoIterations == 3
+			}},
+		{name: "file profile", args: []string{"-config", file}, env: map[string]string{EnvProfile: "night"},
+			check: func(c *Config) bool { return c.Detector.Threshold == 90 && c.Detector.KernelSize == 8 }},
+		{name: "env overrides file and profile", args: []string{"-config", file, "-profile", "night"},
+			env: map[string]string{"HORIZON": "40", "MAX_FRAME_AGE": "1s", "REJECT_OUT_OF_ORDER": "", "MQTT_TOPIC_ROAD": ""},
+			check: func(c *Config) bool {
+				return c.Detector.Horizon == 40 && c.Detector.Threshold == 90 && c.Frames.MaxAge == time.Second &&
+					c.Frames.RejectOutOfOrder && c.Topics.Road == ""
+			}},
+		{name: "flags override env", args: []string{"-config", file, "-horizon", "50", "-log", "debug", "-reject-out-of-order=false"},
+			env: map[string]string{"HORIZON": "40", "REJECT_OUT_OF_ORDER": "", "MQTT_BROKER": "tcp://other:1883"},
+			check: func(c *Config) bool {
+				return c.Detector.Horizon == 50 && !c.Frames.RejectOutOfOrder && c.LogLevel.String() == "debug" &&
+					c.MQTT.Broker == "tcp://other:1883"
+			}},
+		{name: "legacy boolean env set by presence", env: map[string]string{"MQTT_RETAIN": "yes", "REJECT_OUT_OF_ORDER": "on"},
+			check: func(c *Config) bool { return c.MQTT.Retain && c.Frames.RejectOutOfOrder }},
+		{name: "boolean env disabled", args: []string{"-profile", "outdoor-track"},
+			env:   map[string]string{"MQTT_RETAIN": "false", "REJECT_OUT_OF_ORDER": "0"},
+			check: func(c *Config) bool { return !c.MQTT.Retain && !c.Frames.RejectOutOfOrder }},
+		{name: "ground calibration from env", args: []string{"-waypoints-spacing", "ground"},
+			env: map[string]string{"GROUND_HOMOGRAPHY": "1,0,-80,0,0,1000,0,1,-20", "MQTT_TOPIC_WAYPOINTS": "robocar/waypoints", "MQTT_TOPIC_POSE": "robocar/pose"},
+			check: func(c *Config) bool {
+				return c.Lane.Spacing == "ground" && c.Lane.Homography.Calibrated() && c.Lane.Homography[2] == -80 &&
+					c.Topics.Waypoints == "robocar/waypoints" && c.Topics.Pose == "robocar/pose" && c.Lane.Waypoints == 10
+			}},
+		{name: "drive mode processing", args: []string{"-drive-mode-processing", "USER=pause"},
+			env: map[string]string{"MQTT_TOPIC_DRIVE_MODE": "robocar/drive-mode"},
+			check: func(c *Config) bool {
+				return c.Processing.DriveMode == "USER=pause" && c.Topics.DriveMode == "robocar/drive-mode"
+			}},
+		{name: "drive mode processing without topic", args: []string{"-drive-mode-processing", "USER=pause"}, wantError: true},
+		{name: "ground spacing without calibration", args: []string{"-waypoints-spacing", "ground"}, wantError: true},
+		{name: "invalid ground calibration", args: []string{"-ground-homography", "1,0,0"}, wantError: true},
+		{name: "unknown profile", args: []string{"-profile", "moon"}, wantError: true},
+		{name: "missing file", args: []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, wantError: true},
+		{name: "invalid env", env: map[string]string{"HORIZON": "high"}, wantError: true},
+		{name: "invalid config", args: []string{"-horizon", "-1"}, wantError: true},
+		{name: "unknown flag", args: []string{"-horizontal", "1"}, wantError: true},
+		{name: "unexpected argument", args: []string{"print"}, wantError: true},
+	}
+
+	for _, c := range cases {
+		lookupEnv := func(key string) (string, bool) {
+			v, ok := c.env[key]
+			return v, ok
+		}
+		cfg, err := Load("rc-road", c.args, lookupEnv)
+		if c.wantError {
+			if err == nil {
+				t.Errorf("[%v] Load() should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%v] unable to load config: %v", c.name, err)
+			continue
+		}
+		if !c.check(cfg) {
+			t.Errorf("[%v] bad config: %+v", c.name, cfg)
+		}
+	}
+}
+
+func TestLoad_CommandOptions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rc-road.yaml")
+	if err := os.WriteFile(file, []byte(testConfigFile), 0644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+
+	cases := []struct {
+		name         string
+		args         []string
+		env          map[string]string
+		wantClientId string
+		wantHorizon  int
+		wantOutput   string
+		wantError    bool
+	}{
+		{name: "command defaults", wantClientId: "robocar-road-record", wantHorizon: 20, wantOutput: "frames.rec"},
+		{name: "file overrides command defaults", args: []string{"-config", file, "-output", "track.rec"},
+			wantClientId: "robocar-road-record", wantHorizon: 30, wantOutput: "track.rec"},
+		{name: "env overrides command defaults", args: []string{"-output", "track.rec"},
+			env:          map[string]string{"MQTT_CLIENT_ID": "recorder", "HORIZON": "40"},
+			wantClientId: "recorder", wantHorizon: 40, wantOutput: "track.rec"},
+		{name: "command flags mixed with settings flags", args: []string{"-output", "track.rec", "-horizon", "50", "-profile", "night", "-config", file},
+			wantClientId: "robocar-road-record", wantHorizon: 50, wantOutput: "track.rec"},
+		{name: "unknown command flag", args: []string{"-input", "track.rec"}, wantError: true},
+	}
+
+	for _, c := range cases {
+		lookupEnv := func(key string) (string, bool) {
+			v, ok := c.env[key]
+			return v, ok
+		}
+		var output string
+		cfg, err := Load("record", c.args, lookupEnv,
+			WithDefaults(func(cfg *Config) {
+				cfg.MQTT.ClientId = DefaultClientId + "-record"
+			}),
+			WithFlags(func(fs *flag.FlagSet) {
+				fs.StringVar(&output, "output", "frames.rec", "Record file")
+			}),
+		)
+		if c.wantError {
+			if err == nil {
+				t.Errorf("[%v] Load() should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%v] unable to load config: %v", c.name, err)
+			continue
+		}
+		if cfg.MQTT.ClientId != c.wantClientId || cfg.Detector.Horizon != c.wantHorizon || output != c.wantOutput {
+			t.Errorf("[%v] bad config: client id %v, horizon %v, output %v, wants %v, %v, %v", c.name,
+				cfg.MQTT.ClientId, cfg.Detector.Horizon, output, c.wantClientId, c.wantHorizon, c.wantOutput)
+		}
+	}
+}
+
+func TestPrintDefaults_CommandOptions(t *testing.T) {
+	var output string
+	var b bytes.Buffer
+	PrintDefaults("record", &b,
+		WithUsage("Usage: rc-road record [flags]"),
+		WithDefaults(func(cfg *Config) {
+			cfg.MQTT.ClientId = "robocar-road-record"
+		}),
+		WithFlags(func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "output", "frames.rec", "Record file")
+		}),
+	)
+	usage := b.String()
+	for _, expected := range []string{"Usage: rc-road record [flags]\n", "-output", "-mqtt-broker", "-config", `(default "robocar-road-record")`} {
+		if !strings.Contains(usage, expected) {
+			t.Errorf("usage should contain %q: %v", expected, usage)
+		}
+	}
+	if !strings.HasPrefix(usage, "Usage:") {
+		t.Errorf("usage text should be printed first: %v", usage)
+	}
+}
+
+func TestLoad_InvalidFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"unknown field", "detector:\n  treshold: 120\n"},
+		{"unknown profile field", "profiles:\n  night:\n    detector:\n      treshold: 120\n"},
+		{"bad type", "detector:\n  threshold: dark\n"},
+		{"bad duration", "frames:\n  max_age: soon\n"},
+	}
+	for _, c := range cases {
+		file := filepath.Join(t.TempDir(), "rc-road.yaml")
+		if err := os.WriteFile(file, []byte(c.content), 0644); err != nil {
+			t.Fatalf("unable to write config file: %v", err)
+		}
+		if _, err := Load("rc-road", []string{"-config", file, "-profile", "night"}, noEnv); err == nil {
+			t.Errorf("[%v] Load() should fail", c.name)
+		}
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg, err := Load("rc-road", []string{"-mqtt-password", "secret", "-profile", "outdoor-track"}, noEnv)
+	if err != nil {
+		t.Fatalf("unable to load config: %v", err)
+	}
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatalf("unable to print config: %v", err)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("password not masked: %v", out.String())
+	}
+	for _, expected := range []string{"threshold: 200", "max_age: 300ms", "log_level: info"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("'%v' missing from printed config: %v", expected, out.String())
+		}
+	}
+
+	// Printed config can be loaded back
+	file := filepath.Join(t.TempDir(), "rc-road.yaml")
+	if err := os.WriteFile(file, out.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+	reloaded, err := Load("rc-road", []string{"-config", file, "-mqtt-password", "secret"}, noEnv)
+	if err != nil {
+		t.Fatalf("unable to load printed config: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded, cfg) {
+		t.Errorf("bad reloaded config: %+v, wants %+v", reloaded, cfg)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	profiles, err := Profiles()
+	if err != nil {
+		t.Fatalf("unable to read built-in profiles: %v", err)
+	}
+	for _, name := range []string{"indoor-track", "outdoor-track"} {
+		if _, err := Load("rc-road", []string{"-profile", name}, noEnv); err != nil {
+			t.Errorf("[%v] invalid built-in profile: %v", name, err)
+		}
+		if _, ok := profiles[name]; !ok {
+			t.Errorf("[%v] missing built-in profile", name)
+		}
+	}
+}
+
+func noEnv(_ string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SaveDetector writes detector settings to config file at path, in profile section if profile is not empty. Other
+// settings and comments of file are kept, file is created if it doesn't exist. A profile missing from file is
+// initialized with the built-in profile of the same name, if any, as a file profile replaces the built-in one.
+func SaveDetector(path, profile string, detector part.DetectorConfig) error {
+	var doc yaml.Node
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("unable to read config file: %w", err)
+	default:
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return fmt.Errorf("invalid config file %v: %w", path, err)
+		}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{newMapping()}}
+	}
+	section := doc.Content[0]
+	if section.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid config file %v: settings should be a yaml mapping", path)
+	}
+
+	if profile != "" {
+		profiles := mappingValue(section, "profiles")
+		if profiles == nil || profiles.Kind != yaml.MappingNode {
+			profiles = newMapping()
+			setMappingValue(section, "profiles", profiles)
+		}
+		section = mappingValue(profiles, profile)
+		if section == nil || section.Kind != yaml.MappingNode {
+			section, err = builtinProfile(profile)
+			if err != nil {
+				return err
+			}
+			setMappingValue(profiles, profile, section)
+		}
+	}
+
+	var value yaml.Node
+	if err := value.Encode(&detector); err != nil {
+		return fmt.Errorf("unable to encode detector config: %w", err)
+	}
+	setMappingValue(section, "detector", &value)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("unable to encode config file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("unable to encode config file: %w", err)
+	}
+	// Never write a file that can't be loaded back
+	if err := decodeStrict(buf.Bytes(), &fileContent{Config: Default()}); err != nil {
+		return fmt.Errorf("unable to update config file %v: %w", path, err)
+	}
+
+	// Replace file atomically, a crash never leaves a truncated config
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	return nil
+}
+
+// WriteDetector writes a config file at path with only detector settings, preceded by comment lines if comment is
+// not empty. File is replaced if it exists, it can be loaded with Load or LoadDetector.
+func WriteDetector(path, comment string, detector part.DetectorConfig) error {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(comment), "\n") {
+		if line != "" {
+			fmt.Fprintf(&buf, "# %v\n", line)
+		}
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&struct {
+		Detector part.DetectorConfig `yaml:"detector"`
+	}{detector}); err != nil {
+		return fmt.Errorf("unable to encode detector config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("unable to encode detector config: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write config file: %w", err)
+	}
+	return nil
+}
+
+// builtinProfile returns content of built-in profile as yaml mapping, an empty one if profile is not built-in
+func builtinProfile(name string) (*yaml.Node, error) {
+	profiles, err := Profiles()
+	if err != nil {
+		return nil, err
+	}
+	content, ok := profiles[name]
+	if !ok {
+		return newMapping(), nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("invalid built-in profile '%v': %w", name, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return newMapping(), nil
+	}
+	return doc.Content[0], nil
+}
+
+func newMapping() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+// mappingValue returns value of key in mapping, nil if key is missing
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces value of key in mapping, key is appended if missing
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
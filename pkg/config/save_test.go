@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveDetector(t *testing.T) {
+	detector := Default().Detector
+	detector.Threshold = 123
+	detector.KernelSize = 7
+
+	cases := []struct {
+		name    string
+		content string
+		profile string
+		check   func(c *Config, content string) bool
+	}{
+		{name: "new file",
+			check: func(c *Config, _ string) bool { return c.MQTT.Broker == DefaultBroker }},
+		{name: "file settings and comments kept", content: "# track settings\nmqtt:\n  broker: tcp://car:1883\ndetector:\n  threshold: 90\n",
+			check: func(c *Config, content string) bool {
+				return c.MQTT.Broker == "tcp://car:1883" && strings.Contains(content, "# track settings")
+			}},
+		{name: "file profile", content: "detector:\n  threshold: 90\nprofiles:\n  night:\n    detector:\n      threshold: 80\n", profile: "night",
+			check: func(c *Config, content string) bool { return strings.Contains(content, "threshold: 90") }},
+		{name: "new profile", content: "detector:\n  threshold: 90\n", profile: "race",
+			check: func(c *Config, content string) bool { return strings.Contains(content, "threshold: 90") }},
+		{name: "built-in profile copied to file", profile: "outdoor-track",
+			check: func(c *Config, _ string) bool {
+				return c.Frames.MaxAge == 300*time.Millisecond && c.Frames.RejectOutOfOrder
+			}},
+	}
+
+	for _, c := range cases {
+		file := filepath.Join(t.TempDir(), "rc-road.yaml")
+		if c.content != "" {
+			if err := os.WriteFile(file, []byte(c.content), 0644); err != nil {
+				t.Fatalf("unable to write config file: %v", err)
+			}
+		}
+		if err := SaveDetector(file, c.profile, detector); err != nil {
+			t.Errorf("[%v] unable to save detector config: %v", c.name, err)
+			continue
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unable to read config file: %v", err)
+		}
+		args := []string{"-config", file}
+		if c.profile != "" {
+			args = append(args, "-profile", c.profile)
+		}
+		cfg, err := Load("rc-road", args, noEnv)
+		if err != nil {
+			t.Errorf("[%v] unable to load saved config: %v\n%s", c.name, err, content)
+			continue
+		}
+		if cfg.Detector != detector {
+			t.Errorf("[%v] bad saved detector config: %+v, wants %+v", c.name, cfg.Detector, detector)
+		}
+		if !c.check(cfg, string(content)) {
+			t.Errorf("[%v] bad saved config file:\n%s", c.name, content)
+		}
+	}
+}
+
+func TestSaveDetector_InvalidFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"not a mapping", "- detector\n"},
+		{"not yaml", "detector: [\n"},
+		{"unknown field", "detectr:\n  threshold: 90\n"},
+	}
+	for _, c := range cases {
+		file := filepath.Join(t.TempDir(), "rc-road.yaml")
+		if err := os.WriteFile(file, []byte(c.content), 0644); err != nil {
+			t.Fatalf("unable to write config file: %v", err)
+		}
+		if err := SaveDetector(file, "", Default().Detector); err == nil {
+			t.Errorf("[%v] SaveDetector() should fail", c.name)
+		}
+		if content, _ := os.ReadFile(file); string(content) != c.content {
+			t.Errorf("[%v] invalid file modified: %s", c.name, content)
+		}
+	}
+}
+
+func TestWriteDetector(t *testing.T) {
+	detector := Default().Detector
+	detector.Threshold = 150
+	detector.Horizon = 35
+	detector.ApproxPolyEpsilonFactor = 0.015
+
+	file := filepath.Join(t.TempDir(), "rc-road-tuned.yaml")
+	if err := WriteDetector(file, "Tuned on labeled/: iou 0.912", detector); err != nil {
+		t.Fatalf("unable to write detector config: %v", err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read config file: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "# Tuned on labeled/: iou 0.912\n") {
+		t.Errorf("comment should be written first: %s", content)
+	}
+
+	cfg, err := Load("rc-road", []string{"-config", file}, noEnv)
+	if err != nil {
+		t.Fatalf("unable to load written config: %v\n%s", err, content)
+	}
+	if cfg.Detector != detector {
+		t.Errorf("bad detector config: %+v, wants %+v", cfg.Detector, detector)
+	}
+	if cfg.MQTT != Default().MQTT {
+		t.Errorf("other settings should keep their defaults: %+v", cfg.MQTT)
+	}
+}
+
+func TestLoadDetector(t *testing.T) {
+	base := Default().Detector
+	base.Horizon = 42
+
+	file := filepath.Join(t.TempDir(), "rc-road.yaml")
+	content := "mqtt:\n  broker: tcp://car:1883\ndetector:\n  threshold: 150\nprofiles:\n  night:\n    detector:\n      threshold: 90\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+
+	cases := []struct {
+		name          string
+		path          string
+		profile       string
+		wantThreshold float64
+		wantKernel    int
+		wantError     bool
+	}{
+		{name: "file", path: file, wantThreshold: 150, wantKernel: base.KernelSize},
+		{name: "file profile", path: file, profile: "night", wantThreshold: 90, wantKernel: base.KernelSize},
+		{name: "built-in profile", path: file, profile: "outdoor-track", wantThreshold: 200, wantKernel: 6},
+		{name: "unknown profile", path: file, profile: "moon", wantError: true},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.yaml"), wantError: true},
+	}
+
+	for _, c := range cases {
+		detector, err := LoadDetector(c.path, c.profile, base)
+		if c.wantError {
+			if err == nil {
+				t.Errorf("[%v] LoadDetector() should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%v] unable to load detector config: %v", c.name, err)
+			continue
+		}
+		if detector.Threshold != c.wantThreshold || detector.KernelSize != c.wantKernel || detector.Horizon != 42 {
+			t.Errorf("[%v] bad detector config: %+v", c.name, detector)
+		}
+	}
+}
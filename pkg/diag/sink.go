@@ -0,0 +1,249 @@
+// Package diag writes diagnostic documents as json lines, without blocking producers
+package diag
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMaxSize is the file size in bytes that triggers a rotation
+	DefaultMaxSize = 50 << 20
+	// DefaultMaxBackups is the number of rotated files kept
+	DefaultMaxBackups = 3
+	// DefaultQueueSize is the number of documents waiting to be written before new ones are dropped
+	DefaultQueueSize = 256
+)
+
+// Option configures a Sink
+type Option func(s *Sink)
+
+// WithMaxSize rotates file when writing a new line would make it bigger than maxSize bytes
+func WithMaxSize(maxSize int64) Option {
+	return func(s *Sink) {
+		s.maxSize = maxSize
+	}
+}
+
+// WithMaxBackups keeps maxBackups rotated files (path.1 being the most recent), 0 to remove file on rotation
+func WithMaxBackups(maxBackups int) Option {
+	return func(s *Sink) {
+		s.maxBackups = maxBackups
+	}
+}
+
+// WithQueueSize bounds number of documents waiting to be written
+func WithQueueSize(queueSize int) Option {
+	return func(s *Sink) {
+		s.queueSize = queueSize
+	}
+}
+
+// Sink appends documents to a file as json lines from a background goroutine
+type Sink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	queueSize  int
+
+	queue chan interface{}
+	done  chan struct{}
+
+	// mu protects queue closing
+	mu     sync.RWMutex
+	closed bool
+
+	// openFile opens diagnostic file, os.OpenFile out of tests
+	openFile func(name string, flag int, perm os.FileMode) (*os.File, error)
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	dropped  int64
+}
+
+// NewSink opens file at path, in append mode, and starts writing documents
+func NewSink(path string, opts ...Option) (*Sink, error) {
+	s := &Sink{
+		path:       path,
+		maxSize:    DefaultMaxSize,
+		maxBackups: DefaultMaxBackups,
+		queueSize:  DefaultQueueSize,
+		done:       make(chan struct{}),
+		openFile:   os.OpenFile,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	s.queue = make(chan interface{}, s.queueSize)
+	go s.run()
+	return s, nil
+}
+
+// Write queues doc to be written as json. It never blocks: doc is dropped, and false returned, if queue is full or
+// sink is closed. doc must not be modified after the call.
+func (s *Sink) Write(doc interface{}) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		atomic.AddInt64(&s.dropped, 1)
+		return false
+	}
+	select {
+	case s.queue <- doc:
+		return true
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns the number of documents not written because queue was full
+func (s *Sink) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close writes pending documents and closes file
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.done
+	if s.writer == nil {
+		return nil
+	}
+	if err := s.writer.Flush(); err != nil {
+		_ = s.file.Close()
+		return fmt.Errorf("unable to flush %v: %w", s.path, err)
+	}
+	return s.file.Close()
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	for doc := range s.queue {
+		if err := s.write(doc); err != nil {
+			zap.S().Errorf("unable to write diagnostic: %v", err)
+		}
+		if len(s.queue) == 0 && s.writer != nil {
+			// Idle, make lines visible to readers
+			if err := s.writer.Flush(); err != nil {
+				zap.S().Errorf("unable to flush %v: %v", s.path, err)
+			}
+		}
+	}
+}
+
+func (s *Sink) write(doc interface{}) error {
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %T to json: %w", doc, err)
+	}
+	line = append(line, '\n')
+
+	if s.writer == nil {
+		// File couldn't be reopened after a failed rotation
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			if s.writer == nil {
+				return err
+			}
+			// Current file has been reopened, keep writing to it
+			zap.S().Errorf("unable to rotate diagnostic file: %v", err)
+		}
+	}
+	n, err := s.writer.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *Sink) open() error {
+	f, err := s.openFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open diagnostic file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to stat diagnostic file: %w", err)
+	}
+	s.file = f
+	s.writer = bufio.NewWriter(f)
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames current file to path.1, after shifting previous backups, and opens a new file. If current file
+// can't be flushed or renamed, it is reopened so that next documents are still written, and rotation is retried on
+// next write. If no file can be opened, writer is reset and opening is retried on next write.
+func (s *Sink) rotate() error {
+	// A bufio.Writer keeps failing after an error, file is always closed to start again with a new writer
+	flushErr := s.writer.Flush()
+	closeErr := s.file.Close()
+	if flushErr != nil {
+		return s.reopen(fmt.Errorf("unable to flush %v before rotation: %w", s.path, flushErr))
+	}
+	if closeErr != nil {
+		return s.reopen(fmt.Errorf("unable to close %v before rotation: %w", s.path, closeErr))
+	}
+	if err := s.shiftFiles(); err != nil {
+		return s.reopen(err)
+	}
+	if err := s.open(); err != nil {
+		s.file, s.writer = nil, nil
+		return err
+	}
+	return nil
+}
+
+// shiftFiles renames current file and backups to make room for a new file, current file is removed if no backup is
+// kept
+func (s *Sink) shiftFiles() error {
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil {
+			return fmt.Errorf("unable to remove %v: %w", s.path, err)
+		}
+		return nil
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(backupPath(s.path, i), backupPath(s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate backup %v: %w", i, err)
+		}
+	}
+	if err := os.Rename(s.path, backupPath(s.path, 1)); err != nil {
+		return fmt.Errorf("unable to rotate %v: %w", s.path, err)
+	}
+	return nil
+}
+
+// reopen opens current file again after a failed rotation and returns rotation error. If file can't be opened,
+// writer is reset and opening is retried on next write.
+func (s *Sink) reopen(rotationErr error) error {
+	if err := s.open(); err != nil {
+		s.file, s.writer = nil, nil
+		return fmt.Errorf("%v, then %w", rotationErr, err)
+	}
+	return rotationErr
+}
+
+func backupPath(path string, idx int) string {
+	return fmt.Sprintf("%s.%d", path, idx)
+}
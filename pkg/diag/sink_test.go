@@ -0,0 +1,205 @@
+package diag
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type doc struct {
+	Id      int    `json:"id"`
+	Payload string `json:"payload"`
+}
+
+func readLines(t *testing.T, path string) []doc {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open %v: %v", path, err)
+	}
+	defer f.Close()
+
+	var docs []doc
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d doc
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Errorf("invalid json line '%s': %v", scanner.Text(), err)
+		}
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+func TestSink_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diag.jsonl")
+	s, err := NewSink(path)
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if !s.Write(&doc{Id: i}) {
+			t.Errorf("document %v dropped", i)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("unable to close sink: %v", err)
+	}
+	if s.Write(&doc{Id: 10}) {
+		t.Errorf("document written after Close()")
+	}
+
+	docs := readLines(t, path)
+	if len(docs) != 10 {
+		t.Fatalf("bad number of lines: %v, wants 10", len(docs))
+	}
+	for i, d := range docs {
+		if d.Id != i {
+			t.Errorf("bad document at line %v: %v", i, d)
+		}
+	}
+
+	// Reopened file is appended
+	s, err = NewSink(path)
+	if err != nil {
+		t.Fatalf("unable to reopen sink: %v", err)
+	}
+	s.Write(&doc{Id: 10})
+	_ = s.Close()
+	if docs := readLines(t, path); len(docs) != 11 {
+		t.Errorf("bad number of lines after reopen: %v, wants 11", len(docs))
+	}
+}
+
+func TestSink_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diag.jsonl")
+	// Each line is 32 bytes: {"id":N,"payload":"0123456789"}\n
+	s, err := NewSink(path, WithMaxSize(100), WithMaxBackups(2))
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		s.Write(&doc{Id: i, Payload: "0123456789"})
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("unable to close sink: %v", err)
+	}
+
+	cases := []struct {
+		path        string
+		expectedIds []int
+	}{
+		{path, []int{9}},
+		{path + ".1", []int{6, 7, 8}},
+		{path + ".2", []int{3, 4, 5}},
+	}
+	for _, c := range cases {
+		docs := readLines(t, c.path)
+		if len(docs) != len(c.expectedIds) {
+			t.Errorf("[%v] bad number of lines: %v, wants %v", c.path, len(docs), len(c.expectedIds))
+			continue
+		}
+		for i, d := range docs {
+			if d.Id != c.expectedIds[i] {
+				t.Errorf("[%v] bad document at line %v: %v, wants id %v", c.path, i, d, c.expectedIds[i])
+			}
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many backups kept: %v", err)
+	}
+}
+
+func TestSink_RotateFailure(t *testing.T) {
+	cases := []struct {
+		name       string
+		maxBackups int
+		// blocked is the backup path replaced by a non-empty directory, so that it can't be renamed over
+		blocked string
+		// failOpen makes the first opening of a new file fail, after current file has been renamed
+		failOpen    bool
+		expectedIds []int
+	}{
+		{name: "backup is a directory", maxBackups: 1, blocked: ".1",
+			expectedIds: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "older backup is a directory", maxBackups: 2, blocked: ".2",
+			expectedIds: []int{3, 4, 5, 6, 7, 8, 9}},
+		// Document 3 triggers rotation and is lost, document 4 reopens file
+		{name: "new file can't be opened", maxBackups: 1, failOpen: true,
+			expectedIds: []int{7, 8, 9}},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "diag.jsonl")
+		if c.blocked != "" {
+			if err := os.MkdirAll(filepath.Join(path+c.blocked, "keep"), 0755); err != nil {
+				t.Fatalf("unable to create directory: %v", err)
+			}
+		}
+		s, err := NewSink(path, WithMaxSize(100), WithMaxBackups(c.maxBackups))
+		if err != nil {
+			t.Fatalf("unable to create sink: %v", err)
+		}
+		if c.failOpen {
+			failed := false
+			s.openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+				if !failed {
+					failed = true
+					return nil, os.ErrPermission
+				}
+				return os.OpenFile(name, flag, perm)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			if !s.Write(&doc{Id: i, Payload: "0123456789"}) {
+				t.Errorf("[%v] document %v dropped", c.name, i)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("[%v] unable to close sink: %v", c.name, err)
+		}
+
+		docs := readLines(t, path)
+		if len(docs) != len(c.expectedIds) {
+			t.Errorf("[%v] bad number of lines: %v, wants %v", c.name, len(docs), len(c.expectedIds))
+			continue
+		}
+		for i, d := range docs {
+			if d.Id != c.expectedIds[i] {
+				t.Errorf("[%v] bad document at line %v: %v, wants id %v", c.name, i, d, c.expectedIds[i])
+			}
+		}
+	}
+}
+
+// blockingDoc blocks marshalling until released
+type blockingDoc chan struct{}
+
+func (b blockingDoc) MarshalJSON() ([]byte, error) {
+	<-b
+	return []byte("{}"), nil
+}
+
+func TestSink_WriteNeverBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diag.jsonl")
+	s, err := NewSink(path, WithQueueSize(2))
+	if err != nil {
+		t.Fatalf("unable to create sink: %v", err)
+	}
+
+	release := make(blockingDoc)
+	written := 0
+	for i := 0; i < 10; i++ {
+		if s.Write(release) {
+			written++
+		}
+	}
+	// One document in writer goroutine, at most 2 in queue
+	if written > 3 || s.Dropped() != int64(10-written) {
+		t.Errorf("bad written/dropped documents: %v/%v", written, s.Dropped())
+	}
+	close(release)
+	if err := s.Close(); err != nil {
+		t.Errorf("unable to close sink: %v", err)
+	}
+}
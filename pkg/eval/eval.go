@@ -0,0 +1,289 @@
+// Package eval measures road detection quality against labeled ground truth
+package eval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/synth"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNoLabel is returned when an image has no label file
+var ErrNoLabel = errors.New("no label")
+
+const (
+	// LabelExt is the extension of json label files, next to labeled image
+	LabelExt = ".json"
+	// MaskExt is the extension of png label masks, next to labeled image: non-zero pixels are road
+	MaskExt = ".mask.png"
+)
+
+// Point is a pixel position
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Label is the ground truth of an image, from a json file:
+//
+//	{"road": [{"x": 0, "y": 45}, {"x": 159, "y": 127}, ...], "center": {"x": 80, "y": 90}}
+//
+// or from a png mask
+type Label struct {
+	// Road is the road polygon
+	Road []Point `json:"road"`
+	// Center is the expected center of road ellipse, centroid of road pixels if not set
+	Center *Point `json:"center,omitempty"`
+
+	mask *image.Gray
+}
+
+// LabelPaths returns paths of json label and png mask of image at path
+func LabelPaths(path string) (string, string) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return base + LabelExt, base + MaskExt
+}
+
+// ReadLabel reads label of image at path, json label is preferred to png mask. It returns ErrNoLabel if none exists.
+func ReadLabel(path string) (*Label, error) {
+	jsonPath, maskPath := LabelPaths(path)
+
+	content, err := ioutil.ReadFile(jsonPath)
+	if err == nil {
+		var label Label
+		if err := json.Unmarshal(content, &label); err != nil {
+			return nil, fmt.Errorf("invalid label %v: %w", jsonPath, err)
+		}
+		return &label, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read label %v: %w", jsonPath, err)
+	}
+
+	f, err := os.Open(maskPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %v, expected %v or %v", ErrNoLabel, path, jsonPath, maskPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read label mask %v: %w", maskPath, err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label mask %v: %w", maskPath, err)
+	}
+	bounds := img.Bounds()
+	mask := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r|g|b != 0 {
+				mask.SetGray(x-bounds.Min.X, y-bounds.Min.Y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return &Label{mask: mask}, nil
+}
+
+// Mask returns road pixels of label on an image of bounds
+func (l *Label) Mask(bounds image.Rectangle) (*image.Gray, error) {
+	if l.mask != nil {
+		if l.mask.Bounds().Size() != bounds.Size() {
+			return nil, fmt.Errorf("label mask size %v differs from image size %v", l.mask.Bounds().Size(), bounds.Size())
+		}
+		return l.mask, nil
+	}
+	road := make([]image.Point, 0, len(l.Road))
+	for _, pt := range l.Road {
+		road = append(road, image.Point{X: pt.X, Y: pt.Y})
+	}
+	return synth.PolygonMask(bounds, road), nil
+}
+
+// Counts are numbers of pixels by class, detected road compared to ground truth
+type Counts struct {
+	TruePositives  int `json:"true_positives"`
+	FalsePositives int `json:"false_positives"`
+	FalseNegatives int `json:"false_negatives"`
+}
+
+// Compare counts road pixels (>= 128) of detected mask against truth mask, on bounds of truth
+func Compare(truth, detected *image.Gray) Counts {
+	var c Counts
+	bounds := truth.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inTruth := truth.GrayAt(x, y).Y >= 128
+			inDetected := detected.GrayAt(x, y).Y >= 128
+			switch {
+			case inTruth && inDetected:
+				c.TruePositives++
+			case inDetected:
+				c.FalsePositives++
+			case inTruth:
+				c.FalseNegatives++
+			}
+		}
+	}
+	return c
+}
+
+// Add returns sum of counts
+func (c Counts) Add(o Counts) Counts {
+	return Counts{
+		TruePositives:  c.TruePositives + o.TruePositives,
+		FalsePositives: c.FalsePositives + o.FalsePositives,
+		FalseNegatives: c.FalseNegatives + o.FalseNegatives,
+	}
+}
+
+// IoU returns intersection over union of detected and truth road, 1 if both are empty
+func (c Counts) IoU() float64 {
+	return ratio(c.TruePositives, c.TruePositives+c.FalsePositives+c.FalseNegatives)
+}
+
+// Precision returns the part of detected road pixels that are road, 1 if no road is detected
+func (c Counts) Precision() float64 {
+	return ratio(c.TruePositives, c.TruePositives+c.FalsePositives)
+}
+
+// Recall returns the part of road pixels that are detected, 1 if image has no road
+func (c Counts) Recall() float64 {
+	return ratio(c.TruePositives, c.TruePositives+c.FalseNegatives)
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 1.
+	}
+	return float64(n) / float64(d)
+}
+
+// Result is the evaluation of detection on an image
+type Result struct {
+	Name string `json:"name"`
+	Counts
+	IoU       float64 `json:"iou"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	// CenterError is the distance in pixels between detected ellipse center and expected center, -1 if image has no
+	// road or no ellipse is detected
+	CenterError float64 `json:"center_error"`
+	// RoadLost is true if image has road but detected road IoU is under threshold
+	RoadLost bool `json:"road_lost"`
+}
+
+// Detected is the detector output to evaluate
+type Detected struct {
+	// Road is the road contour
+	Road []image.Point
+	// EllipseCenter is nil if no ellipse is found
+	EllipseCenter *image.Point
+}
+
+// Evaluate compares detection on image name to its label. Road is lost if IoU is lower than lostIoU.
+func Evaluate(name string, label *Label, bounds image.Rectangle, detected Detected, lostIoU float64) (Result, error) {
+	truth, err := label.Mask(bounds)
+	if err != nil {
+		return Result{}, err
+	}
+	counts := Compare(truth, synth.PolygonMask(bounds, detected.Road))
+	r := Result{
+		Name:        name,
+		Counts:      counts,
+		IoU:         counts.IoU(),
+		Precision:   counts.Precision(),
+		Recall:      counts.Recall(),
+		CenterError: -1,
+	}
+	hasRoad := counts.TruePositives+counts.FalseNegatives > 0
+	r.RoadLost = hasRoad && r.IoU < lostIoU
+
+	center, ok := label.center(truth)
+	if ok && detected.EllipseCenter != nil {
+		r.CenterError = math.Hypot(float64(detected.EllipseCenter.X-center.X), float64(detected.EllipseCenter.Y-center.Y))
+	}
+	return r, nil
+}
+
+// center returns expected ellipse center, false if road is empty
+func (l *Label) center(truth *image.Gray) (Point, bool) {
+	if l.Center != nil {
+		return *l.Center, true
+	}
+	var sumX, sumY, count int
+	bounds := truth.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if truth.GrayAt(x, y).Y >= 128 {
+				sumX += x
+				sumY += y
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return Point{}, false
+	}
+	return Point{X: sumX / count, Y: sumY / count}, true
+}
+
+// Report aggregates results of a dataset
+type Report struct {
+	Images int `json:"images"`
+	// Counts, IoU, Precision and Recall are computed over road pixels of all images
+	Counts
+	IoU       float64 `json:"iou"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	// MeanIoU is the mean of images IoU
+	MeanIoU float64 `json:"mean_iou"`
+	// MeanCenterError is the mean of images center errors, -1 if no center error is available
+	MeanCenterError float64 `json:"mean_center_error"`
+	RoadLost        int     `json:"road_lost"`
+	RoadLostRate    float64 `json:"road_lost_rate"`
+	// Results are sorted by increasing IoU, worst images first
+	Results []Result `json:"results"`
+}
+
+// Summarize aggregates results
+func Summarize(results []Result) Report {
+	r := Report{
+		Images:          len(results),
+		MeanCenterError: -1,
+		Results:         make([]Result, len(results)),
+	}
+	copy(r.Results, results)
+	sort.SliceStable(r.Results, func(i, j int) bool { return r.Results[i].IoU < r.Results[j].IoU })
+
+	var sumIoU, sumCenterError float64
+	var centers int
+	for _, res := range results {
+		r.Counts = r.Counts.Add(res.Counts)
+		sumIoU += res.IoU
+		if res.CenterError >= 0 {
+			sumCenterError += res.CenterError
+			centers++
+		}
+		if res.RoadLost {
+			r.RoadLost++
+		}
+	}
+	r.IoU, r.Precision, r.Recall = r.Counts.IoU(), r.Counts.Precision(), r.Counts.Recall()
+	if len(results) > 0 {
+		r.MeanIoU = sumIoU / float64(len(results))
+		r.RoadLostRate = float64(r.RoadLost) / float64(len(results))
+	}
+	if centers > 0 {
+		r.MeanCenterError = sumCenterError / float64(centers)
+	}
+	return r
+}
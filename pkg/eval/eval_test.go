@@ -0,0 +1,169 @@
+package eval
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var bounds = image.Rect(0, 0, 10, 10)
+
+func rectMask(r image.Rectangle) *image.Gray {
+	mask := image.NewGray(bounds)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			mask.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	return mask
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		name                   string
+		truth, detected        *image.Gray
+		expected               Counts
+		iou, precision, recall float64
+	}{
+		{"no road", image.NewGray(bounds), image.NewGray(bounds), Counts{}, 1., 1., 1.},
+		{"road lost", rectMask(image.Rect(0, 0, 4, 5)), image.NewGray(bounds), Counts{FalseNegatives: 20}, 0., 1., 0.},
+		{"false road", image.NewGray(bounds), rectMask(image.Rect(0, 0, 2, 2)), Counts{FalsePositives: 4}, 0., 0., 1.},
+		{"exact road", rectMask(image.Rect(0, 0, 4, 5)), rectMask(image.Rect(0, 0, 4, 5)), Counts{TruePositives: 20}, 1., 1., 1.},
+		{"partial road", rectMask(image.Rect(0, 0, 4, 4)), rectMask(image.Rect(0, 2, 4, 8)),
+			Counts{TruePositives: 8, FalsePositives: 16, FalseNegatives: 8}, 8. / 32., 8. / 24., 0.5},
+	}
+	for _, c := range cases {
+		counts := Compare(c.truth, c.detected)
+		if counts != c.expected {
+			t.Errorf("[%v] bad counts: %+v, wants %+v", c.name, counts, c.expected)
+		}
+		if counts.IoU() != c.iou || counts.Precision() != c.precision || counts.Recall() != c.recall {
+			t.Errorf("[%v] bad iou/precision/recall: %v/%v/%v, wants %v/%v/%v", c.name,
+				counts.IoU(), counts.Precision(), counts.Recall(), c.iou, c.precision, c.recall)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	square := []Point{{2, 2}, {2, 5}, {5, 5}, {5, 2}}
+	detectedSquare := []image.Point{{2, 2}, {2, 5}, {5, 5}, {5, 2}}
+	cases := []struct {
+		name                string
+		label               Label
+		detected            Detected
+		expectedIoU         float64
+		expectedCenterError float64
+		expectedLost        bool
+	}{
+		{"exact detection", Label{Road: square}, Detected{Road: detectedSquare, EllipseCenter: &image.Point{X: 3, Y: 3}},
+			1., 0., false},
+		{"labeled center", Label{Road: square, Center: &Point{X: 6, Y: 7}},
+			Detected{Road: detectedSquare, EllipseCenter: &image.Point{X: 3, Y: 3}}, 1., 5., false},
+		{"no ellipse", Label{Road: square}, Detected{Road: detectedSquare}, 1., -1., false},
+		{"road lost", Label{Road: square}, Detected{}, 0., -1., true},
+		{"no road", Label{}, Detected{}, 1., -1., false},
+		{"false road", Label{}, Detected{Road: detectedSquare, EllipseCenter: &image.Point{X: 3, Y: 3}}, 0., -1., false},
+	}
+	for _, c := range cases {
+		r, err := Evaluate(c.name, &c.label, bounds, c.detected, 0.1)
+		if err != nil {
+			t.Errorf("[%v] unable to evaluate: %v", c.name, err)
+			continue
+		}
+		if r.IoU != c.expectedIoU || r.CenterError != c.expectedCenterError || r.RoadLost != c.expectedLost {
+			t.Errorf("[%v] bad result: %+v, wants iou %v, center error %v, road lost %v", c.name, r,
+				c.expectedIoU, c.expectedCenterError, c.expectedLost)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []Result{
+		{Name: "good", Counts: Counts{TruePositives: 90, FalsePositives: 10}, IoU: 0.9, CenterError: 2},
+		{Name: "lost", Counts: Counts{FalseNegatives: 100}, IoU: 0., CenterError: -1, RoadLost: true},
+		{Name: "fair", Counts: Counts{TruePositives: 60, FalseNegatives: 40}, IoU: 0.6, CenterError: 4},
+	}
+	r := Summarize(results)
+	if r.Images != 3 || r.RoadLost != 1 || math.Abs(r.RoadLostRate-1./3.) > 1e-9 {
+		t.Errorf("bad images/road lost: %v/%v/%v", r.Images, r.RoadLost, r.RoadLostRate)
+	}
+	if r.Counts != (Counts{TruePositives: 150, FalsePositives: 10, FalseNegatives: 140}) || r.IoU != 0.5 {
+		t.Errorf("bad overall counts: %+v, iou %v", r.Counts, r.IoU)
+	}
+	if math.Abs(r.MeanIoU-0.5) > 1e-9 || r.MeanCenterError != 3 {
+		t.Errorf("bad means: iou %v, center error %v", r.MeanIoU, r.MeanCenterError)
+	}
+	if r.Results[0].Name != "lost" || r.Results[2].Name != "good" || results[0].Name != "good" {
+		t.Errorf("results should be sorted by IoU on a copy: %+v", r.Results)
+	}
+
+	if empty := Summarize(nil); empty.MeanCenterError != -1 || empty.RoadLostRate != 0 {
+		t.Errorf("bad empty report: %+v", empty)
+	}
+}
+
+func TestReadLabel(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "polygon.json"), []byte(`{"road": [{"x": 2, "y": 2}, {"x": 2, "y": 5}, {"x": 5, "y": 5}, {"x": 5, "y": 2}]}`), 0644); err != nil {
+		t.Fatalf("unable to write label: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid.json"), []byte(`{"road": 3}`), 0644); err != nil {
+		t.Fatalf("unable to write label: %v", err)
+	}
+	writeMask := func(name string, mask image.Image) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unable to create mask: %v", err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, mask); err != nil {
+			t.Fatalf("unable to write mask: %v", err)
+		}
+	}
+	rgba := image.NewRGBA(bounds)
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			rgba.Set(x, y, color.RGBA{R: 1, A: 255})
+		}
+	}
+	writeMask("mask.mask.png", rgba)
+	writeMask("small.mask.png", image.NewGray(image.Rect(0, 0, 4, 4)))
+
+	expected := rectMask(image.Rect(2, 2, 6, 6))
+	cases := []struct {
+		name          string
+		image         string
+		wantNoLabel   bool
+		wantError     bool
+		wantMaskError bool
+	}{
+		{name: "json polygon", image: "polygon.jpg"},
+		{name: "png mask", image: "mask.png"},
+		{name: "unlabeled", image: "other.jpg", wantNoLabel: true, wantError: true},
+		{name: "invalid json", image: "invalid.jpg", wantError: true},
+		{name: "mask of other size", image: "small.jpg", wantMaskError: true},
+	}
+	for _, c := range cases {
+		label, err := ReadLabel(filepath.Join(dir, c.image))
+		if (err != nil) != c.wantError || errors.Is(err, ErrNoLabel) != c.wantNoLabel {
+			t.Errorf("[%v] bad error: %v", c.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		mask, err := label.Mask(bounds)
+		if (err != nil) != c.wantMaskError {
+			t.Errorf("[%v] bad mask error: %v", c.name, err)
+			continue
+		}
+		if err == nil && string(mask.Pix) != string(expected.Pix) {
+			t.Errorf("[%v] bad mask: %v, wants %v", c.name, mask.Pix, expected.Pix)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Package lane computes the road centerline from a road mask and resamples it as waypoints that a path follower
+// can consume directly, and estimates lane-keeping signals of car from the road contour
+package lane
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Spacing modes of waypoints
+const (
+	// SpacingRows spaces waypoints by a fixed number of image rows
+	SpacingRows = "rows"
+	// SpacingGround spaces waypoints by a fixed distance on ground, it requires a ground calibration
+	SpacingGround = "ground"
+)
+
+// Config defines centerline extraction and waypoints sampling
+type Config struct {
+	// Waypoints is the max number of waypoints
+	Waypoints int    `yaml:"waypoints"`
+	Spacing   string `yaml:"spacing"`
+	// Step is the distance between waypoints, in rows or cm according to spacing. If 0, waypoints are evenly
+	// spread along the whole centerline.
+	Step float64 `yaml:"step"`
+	// Smoothing is the size in rows of the moving average applied to centerline, disabled if <= 1
+	Smoothing int `yaml:"smoothing"`
+	// MinWidth is the min width in pixels of road on a row, narrower runs are ignored
+	MinWidth int `yaml:"min_width"`
+	// Homography is the ground calibration, optional with rows spacing
+	Homography Homography `yaml:"homography,omitempty"`
+}
+
+// DefaultConfig returns 10 waypoints evenly spread by rows along centerline
+func DefaultConfig() Config {
+	return Config{
+		Waypoints: 10,
+		Spacing:   SpacingRows,
+		Smoothing: 5,
+		MinWidth:  2,
+	}
+}
+
+// Validate checks config consistency
+func (c *Config) Validate() error {
+	if c.Waypoints < 1 {
+		return fmt.Errorf("invalid waypoints count %v, should be >= 1", c.Waypoints)
+	}
+	if c.Spacing != SpacingRows && c.Spacing != SpacingGround {
+		return fmt.Errorf("invalid waypoints spacing '%v', should be %v or %v", c.Spacing, SpacingRows, SpacingGround)
+	}
+	if c.Step < 0 {
+		return fmt.Errorf("invalid waypoints step %v, should be >= 0", c.Step)
+	}
+	if c.Smoothing < 0 {
+		return fmt.Errorf("invalid centerline smoothing %v, should be >= 0", c.Smoothing)
+	}
+	if c.MinWidth < 1 {
+		return fmt.Errorf("invalid road min width %v, should be >= 1", c.MinWidth)
+	}
+	if err := c.Homography.Validate(); err != nil {
+		return err
+	}
+	if c.Spacing == SpacingGround && !c.Homography.Calibrated() {
+		return fmt.Errorf("%v spacing of waypoints requires a ground homography", SpacingGround)
+	}
+	return nil
+}
+
+// Homography is a 3x3 matrix, in row-major order, that projects image pixels to ground plane coordinates in cm:
+// x to the right of camera axis and y forward. Ground pixels should have a positive projective coordinate w.
+// An empty Homography means no ground calibration.
+type Homography []float64
+
+// Calibrated returns true if h projects pixels to ground
+func (h Homography) Calibrated() bool {
+	return len(h) == 9
+}
+
+// Validate checks h is empty or a 3x3 matrix
+func (h Homography) Validate() error {
+	if len(h) != 0 && len(h) != 9 {
+		return fmt.Errorf("invalid ground homography of %v values, should have 9 values", len(h))
+	}
+	for _, v := range h {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("invalid ground homography value %v", v)
+		}
+	}
+	return nil
+}
+
+// Project returns ground coordinates of pixel (x, y). ok is false if pixel is above ground horizon or h is not
+// calibrated.
+func (h Homography) Project(x, y float64) (gx, gy float64, ok bool) {
+	if !h.Calibrated() {
+		return 0, 0, false
+	}
+	w := h.w(x, y)
+	if w <= 0 {
+		return 0, 0, false
+	}
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w, true
+}
+
+// w returns the projective coordinate of pixel (x, y), h should be calibrated
+func (h Homography) w(x, y float64) float64 {
+	return h[6]*x + h[7]*y + h[8]
+}
+
+// String returns values as comma separated list
+func (h *Homography) String() string {
+	if h == nil {
+		return ""
+	}
+	values := make([]string, 0, len(*h))
+	for _, v := range *h {
+		values = append(values, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses a comma separated list of 9 values, empty value removes calibration
+func (h *Homography) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		*h = nil
+		return nil
+	}
+	fields := strings.Split(value, ",")
+	values := make(Homography, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return fmt.Errorf("invalid ground homography value '%v': %w", f, err)
+		}
+		values = append(values, v)
+	}
+	if err := values.Validate(); err != nil {
+		return err
+	}
+	*h = values
+	return nil
+}
+
+// Point is a position in pixels on image, or in cm on ground
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Waypoint is a centerline position on image, in pixels
+type Waypoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// Ground is the waypoint position on ground, in cm, only with ground calibration
+	Ground *Point `json:"ground,omitempty"`
+}
+
+// Extractor computes waypoints from road masks
+type Extractor struct {
+	config Config
+}
+
+// NewExtractor returns an Extractor, config is validated
+func NewExtractor(config Config) (*Extractor, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Extractor{config: config}, nil
+}
+
+func (e *Extractor) Config() Config {
+	return e.config
+}
+
+// Waypoints returns waypoints of road centerline on mask, from bottom to top of image, empty if no road is found.
+// Mask pixels different from 0 are road.
+func (e *Extractor) Waypoints(mask *image.Gray) []Waypoint {
+	centerline := Smooth(Centerline(mask, e.config.MinWidth), e.config.Smoothing)
+	return Resample(centerline, e.config)
+}
+
+// run is a range [start, end) of road pixels on a mask row
+type run struct {
+	start, end int
+}
+
+func (r run) overlap(o run) int {
+	end, start := r.end, r.start
+	if o.end < end {
+		end = o.end
+	}
+	if o.start > start {
+		start = o.start
+	}
+	return end - start
+}
+
+// Centerline returns the row-wise midpoints of road on mask, from bottom to top. Road starts at the widest run of
+// road pixels of the lowest row that contains one, and is followed row by row through the run that overlaps most
+// the previous one. Centerline ends at the first row without connected run. Runs narrower than minWidth are
+// ignored.
+func Centerline(mask *image.Gray, minWidth int) []Point {
+	b := mask.Bounds()
+	centers := make([]Point, 0, b.Dy())
+	var previous run
+	tracking := false
+	for y := b.Max.Y - 1; y >= b.Min.Y; y-- {
+		runs := rowRuns(mask, y, minWidth)
+		best, bestScore := run{}, 0
+		for _, r := range runs {
+			score := r.end - r.start
+			if tracking {
+				score = r.overlap(previous)
+			}
+			if score > bestScore {
+				best, bestScore = r, score
+			}
+		}
+		if bestScore == 0 {
+			if tracking {
+				break
+			}
+			continue
+		}
+		tracking = true
+		previous = best
+		centers = append(centers, Point{X: float64(best.start+best.end-1) / 2, Y: float64(y)})
+	}
+	return centers
+}
+
+// rowRuns returns runs of road pixels of row y that are at least minWidth wide
+func rowRuns(mask *image.Gray, y, minWidth int) []run {
+	b := mask.Bounds()
+	row := mask.Pix[mask.PixOffset(b.Min.X, y) : mask.PixOffset(b.Max.X-1, y)+1]
+	var runs []run
+	start := -1
+	for i := 0; i <= len(row); i++ {
+		road := i < len(row) && row[i] != 0
+		switch {
+		case road && start < 0:
+			start = i
+		case !road && start >= 0:
+			if i-start >= minWidth {
+				runs = append(runs, run{start: b.Min.X + start, end: b.Min.X + i})
+			}
+			start = -1
+		}
+	}
+	return runs
+}
+
+// Smooth returns a copy of centerline with x coordinates averaged on a centered window of size rows. Window is
+// shrunk near centerline ends, so ends are not moved.
+func Smooth(centerline []Point, size int) []Point {
+	smoothed := make([]Point, len(centerline))
+	copy(smoothed, centerline)
+	half := size / 2
+	if half < 1 {
+		return smoothed
+	}
+	for i := range centerline {
+		k := half
+		if i < k {
+			k = i
+		}
+		if len(centerline)-1-i < k {
+			k = len(centerline) - 1 - i
+		}
+		sum := 0.
+		for j := i - k; j <= i+k; j++ {
+			sum += centerline[j].X
+		}
+		smoothed[i].X = sum / float64(2*k+1)
+	}
+	return smoothed
+}
+
+// Resample returns up to config.Waypoints waypoints along centerline, from its first point. Distance between
+// waypoints is measured in rows or in cm on ground according to config.Spacing. With ground spacing, centerline is
+// truncated at the first point above ground horizon.
+func Resample(centerline []Point, config Config) []Waypoint {
+	// distances are measured along centerline from its first point, only rows count with rows spacing
+	distances := make([]float64, 0, len(centerline))
+	var last Point
+	for i, p := range centerline {
+		position := Point{Y: p.Y}
+		if config.Spacing == SpacingGround {
+			gx, gy, ok := config.Homography.Project(p.X, p.Y)
+			if !ok {
+				break
+			}
+			position = Point{X: gx, Y: gy}
+		}
+		if i == 0 {
+			distances = append(distances, 0)
+		} else {
+			distances = append(distances, distances[i-1]+math.Hypot(position.X-last.X, position.Y-last.Y))
+		}
+		last = position
+	}
+	if len(distances) == 0 {
+		return []Waypoint{}
+	}
+	centerline = centerline[:len(distances)]
+
+	total := distances[len(distances)-1]
+	step := config.Step
+	if step == 0 && config.Waypoints > 1 {
+		step = total / float64(config.Waypoints-1)
+	}
+	waypoints := make([]Waypoint, 0, config.Waypoints)
+	segment := 0
+	for k := 0; k < config.Waypoints; k++ {
+		d := float64(k) * step
+		if d > total+1e-9 || (k > 0 && step == 0) {
+			break
+		}
+		for segment < len(distances)-2 && distances[segment+1] < d {
+			segment++
+		}
+		p := centerline[segment]
+		if segment+1 < len(distances) && distances[segment+1] > distances[segment] {
+			t := math.Min(1, (d-distances[segment])/(distances[segment+1]-distances[segment]))
+			next := centerline[segment+1]
+			if config.Spacing == SpacingGround {
+				// Perspective correct interpolation: t is a fraction of segment on ground, convert it on image
+				w0, w1 := config.Homography.w(p.X, p.Y), config.Homography.w(next.X, next.Y)
+				t = t * w0 / ((1-t)*w1 + t*w0)
+			}
+			p = Point{X: p.X + t*(next.X-p.X), Y: p.Y + t*(next.Y-p.Y)}
+		}
+		waypoint := Waypoint{X: p.X, Y: p.Y}
+		if gx, gy, ok := config.Homography.Project(p.X, p.Y); ok {
+			waypoint.Ground = &Point{X: gx, Y: gy}
+		}
+		waypoints = append(waypoints, waypoint)
+	}
+	return waypoints
+}
@@ -0,0 +1,295 @@
+package lane
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// drawMask returns a mask of width x height with road pixels on each row y in [left(y), right(y))
+func drawMask(width, height int, road func(y int) (left, right int)) *image.Gray {
+	mask := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		left, right := road(y)
+		for x := left; x < right; x++ {
+			if x >= 0 && x < width {
+				mask.Pix[mask.PixOffset(x, y)] = 255
+			}
+		}
+	}
+	return mask
+}
+
+// groundHomography projects pixels of a camera looking at ground with horizon at row 20: ground y is inversely
+// proportional to distance from horizon, and x is scaled with y
+var groundHomography = Homography{1, 0, -80, 0, 0, 1000, 0, 1, -20}
+
+func TestConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		update  func(c *Config)
+		isValid bool
+	}{
+		{"default", func(c *Config) {}, true},
+		{"no waypoint", func(c *Config) { c.Waypoints = 0 }, false},
+		{"unknown spacing", func(c *Config) { c.Spacing = "meters" }, false},
+		{"negative step", func(c *Config) { c.Step = -1 }, false},
+		{"negative smoothing", func(c *Config) { c.Smoothing = -1 }, false},
+		{"no min width", func(c *Config) { c.MinWidth = 0 }, false},
+		{"ground without calibration", func(c *Config) { c.Spacing = SpacingGround }, false},
+		{"ground", func(c *Config) { c.Spacing = SpacingGround; c.Homography = groundHomography }, true},
+		{"bad homography size", func(c *Config) { c.Homography = Homography{1, 0, 0, 1} }, false},
+		{"bad homography value", func(c *Config) { c.Homography = Homography{1, 0, 0, 0, 1, 0, 0, 0, math.NaN()} }, false},
+	}
+	for _, c := range cases {
+		config := DefaultConfig()
+		c.update(&config)
+		if err := config.Validate(); (err == nil) != c.isValid {
+			t.Errorf("[%v] bad validation: %v, wants valid = %v", c.name, err, c.isValid)
+		}
+	}
+}
+
+func TestHomography_Set(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    string
+		expected Homography
+		isValid  bool
+	}{
+		{"empty", " ", nil, true},
+		{"matrix", "1, 0,-80,0,0,1000,0,1,-20", groundHomography, true},
+		{"too short", "1,0,0", nil, false},
+		{"not a number", "1,0,0,0,1,0,0,0,a", nil, false},
+	}
+	for _, c := range cases {
+		h := Homography{1, 2, 3, 4, 5, 6, 7, 8, 9}
+		err := h.Set(c.value)
+		if (err == nil) != c.isValid {
+			t.Errorf("[%v] bad error: %v, wants valid = %v", c.name, err, c.isValid)
+			continue
+		}
+		if c.isValid && h.String() != c.expected.String() {
+			t.Errorf("[%v] bad homography: %v, wants %v", c.name, h.String(), c.expected.String())
+		}
+	}
+}
+
+func TestHomography_Project(t *testing.T) {
+	cases := []struct {
+		name       string
+		x, y       float64
+		expectedOk bool
+		gx, gy     float64
+	}{
+		{"bottom center", 80, 120, true, 0, 10},
+		{"far right", 100, 30, true, 2, 100},
+		{"horizon", 80, 20, false, 0, 0},
+		{"above horizon", 80, 10, false, 0, 0},
+	}
+	for _, c := range cases {
+		gx, gy, ok := groundHomography.Project(c.x, c.y)
+		if ok != c.expectedOk || math.Abs(gx-c.gx) > 1e-9 || math.Abs(gy-c.gy) > 1e-9 {
+			t.Errorf("[%v] bad projection: (%v, %v, %v), wants (%v, %v, %v)", c.name, gx, gy, ok, c.gx, c.gy, c.expectedOk)
+		}
+	}
+	if _, _, ok := Homography(nil).Project(80, 120); ok {
+		t.Errorf("pixel projected without calibration")
+	}
+}
+
+func TestCenterline(t *testing.T) {
+	cases := []struct {
+		name     string
+		mask     *image.Gray
+		minWidth int
+		// expected are x centers from bottom row, nil if no road is expected
+		expected []float64
+		bottom   int
+	}{
+		{name: "empty", mask: drawMask(10, 4, func(int) (int, int) { return 0, 0 }), minWidth: 1},
+		{name: "straight", mask: drawMask(10, 4, func(int) (int, int) { return 2, 7 }), minWidth: 1,
+			expected: []float64{4, 4, 4, 4}, bottom: 3},
+		{name: "shifting", mask: drawMask(10, 4, func(y int) (int, int) { return y, y + 4 }), minWidth: 1,
+			expected: []float64{4.5, 3.5, 2.5, 1.5}, bottom: 3},
+		{name: "hood on bottom rows", mask: drawMask(10, 4, func(y int) (int, int) {
+			if y >= 2 {
+				return 0, 0
+			}
+			return 0, 4
+		}), minWidth: 1, expected: []float64{1.5, 1.5}, bottom: 1},
+		{name: "disconnected blob ignored", mask: drawMask(10, 4, func(y int) (int, int) {
+			if y == 0 {
+				return 7, 10
+			}
+			return 0, 4
+		}), minWidth: 1, expected: []float64{1.5, 1.5, 1.5}, bottom: 3},
+		{name: "noise narrower than min width", mask: drawMask(10, 4, func(y int) (int, int) {
+			if y == 3 {
+				return 8, 9
+			}
+			return 2, 6
+		}), minWidth: 2, expected: []float64{3.5, 3.5, 3.5}, bottom: 2},
+	}
+	for _, c := range cases {
+		centerline := Centerline(c.mask, c.minWidth)
+		if len(centerline) != len(c.expected) {
+			t.Errorf("[%v] bad centerline: %v, wants x = %v", c.name, centerline, c.expected)
+			continue
+		}
+		for i, p := range centerline {
+			if p.X != c.expected[i] || p.Y != float64(c.bottom-i) {
+				t.Errorf("[%v] bad centerline point %v: %v, wants (%v, %v)", c.name, i, p, c.expected[i], c.bottom-i)
+			}
+		}
+	}
+}
+
+func TestCenterline_FollowsWidestBottomRun(t *testing.T) {
+	mask := drawMask(20, 3, func(int) (int, int) { return 10, 18 })
+	// Narrow run on the left of bottom row
+	mask.Pix[mask.PixOffset(1, 2)], mask.Pix[mask.PixOffset(2, 2)] = 255, 255
+
+	centerline := Centerline(mask, 1)
+	if len(centerline) != 3 || centerline[0].X != 13.5 {
+		t.Errorf("bad centerline: %v, wants to follow run [10, 18)", centerline)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	centerline := []Point{{0, 4}, {4, 3}, {2, 2}, {6, 1}, {8, 0}}
+
+	if smoothed := Smooth(centerline, 1); smoothed[1].X != 4 {
+		t.Errorf("centerline smoothed with window 1: %v", smoothed)
+	}
+	smoothed := Smooth(centerline, 3)
+	expected := []float64{0, 2, 4, 16. / 3, 8}
+	for i, p := range smoothed {
+		if math.Abs(p.X-expected[i]) > 1e-9 || p.Y != centerline[i].Y {
+			t.Errorf("bad smoothed point %v: %v, wants x = %v", i, p, expected[i])
+		}
+	}
+	if centerline[1].X != 4 {
+		t.Errorf("centerline modified by Smooth: %v", centerline)
+	}
+}
+
+func TestResample(t *testing.T) {
+	// Road center moves 1 pixel right each row up, from row 120 to row 21
+	var centerline []Point
+	for y := 120; y > 20; y-- {
+		centerline = append(centerline, Point{X: 80 + float64(120-y), Y: float64(y)})
+	}
+
+	rows := DefaultConfig()
+	rowsStep := rows
+	rowsStep.Step = 30
+	ground := DefaultConfig()
+	ground.Spacing = SpacingGround
+	ground.Homography = groundHomography
+	ground.Waypoints = 4
+	groundStep := ground
+	groundStep.Step = 20
+	groundStep.Waypoints = 100
+
+	cases := []struct {
+		name       string
+		centerline []Point
+		config     Config
+		// expectedY are rows of waypoints
+		expectedY []float64
+	}{
+		{"no road", nil, rows, nil},
+		{"single point", centerline[:1], rows, []float64{120}},
+		{"evenly spread rows", centerline, rows, []float64{120, 109, 98, 87, 76, 65, 54, 43, 32, 21}},
+		{"rows step", centerline, rowsStep, []float64{120, 90, 60, 30}},
+		{"one waypoint", centerline, Config{Waypoints: 1, Spacing: SpacingRows, MinWidth: 1}, []float64{120}},
+	}
+	for _, c := range cases {
+		waypoints := Resample(c.centerline, c.config)
+		if waypoints == nil {
+			t.Errorf("[%v] nil waypoints, wants empty slice", c.name)
+		}
+		if len(waypoints) != len(c.expectedY) {
+			t.Errorf("[%v] bad waypoints: %v, wants rows %v", c.name, waypoints, c.expectedY)
+			continue
+		}
+		for i, w := range waypoints {
+			if math.Abs(w.Y-c.expectedY[i]) > 1e-9 || math.Abs(w.X-(80+120-w.Y)) > 1e-9 {
+				t.Errorf("[%v] bad waypoint %v: %v, wants row %v on centerline", c.name, i, w, c.expectedY[i])
+			}
+			if w.Ground != nil {
+				t.Errorf("[%v] ground position without calibration: %v", c.name, w.Ground)
+			}
+		}
+	}
+
+	for _, config := range []Config{ground, groundStep} {
+		waypoints := Resample(centerline, config)
+		if len(waypoints) < 2 || len(waypoints) > config.Waypoints {
+			t.Errorf("[%v] bad ground waypoints count: %v", config, len(waypoints))
+			continue
+		}
+		spacing := distance(waypoints[0].Ground, waypoints[1].Ground)
+		if config.Step > 0 && math.Abs(spacing-config.Step) > 0.1 {
+			t.Errorf("[%v] bad ground spacing: %v, wants %v", config, spacing, config.Step)
+		}
+		for i := 1; i < len(waypoints); i++ {
+			if d := distance(waypoints[i-1].Ground, waypoints[i].Ground); math.Abs(d-spacing) > 0.1 {
+				t.Errorf("[%v] waypoints %v not evenly spaced on ground: %v, wants %v", config, i, d, spacing)
+			}
+		}
+	}
+}
+
+func TestResample_AboveGroundHorizon(t *testing.T) {
+	config := DefaultConfig()
+	config.Spacing = SpacingGround
+	config.Homography = groundHomography
+
+	if waypoints := Resample([]Point{{80, 15}, {80, 10}}, config); len(waypoints) != 0 {
+		t.Errorf("waypoints above horizon: %v", waypoints)
+	}
+	waypoints := Resample([]Point{{80, 40}, {80, 30}, {80, 20}, {80, 10}}, config)
+	for _, w := range waypoints {
+		if w.Y < 30 || w.Ground == nil {
+			t.Errorf("bad waypoint on ground: %v", w)
+		}
+	}
+}
+
+func TestExtractor_Waypoints(t *testing.T) {
+	if _, err := NewExtractor(Config{}); err == nil {
+		t.Errorf("extractor created with invalid config")
+	}
+	extractor, err := NewExtractor(DefaultConfig())
+	if err != nil {
+		t.Fatalf("unable to create extractor: %v", err)
+	}
+
+	// Road is a trapezoid that narrows to the top of image and bends to the right
+	mask := drawMask(160, 120, func(y int) (int, int) {
+		if y < 30 {
+			return 0, 0
+		}
+		half := 10 + (y-30)*2/3
+		center := 100 - (y-30)/3
+		return center - half, center + half
+	})
+	waypoints := extractor.Waypoints(mask)
+	if len(waypoints) != 10 {
+		t.Fatalf("bad waypoints count: %v, wants 10", len(waypoints))
+	}
+	if waypoints[0].Y != 119 || waypoints[9].Y != 30 {
+		t.Errorf("bad waypoints range: [%v, %v], wants [119, 30]", waypoints[0].Y, waypoints[9].Y)
+	}
+	for i := 1; i < len(waypoints); i++ {
+		if waypoints[i].X < waypoints[i-1].X {
+			t.Errorf("waypoint %v doesn't bend to the right: %v after %v", i, waypoints[i], waypoints[i-1])
+		}
+	}
+}
+
+func distance(a, b *Point) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
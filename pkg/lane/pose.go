@@ -0,0 +1,165 @@
+package lane
+
+import (
+	"image"
+	"math"
+)
+
+// Pose holds lane-keeping signals of car relative to road. Camera is assumed on car axis, looking forward: car is at
+// the bottom center of image.
+type Pose struct {
+	// Offset is the lateral offset of car from road center at the bottom of image, normalized by road half width:
+	// 0 on road center, 1 on right road edge and -1 on left one
+	Offset float64 `json:"offset"`
+	// Heading is the angle of road direction relative to camera axis, normalized by 90°: positive when road goes to
+	// the right
+	Heading float64 `json:"heading"`
+	// OffsetCm and HeadingDeg are measured on ground, only with ground calibration
+	OffsetCm   *float64 `json:"offset_cm,omitempty"`
+	HeadingDeg *float64 `json:"heading_deg,omitempty"`
+	// Confidence is between 0, road not found, and 1
+	Confidence float64 `json:"confidence"`
+}
+
+// span is the road extent [left, right] on row y
+type span struct {
+	y           int
+	left, right float64
+}
+
+func (s span) center() float64 {
+	return (s.left + s.right) / 2
+}
+
+// EstimatePose returns pose of car on road of contour, the road polygon on an image of size pixels. Heading is
+// fitted on the lower half of road centerline, the closest one to the car. Ground measures are computed if
+// homography is calibrated and road bottom is on ground.
+//
+// Confidence is the product of 3 factors: road reaches the bottom of image, road is visible on at least a quarter
+// of image height, and road centerline is straight enough to be fitted by a line.
+func EstimatePose(contour []image.Point, size image.Point, homography Homography) Pose {
+	spans := polygonSpans(contour)
+	if len(spans) < 2 || size.X <= 0 || size.Y <= 0 {
+		return Pose{}
+	}
+
+	bottom := spans[0]
+	carX := float64(size.X-1) / 2
+	pose := Pose{}
+	if halfWidth := (bottom.right - bottom.left) / 2; halfWidth > 0 {
+		pose.Offset = (carX - bottom.center()) / halfWidth
+	}
+
+	near := spans[:(len(spans)+1)/2]
+	if len(near) < 2 {
+		near = spans[:2]
+	}
+	xs := make([]float64, 0, len(near))
+	ys := make([]float64, 0, len(near))
+	for _, s := range near {
+		xs = append(xs, s.center())
+		ys = append(ys, float64(s.y))
+	}
+	// Going up in image, x moves of -slope by row
+	slope, _, rmse := fitLine(ys, xs)
+	pose.Heading = math.Atan(-slope) / (math.Pi / 2)
+
+	if gx, _, ok := homography.Project(carX, float64(bottom.y)); ok {
+		cx, _, _ := homography.Project(bottom.center(), float64(bottom.y))
+		offset := gx - cx
+		pose.OffsetCm = &offset
+
+		gxs := make([]float64, 0, len(near))
+		gys := make([]float64, 0, len(near))
+		for _, s := range near {
+			if x, y, ok := homography.Project(s.center(), float64(s.y)); ok {
+				gxs = append(gxs, x)
+				gys = append(gys, y)
+			}
+		}
+		if len(gxs) >= 2 {
+			groundSlope, _, _ := fitLine(gys, gxs)
+			heading := math.Atan(groundSlope) * 180 / math.Pi
+			pose.HeadingDeg = &heading
+		}
+	}
+
+	height := float64(size.Y)
+	gap := float64(size.Y-1-bottom.y) / (height / 2)
+	length := float64(len(spans)) / (height / 4)
+	straightness := 1.
+	if halfWidth := (bottom.right - bottom.left) / 2; halfWidth > 0 {
+		straightness = 1 / (1 + rmse/halfWidth)
+	}
+	pose.Confidence = clamp(1-gap) * clamp(length) * straightness
+	return pose
+}
+
+// polygonSpans returns the horizontal extent of polygon on each row, from the bottom row to the top one
+func polygonSpans(polygon []image.Point) []span {
+	if len(polygon) < 3 {
+		return nil
+	}
+	minY, maxY := polygon[0].Y, polygon[0].Y
+	for _, p := range polygon {
+		if p.Y < minY {
+			minY = p.Y
+		}
+		if p.Y > maxY {
+			maxY = p.Y
+		}
+	}
+
+	spans := make([]span, 0, maxY-minY+1)
+	for y := maxY; y >= minY; y-- {
+		s := span{y: y, left: math.Inf(1), right: math.Inf(-1)}
+		for i, p1 := range polygon {
+			p2 := polygon[(i+1)%len(polygon)]
+			if y < p1.Y && y < p2.Y || y > p1.Y && y > p2.Y {
+				continue
+			}
+			xs := []float64{float64(p1.X), float64(p2.X)}
+			if p1.Y != p2.Y {
+				x := float64(p1.X) + float64(y-p1.Y)*float64(p2.X-p1.X)/float64(p2.Y-p1.Y)
+				xs = []float64{x}
+			}
+			for _, x := range xs {
+				s.left = math.Min(s.left, x)
+				s.right = math.Max(s.right, x)
+			}
+		}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// fitLine returns the least squares line y = slope * x + intercept and the root mean square of residuals
+func fitLine(xs, ys []float64) (slope, intercept, rmse float64) {
+	n := float64(len(xs))
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var sxx, sxy float64
+	for i := range xs {
+		sxx += (xs[i] - meanX) * (xs[i] - meanX)
+		sxy += (xs[i] - meanX) * (ys[i] - meanY)
+	}
+	if sxx > 0 {
+		slope = sxy / sxx
+	}
+	intercept = meanY - slope*meanX
+
+	var sse float64
+	for i := range xs {
+		r := ys[i] - (slope*xs[i] + intercept)
+		sse += r * r
+	}
+	return slope, intercept, math.Sqrt(sse / n)
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,106 @@
+package lane
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// poseHomography projects pixels of a 160x128 camera with horizon at row 20, 1cm on ground at row 30 is 1 pixel
+var poseHomography = Homography{10, 0, -800, 0, 0, 1000, 0, 1, -20}
+
+func TestEstimatePose(t *testing.T) {
+	size := image.Point{X: 160, Y: 128}
+	cases := []struct {
+		name    string
+		contour []image.Point
+		// check validates estimated pose
+		check func(p Pose) bool
+	}{
+		{name: "no road",
+			check: func(p Pose) bool { return p == Pose{} }},
+		{name: "centered straight road", contour: []image.Point{{40, 127}, {119, 127}, {95, 30}, {64, 30}},
+			check: func(p Pose) bool {
+				return math.Abs(p.Offset) < 1e-9 && math.Abs(p.Heading) < 1e-9 && math.Abs(p.Confidence-1) < 1e-9 &&
+					p.OffsetCm == nil && p.HeadingDeg == nil
+			}},
+		{name: "car on left of road", contour: []image.Point{{60, 127}, {139, 127}, {115, 30}, {84, 30}},
+			check: func(p Pose) bool { return math.Abs(p.Offset-(-20./39.5)) < 1e-9 && math.Abs(p.Heading) < 1e-9 }},
+		{name: "car on right edge", contour: []image.Point{{0, 127}, {79, 127}, {55, 30}, {24, 30}},
+			check: func(p Pose) bool { return math.Abs(p.Offset-(40./39.5)) < 1e-9 }},
+		{name: "road turning right", contour: []image.Point{{40, 127}, {119, 127}, {135, 30}, {104, 30}},
+			check: func(p Pose) bool {
+				// Center moves 40 pixels right over 97 rows
+				return math.Abs(p.Heading-math.Atan(40./97)/(math.Pi/2)) < 0.01 && math.Abs(p.Offset) < 1e-9
+			}},
+		{name: "road turning left", contour: []image.Point{{40, 127}, {119, 127}, {55, 30}, {24, 30}},
+			check: func(p Pose) bool { return p.Heading < -0.2 }},
+		{name: "road far from car", contour: []image.Point{{40, 80}, {119, 80}, {95, 30}, {64, 30}},
+			check: func(p Pose) bool { return p.Confidence > 0.2 && p.Confidence < 0.3 }},
+		{name: "short road", contour: []image.Point{{40, 127}, {119, 127}, {95, 112}, {64, 112}},
+			check: func(p Pose) bool { return math.Abs(p.Confidence-0.5) < 1e-9 }},
+		{name: "curved road", contour: []image.Point{{40, 127}, {119, 127}, {125, 110}, {130, 90}, {100, 80}, {60, 90}, {65, 110}},
+			check: func(p Pose) bool { return p.Heading > 0.3 && p.Confidence < 1 && p.Confidence > 0.9 }},
+	}
+	for _, c := range cases {
+		pose := EstimatePose(c.contour, size, nil)
+		if !c.check(pose) {
+			t.Errorf("[%v] bad pose: %+v", c.name, pose)
+		}
+	}
+}
+
+func TestEstimatePose_Ground(t *testing.T) {
+	size := image.Point{X: 160, Y: 128}
+
+	// Straight road on ground from 2cm on the left to 4cm on the right of camera axis: on image, it tilts
+	// toward vanishing point
+	road := []image.Point{{59, 127}, {123, 127}, {88, 40}, {76, 40}}
+	pose := EstimatePose(road, size, poseHomography)
+	if pose.OffsetCm == nil || pose.HeadingDeg == nil {
+		t.Fatalf("no ground measures: %+v", pose)
+	}
+	if math.Abs(*pose.OffsetCm-(-1.07)) > 0.05 {
+		t.Errorf("bad ground offset: %v, wants -1.07", *pose.OffsetCm)
+	}
+	if math.Abs(*pose.HeadingDeg) > 1 {
+		t.Errorf("bad ground heading: %v, wants 0", *pose.HeadingDeg)
+	}
+	if pose.Heading >= 0 {
+		t.Errorf("bad image heading: %v, wants < 0", pose.Heading)
+	}
+
+	// Road turning right on ground: 1cm right for 1cm forward
+	road = nil
+	for _, g := range []Point{{-2, 10}, {2, 10}, {42, 50}, {38, 50}} {
+		x, y := 80+g.X*(1000/g.Y)/10, 20+1000/g.Y
+		road = append(road, image.Point{X: int(math.Round(x)), Y: int(math.Round(y))})
+	}
+	pose = EstimatePose(road, size, poseHomography)
+	if pose.HeadingDeg == nil || math.Abs(*pose.HeadingDeg-45) > 3 {
+		t.Errorf("bad ground heading of turning road: %v, wants 45", pose.HeadingDeg)
+	}
+
+	// Road bottom above ground horizon
+	pose = EstimatePose([]image.Point{{40, 15}, {119, 15}, {95, 5}, {64, 5}}, size, poseHomography)
+	if pose.OffsetCm != nil || pose.HeadingDeg != nil {
+		t.Errorf("ground measures above horizon: %+v", pose)
+	}
+}
+
+func TestPolygonSpans(t *testing.T) {
+	spans := polygonSpans([]image.Point{{0, 4}, {8, 4}, {6, 0}, {2, 0}})
+	if len(spans) != 5 {
+		t.Fatalf("bad spans count: %v, wants 5", len(spans))
+	}
+	for i, s := range spans {
+		y := 4 - i
+		left, right := 2-float64(y)/2, 6+float64(y)/2
+		if s.y != y || s.left != left || s.right != right {
+			t.Errorf("bad span %v: %+v, wants {%v %v %v}", i, s, y, left, right)
+		}
+	}
+	if spans := polygonSpans([]image.Point{{0, 4}, {8, 4}}); spans != nil {
+		t.Errorf("spans of segment: %v", spans)
+	}
+}
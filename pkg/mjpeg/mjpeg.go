@@ -0,0 +1,100 @@
+package mjpeg
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+)
+
+const boundary = "frame"
+
+// Stream broadcasts jpeg images to http clients as a MJPEG (multipart/x-mixed-replace) stream.
+//
+// Only the latest image is kept: a slow client skips intermediate frames instead of slowing down producer.
+type Stream struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	frame   []byte
+}
+
+func NewStream() *Stream {
+	return &Stream{
+		clients: make(map[chan []byte]struct{}),
+	}
+}
+
+// HasClients returns true if at least one http client is reading the stream.
+// Producers should use it to skip image encoding when nobody watches.
+func (s *Stream) HasClients() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients) > 0
+}
+
+// Update replaces the current image and sends it to all connected clients
+func (s *Stream) Update(jpeg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frame = jpeg
+	for c := range s.clients {
+		select {
+		case <-c:
+			// drop previous frame not yet consumed
+		default:
+		}
+		c <- jpeg
+	}
+}
+
+func (s *Stream) register() chan []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := make(chan []byte, 1)
+	if s.frame != nil {
+		c <- s.frame
+	}
+	s.clients[c] = struct{}{}
+	return c
+}
+
+func (s *Stream) unregister(c chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := s.register()
+	defer s.unregister(c)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case jpeg := <-c:
+			_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, len(jpeg))
+			if err == nil {
+				_, err = w.Write(jpeg)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				zap.S().Debugf("unable to write mjpeg frame to %v, close stream: %v", r.RemoteAddr, err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package mjpeg
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStream_ServeHTTP(t *testing.T) {
+	s := NewStream()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	if s.HasClients() {
+		t.Errorf("HasClients() without connected client: true, wants false")
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to connect to stream: %v", err)
+	}
+
+	waitFor(t, "client registration", func() bool { return s.HasClients() })
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("bad content-type: %v", err)
+	}
+	if mediaType != "multipart/x-mixed-replace" {
+		t.Errorf("bad media type: %v, wants multipart/x-mixed-replace", mediaType)
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+
+	frames := [][]byte{[]byte("frame1"), []byte("frame2")}
+	for _, frame := range frames {
+		s.Update(frame)
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("unable to read next part: %v", err)
+		}
+		if part.Header.Get("Content-Type") != "image/jpeg" {
+			t.Errorf("bad part content-type: %v, wants image/jpeg", part.Header.Get("Content-Type"))
+		}
+		// Part is only terminated by next boundary, read expected size
+		content := make([]byte, len(frame))
+		if _, err := io.ReadFull(part, content); err != nil {
+			t.Fatalf("unable to read part: %v", err)
+		}
+		if !bytes.Equal(content, frame) {
+			t.Errorf("bad frame: %s, wants %s", content, frame)
+		}
+	}
+
+	_ = resp.Body.Close()
+	waitFor(t, "client deregistration", func() bool { return !s.HasClients() })
+}
+
+func TestStream_LatestFrameOnConnect(t *testing.T) {
+	s := NewStream()
+	s.Update([]byte("old"))
+	s.Update([]byte("latest"))
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to connect to stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	part, err := multipart.NewReader(resp.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("unable to read first part: %v", err)
+	}
+	content := make([]byte, len("latest"))
+	_, _ = io.ReadFull(part, content)
+	if string(content) != "latest" {
+		t.Errorf("bad first frame: %s, wants latest", content)
+	}
+}
+
+func waitFor(t *testing.T, name string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
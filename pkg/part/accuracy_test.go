@@ -0,0 +1,59 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-road/pkg/synth"
+	"gocv.io/x/gocv"
+	"testing"
+)
+
+func TestRoadDetector_Accuracy(t *testing.T) {
+	cases := []struct {
+		name   string
+		update func(s *synth.Spec)
+		// minIoU is the min intersection over union between detected road contour and ground truth
+		minIoU float64
+	}{
+		{"straight", func(_ *synth.Spec) {}, 0.8},
+		{"left curve", func(s *synth.Spec) { s.Shape = synth.LeftCurve }, 0.8},
+		{"right curve", func(s *synth.Spec) { s.Shape = synth.RightCurve }, 0.8},
+		{"s-bend", func(s *synth.Spec) { s.Shape = synth.SBend }, 0.75},
+		{"narrow road", func(s *synth.Spec) { s.BottomWidth, s.TopWidth = 50, 16 }, 0.7},
+		{"wide road", func(s *synth.Spec) { s.BottomWidth, s.TopWidth = 150, 70 }, 0.8},
+		{"road on the left", func(s *synth.Spec) { s.Offset = -0.2 }, 0.8},
+		{"road on the right", func(s *synth.Spec) { s.Offset, s.Shape = 0.2, synth.LeftCurve }, 0.8},
+		{"lighting gradient", func(s *synth.Spec) { s.Gradient = 0.3 }, 0.75},
+		{"noise", func(s *synth.Spec) { s.Noise = 10 }, 0.75},
+		{"shadows", func(s *synth.Spec) { s.GroundLevel, s.Shadows, s.ShadowLevel = 240, 4, 0.9 }, 0.75},
+		{"curve with noise and shadows", func(s *synth.Spec) {
+			s.Shape, s.GroundLevel, s.Noise, s.Shadows, s.ShadowLevel = synth.RightCurve, 240, 8, 3, 0.9
+		}, 0.7},
+	}
+
+	rd := NewRoadDetector()
+	defer rd.Close()
+
+	for _, c := range cases {
+		spec := synth.DefaultSpec()
+		c.update(&spec)
+		sample, err := synth.Generate(spec)
+		if err != nil {
+			t.Errorf("[%v] unable to generate image: %v", c.name, err)
+			continue
+		}
+		img, err := gocv.ImageToMatRGB(sample.Image)
+		if err != nil {
+			t.Errorf("[%v] unable to convert image: %v", c.name, err)
+			continue
+		}
+
+		detection := rd.Detect(img, nil)
+		road := synth.PolygonMask(sample.Mask.Bounds(), detection.Road.ToPoints())
+		if iou := synth.IoU(road, sample.Mask); iou < c.minIoU {
+			t.Errorf("[%v] bad road detection, IoU: %.3f, wants at least %v, contour: %v", c.name, iou, c.minIoU,
+				detection.Road.ToPoints())
+		}
+
+		_ = detection.Close()
+		_ = img.Close()
+	}
+}
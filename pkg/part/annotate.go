@@ -0,0 +1,69 @@
+package part
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+)
+
+var (
+	colorContour = color.RGBA{R: 0, G: 255, B: 0, A: 255}
+	colorEllipse = color.RGBA{R: 0, G: 0, B: 255, A: 255}
+	colorHorizon = color.RGBA{R: 255, G: 0, B: 0, A: 255}
+	colorText    = color.RGBA{R: 255, G: 255, B: 0, A: 255}
+)
+
+// AnnotateRoad returns a color copy of img with road contour, fitted ellipse, horizon and confidence drawn on it.
+// Caller is responsible for closing returned Mat.
+func AnnotateRoad(img gocv.Mat, road *gocv.PointVector, ellipse *events.Ellipse, horizon int) gocv.Mat {
+	annotated := gocv.NewMat()
+	if img.Empty() {
+		return annotated
+	}
+	switch img.Channels() {
+	case 1:
+		gocv.CvtColor(img, &annotated, gocv.ColorGrayToBGR)
+	case 4:
+		gocv.CvtColor(img, &annotated, gocv.ColorBGRAToBGR)
+	default:
+		img.CopyTo(&annotated)
+	}
+
+	gocv.Line(&annotated, image.Point{X: 0, Y: horizon}, image.Point{X: annotated.Cols(), Y: horizon}, colorHorizon, 1)
+
+	if road != nil && road.Size() > 0 {
+		contours := gocv.NewPointsVector()
+		defer contours.Close()
+		contours.Append(*road)
+		gocv.DrawContours(&annotated, contours, 0, colorContour, 1)
+	}
+
+	if ellipse.GetConfidence() > 0. {
+		gocv.Ellipse(&annotated,
+			image.Point{X: int(ellipse.GetCenter().GetX()), Y: int(ellipse.GetCenter().GetY())},
+			image.Point{X: int(ellipse.GetWidth() / 2), Y: int(ellipse.GetHeight() / 2)},
+			float64(ellipse.GetAngle()), 0., 360., colorEllipse, 1)
+	}
+
+	gocv.PutText(&annotated, fmt.Sprintf("conf: %.2f", ellipse.GetConfidence()),
+		image.Point{X: 2, Y: annotated.Rows() - 4}, gocv.FontHersheyPlain, 0.8, colorText, 1)
+	return annotated
+}
+
+// encodeJPEG encodes img to jpeg and returns a copy of bytes owned by go runtime
+func encodeJPEG(img gocv.Mat) ([]byte, error) {
+	if img.Empty() {
+		return nil, fmt.Errorf("unable to encode empty image to jpeg")
+	}
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode image to jpeg: %w", err)
+	}
+	defer buf.Close()
+
+	jpeg := make([]byte, buf.Len())
+	copy(jpeg, buf.GetBytes())
+	return jpeg, nil
+}
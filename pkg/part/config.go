@@ -0,0 +1,75 @@
+package part
+
+import (
+	"fmt"
+)
+
+// DetectorConfig gathers tunable parameters of RoadDetector
+type DetectorConfig struct {
+	// Horizon is the number of rows from top of image to ignore
+	Horizon int `json:"horizon" yaml:"horizon"`
+	// Threshold applied on gray image after morphological operations, darker pixels are considered as road
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	// ThresholdMaxValue is the value given to road pixels in binary mask
+	ThresholdMaxValue float64 `json:"threshold_max_value" yaml:"threshold_max_value"`
+	// KernelSize is the size of square kernel used by morphological operations
+	KernelSize int `json:"kernel_size" yaml:"kernel_size"`
+	// MorphoIterations is the number of dilate/erode iterations
+	MorphoIterations int `json:"morpho_iterations" yaml:"morpho_iterations"`
+	// ApproxPolyEpsilonFactor is the max distance between road contour and its approximation, as a ratio of contour perimeter
+	ApproxPolyEpsilonFactor float64 `json:"approx_poly_epsilon_factor" yaml:"approx_poly_epsilon_factor"`
+	// TrustRegion is the image area where ellipse center should be to get full confidence
+	TrustRegion TrustRegion `json:"trust_region" yaml:"trust_region"`
+}
+
+// TrustRegion is a rectangle area, in pixels, of image
+type TrustRegion struct {
+	MinX int `json:"min_x" yaml:"min_x"`
+	MaxX int `json:"max_x" yaml:"max_x"`
+	MinY int `json:"min_y" yaml:"min_y"`
+	MaxY int `json:"max_y" yaml:"max_y"`
+}
+
+func DefaultDetectorConfig() DetectorConfig {
+	return DetectorConfig{
+		Horizon:                 20,
+		Threshold:               180,
+		ThresholdMaxValue:       255,
+		KernelSize:              4,
+		MorphoIterations:        3,
+		ApproxPolyEpsilonFactor: 0.01,
+		TrustRegion: TrustRegion{
+			MinX: 48,
+			MaxX: 115,
+			MinY: 69,
+			MaxY: 119,
+		},
+	}
+}
+
+// Validate checks parameters consistency
+func (c *DetectorConfig) Validate() error {
+	if c.Horizon < 0 {
+		return fmt.Errorf("invalid horizon %v, should be >= 0", c.Horizon)
+	}
+	if c.Threshold < 0 || c.Threshold > 255 {
+		return fmt.Errorf("invalid threshold %v, should be in [0, 255]", c.Threshold)
+	}
+	if c.ThresholdMaxValue <= 0 || c.ThresholdMaxValue > 255 {
+		return fmt.Errorf("invalid threshold max value %v, should be in ]0, 255]", c.ThresholdMaxValue)
+	}
+	if c.KernelSize < 1 {
+		return fmt.Errorf("invalid kernel size %v, should be >= 1", c.KernelSize)
+	}
+	if c.MorphoIterations < 0 {
+		return fmt.Errorf("invalid morpho iterations %v, should be >= 0", c.MorphoIterations)
+	}
+	if c.ApproxPolyEpsilonFactor <= 0 || c.ApproxPolyEpsilonFactor >= 1 {
+		return fmt.Errorf("invalid approx poly epsilon factor %v, should be in ]0, 1[", c.ApproxPolyEpsilonFactor)
+	}
+	tr := c.TrustRegion
+	if tr.MinX < 0 || tr.MinY < 0 || tr.MinX > tr.MaxX || tr.MinY > tr.MaxY {
+		return fmt.Errorf("invalid trust region %+v, min values should be >= 0 and <= max values", tr)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package part
+
+import "testing"
+
+func TestDetectorConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		update  func(c *DetectorConfig)
+		wantErr bool
+	}{
+		{"default", func(c *DetectorConfig) {}, false},
+		{"negative horizon", func(c *DetectorConfig) { c.Horizon = -1 }, true},
+		{"threshold too high", func(c *DetectorConfig) { c.Threshold = 256 }, true},
+		{"null threshold max value", func(c *DetectorConfig) { c.ThresholdMaxValue = 0 }, true},
+		{"null kernel", func(c *DetectorConfig) { c.KernelSize = 0 }, true},
+		{"no morpho iteration", func(c *DetectorConfig) { c.MorphoIterations = 0 }, false},
+		{"negative morpho iterations", func(c *DetectorConfig) { c.MorphoIterations = -1 }, true},
+		{"null epsilon factor", func(c *DetectorConfig) { c.ApproxPolyEpsilonFactor = 0 }, true},
+		{"epsilon factor too high", func(c *DetectorConfig) { c.ApproxPolyEpsilonFactor = 1 }, true},
+		{"inverted trust region", func(c *DetectorConfig) { c.TrustRegion.MinX, c.TrustRegion.MaxX = 100, 10 }, true},
+		{"negative trust region", func(c *DetectorConfig) { c.TrustRegion.MinY = -5 }, true},
+	}
+
+	for _, c := range cases {
+		config := DefaultDetectorConfig()
+		c.update(&config)
+		if err := config.Validate(); (err != nil) != c.wantErr {
+			t.Errorf("[%v] Validate(): %v, wants error: %v", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package part
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// MaxUploadSize limits size of images posted to detect handler
+	MaxUploadSize = 10 << 20
+
+	// UploadFormField is the multipart form field that contains image posted to detect handler
+	UploadFormField = "image"
+)
+
+// DetectPoint is a point of DetectResponse
+type DetectPoint struct {
+	X int32 `json:"x"`
+	Y int32 `json:"y"`
+}
+
+// DetectEllipse is the ellipse of DetectResponse
+type DetectEllipse struct {
+	Center DetectPoint `json:"center"`
+	Width  int32       `json:"width"`
+	Height int32       `json:"height"`
+	Angle  float32     `json:"angle"`
+}
+
+// DetectResponse is the json document returned by detect handler
+type DetectResponse struct {
+	Contour []DetectPoint `json:"contour"`
+	// Ellipse is null if no road detected
+	Ellipse    *DetectEllipse `json:"ellipse"`
+	Confidence float32        `json:"confidence"`
+}
+
+func newDetectResponse(msg *events.RoadMessage) *DetectResponse {
+	resp := DetectResponse{
+		Contour:    make([]DetectPoint, 0, len(msg.GetContour())),
+		Confidence: msg.GetEllipse().GetConfidence(),
+	}
+	for _, pt := range msg.GetContour() {
+		resp.Contour = append(resp.Contour, DetectPoint{X: pt.GetX(), Y: pt.GetY()})
+	}
+	if ellipse := msg.GetEllipse(); ellipse.GetConfidence() > 0. {
+		resp.Ellipse = &DetectEllipse{
+			Center: DetectPoint{X: ellipse.GetCenter().GetX(), Y: ellipse.GetCenter().GetY()},
+			Width:  ellipse.GetWidth(),
+			Height: ellipse.GetHeight(),
+			Angle:  ellipse.GetAngle(),
+		}
+	}
+	return &resp
+}
+
+// DetectHandler serves `POST` requests that contain a jpeg or png image, as raw body or as multipart form field
+// UploadFormField. It responds with detected road as DetectResponse json document, or with image annotated with
+// detection result if `annotate=true` query parameter is set. Uploaded images are not shown on debug streams.
+func (r *RoadPart) DetectHandler() http.Handler {
+	return http.HandlerFunc(r.serveDetect)
+}
+
+func (r *RoadPart) serveDetect(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	annotate := false
+	if value := req.URL.Query().Get("annotate"); value != "" {
+		var err error
+		annotate, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid annotate parameter '%v'", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	img, err := readUploadedImage(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	frame := events.FrameMessage{
+		Id: &events.FrameRef{
+			Name:      "http",
+			Id:        strconv.FormatInt(now.UnixMilli(), 10),
+			CreatedAt: timestamppb.New(now),
+		},
+		Frame: img,
+	}
+	result, err := r.detectFrame(&frame, annotate)
+	switch {
+	case errors.Is(err, ErrInvalidImage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		zap.S().Errorf("unable to detect road on uploaded image: %v", err)
+		http.Error(w, "unable to detect road", http.StatusInternalServerError)
+		return
+	}
+
+	if annotate {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(result.annotated)))
+		if _, err := w.Write(result.annotated); err != nil {
+			zap.S().Debugf("unable to write annotated image: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newDetectResponse(result.msg)); err != nil {
+		zap.S().Debugf("unable to write detect response: %v", err)
+	}
+}
+
+// readUploadedImage returns image content from request body or from multipart form field UploadFormField
+func readUploadedImage(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	req.Body = http.MaxBytesReader(w, req.Body, MaxUploadSize)
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		img, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read body: %w", err)
+		}
+		return img, nil
+	}
+
+	if err := req.ParseMultipartForm(MaxUploadSize); err != nil {
+		return nil, fmt.Errorf("invalid multipart form: %w", err)
+	}
+	defer func() {
+		if err := req.MultipartForm.RemoveAll(); err != nil {
+			zap.S().Warnf("unable to remove multipart temporary files: %v", err)
+		}
+	}()
+	file, _, err := req.FormFile(UploadFormField)
+	if err != nil {
+		return nil, fmt.Errorf("no image in form field '%v': %w", UploadFormField, err)
+	}
+	defer file.Close()
+	img, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image from form: %w", err)
+	}
+	return img, nil
+}
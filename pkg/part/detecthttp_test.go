@@ -0,0 +1,101 @@
+package part
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cyrilix/robocar-road/pkg/mjpeg"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoadPart_DetectHandler(t *testing.T) {
+	img, err := ioutil.ReadFile("testdata/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to load data test image: %v", err)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile(UploadFormField, "image.jpg")
+	if err != nil {
+		t.Fatalf("unable to build multipart form: %v", err)
+	}
+	_, _ = fw.Write(img)
+	_ = mw.Close()
+
+	imgStream, maskStream := mjpeg.NewStream(), mjpeg.NewStream()
+	rp := NewRoadPart(newRecordingTransport(), 20, "", "", WithDebugStreams(imgStream, maskStream))
+	defer rp.Stop()
+	handler := rp.DetectHandler()
+	images := map[string]<-chan struct{}{
+		"road": watchStream(t, imgStream),
+		"mask": watchStream(t, maskStream),
+	}
+
+	cases := []struct {
+		name                string
+		method              string
+		url                 string
+		contentType         string
+		body                []byte
+		expectedStatus      int
+		expectedContentType string
+	}{
+		{"raw jpeg", http.MethodPost, "/detect", "image/jpeg", img, http.StatusOK, "application/json"},
+		{"multipart", http.MethodPost, "/detect", mw.FormDataContentType(), form.Bytes(), http.StatusOK, "application/json"},
+		{"annotate", http.MethodPost, "/detect?annotate=true", "image/jpeg", img, http.StatusOK, "image/jpeg"},
+		{"bad method", http.MethodGet, "/detect", "", nil, http.StatusMethodNotAllowed, ""},
+		{"bad annotate", http.MethodPost, "/detect?annotate=maybe", "image/jpeg", img, http.StatusBadRequest, ""},
+		{"bad image", http.MethodPost, "/detect", "image/jpeg", []byte("not an image"), http.StatusBadRequest, ""},
+		{"empty multipart", http.MethodPost, "/detect", "multipart/form-data; boundary=x", []byte("--x--\r\n"), http.StatusBadRequest, ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, bytes.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedStatus {
+			t.Errorf("[%v] bad status: %v, wants %v (%v)", c.name, rec.Code, c.expectedStatus, rec.Body.String())
+			continue
+		}
+		if c.expectedContentType == "" {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != c.expectedContentType {
+			t.Errorf("[%v] bad content type: %v, wants %v", c.name, ct, c.expectedContentType)
+		}
+		if c.expectedContentType != "application/json" {
+			continue
+		}
+
+		var resp DetectResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Errorf("[%v] invalid json response: %v", c.name, err)
+			continue
+		}
+		if len(resp.Contour) != 5 || resp.Contour[0] != (DetectPoint{X: 0, Y: 45}) {
+			t.Errorf("[%v] bad contour: %v", c.name, resp.Contour)
+		}
+		if resp.Ellipse == nil || resp.Ellipse.Center != (DetectPoint{X: 71, Y: 87}) || resp.Confidence != 1. {
+			t.Errorf("[%v] bad ellipse: %+v, confidence %v", c.name, resp.Ellipse, resp.Confidence)
+		}
+	}
+
+	// Uploaded images, multipart or annotated, are not camera frames
+	time.Sleep(100 * time.Millisecond)
+	for name, c := range images {
+		select {
+		case <-c:
+			t.Errorf("[%v] stream updated by /detect request", name)
+		default:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package part
+
+import (
+	"go.uber.org/zap"
+	"gocv.io/x/gocv"
+	"time"
+)
+
+// DiagnosticSink receives a FrameDiagnostic for each processed frame. Write must not block, it returns false if
+// diagnostic is dropped.
+type DiagnosticSink interface {
+	Write(doc interface{}) bool
+}
+
+// WithDiagnosticSink writes a FrameDiagnostic to sink for each frame processed from camera topic
+func WithDiagnosticSink(sink DiagnosticSink) Option {
+	return func(r *RoadPart) {
+		r.diagnosticSink = sink
+	}
+}
+
+// FrameDiagnostic describes how a frame has been processed
+type FrameDiagnostic struct {
+	FrameId   string `json:"frame_id"`
+	FrameName string `json:"frame_name"`
+	// CreatedAt is the frame creation date by camera, if known
+	CreatedAt   *time.Time `json:"created_at"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	PublishedAt time.Time  `json:"published_at"`
+	// Config holds detector parameters used to process frame
+	Config DetectorConfig `json:"config"`
+	// CandidateContours is the number of contours found on road mask, road being the biggest one
+	CandidateContours int               `json:"candidate_contours"`
+	Contour           []DetectPoint     `json:"contour"`
+	Ellipse           *DetectEllipse    `json:"ellipse"`
+	Confidence        float32           `json:"confidence"`
+	ConfidenceFactors ConfidenceFactors `json:"confidence_factors"`
+	// StageTimings are durations of pipeline stages, in milliseconds
+	StageTimings map[Stage]float64 `json:"stage_timings_ms"`
+}
+
+func (r *RoadPart) writeDiagnostic(frame *frameToProcess, result *detectionResult, publishedAt time.Time) {
+	if r.diagnosticSink == nil {
+		return
+	}
+
+	road := newDetectResponse(result.msg)
+	diag := FrameDiagnostic{
+		FrameId:           frame.ref.GetId(),
+		FrameName:         frame.ref.GetName(),
+		ReceivedAt:        frame.receivedAt,
+		PublishedAt:       publishedAt,
+		Config:            result.config,
+		CandidateContours: result.candidates,
+		Contour:           road.Contour,
+		Ellipse:           road.Ellipse,
+		Confidence:        road.Confidence,
+		ConfidenceFactors: result.confidence,
+		StageTimings:      make(map[Stage]float64, len(result.timings)+1),
+	}
+	if frame.ref.GetCreatedAt() != nil {
+		createdAt := frame.ref.GetCreatedAt().AsTime()
+		diag.CreatedAt = &createdAt
+	}
+	diag.StageTimings[StageDecode] = milliseconds(frame.decodeDuration)
+	for stage, d := range result.timings {
+		diag.StageTimings[stage] = milliseconds(d)
+	}
+
+	if !r.diagnosticSink.Write(&diag) {
+		metricDiagnosticsDropped.Add(1)
+		zap.S().Debugf("diagnostic of frame %v dropped", diag.FrameId)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// stageTimer records durations of pipeline stages and forwards notifications to next observer
+type stageTimer struct {
+	next    StageObserver
+	timings map[Stage]time.Duration
+}
+
+func newStageTimer(next StageObserver) *stageTimer {
+	return &stageTimer{next: next, timings: make(map[Stage]time.Duration, len(Stages))}
+}
+
+func (s *stageTimer) OnStage(stage Stage, start time.Time, duration time.Duration, img *gocv.Mat) {
+	s.timings[stage] = duration
+	if s.next != nil {
+		s.next.OnStage(stage, start, duration, img)
+	}
+}
@@ -0,0 +1,82 @@
+package part
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeDiagnosticSink struct {
+	docs chan interface{}
+}
+
+func (f *fakeDiagnosticSink) Write(doc interface{}) bool {
+	select {
+	case f.docs <- doc:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRoadPart_WriteDiagnostic(t *testing.T) {
+	sink := fakeDiagnosticSink{docs: make(chan interface{}, 1)}
+	cameraTopic := "topic/camera"
+	rp := NewRoadPart(newRecordingTransport(), 20, cameraTopic, "topic/road", WithDiagnosticSink(&sink))
+	defer rp.Stop()
+	go func() {
+		if err := rp.Start(context.Background()); err != nil {
+			t.Errorf("unable to start roadPart: %v", err)
+		}
+	}()
+
+	payload := loadFrame(t, "image")
+	ref := frameRefFromPayload(payload)
+	rp.OnFrame(cameraTopic, payload)
+
+	var diag *FrameDiagnostic
+	select {
+	case doc := <-sink.docs:
+		diag = doc.(*FrameDiagnostic)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no diagnostic written")
+	}
+
+	if diag.FrameId != ref.GetId() || diag.FrameName != ref.GetName() {
+		t.Errorf("bad frame ref: %v/%v, wants %v/%v", diag.FrameId, diag.FrameName, ref.GetId(), ref.GetName())
+	}
+	if diag.CreatedAt == nil || !diag.CreatedAt.Equal(ref.GetCreatedAt().AsTime()) {
+		t.Errorf("bad creation date: %v", diag.CreatedAt)
+	}
+	if diag.ReceivedAt.IsZero() || diag.PublishedAt.Before(diag.ReceivedAt) {
+		t.Errorf("bad received/published dates: %v/%v", diag.ReceivedAt, diag.PublishedAt)
+	}
+	if diag.Config.Horizon != 20 {
+		t.Errorf("bad config horizon: %v", diag.Config.Horizon)
+	}
+	if diag.CandidateContours < 1 || len(diag.Contour) != 5 {
+		t.Errorf("bad contours: %v candidates, selected %v", diag.CandidateContours, diag.Contour)
+	}
+	if diag.Ellipse == nil || diag.Confidence != 1. || diag.ConfidenceFactors != (ConfidenceFactors{X: 1., Y: 1.}) {
+		t.Errorf("bad ellipse: %+v, confidence %v, factors %+v", diag.Ellipse, diag.Confidence, diag.ConfidenceFactors)
+	}
+	for _, stage := range Stages {
+		if _, ok := diag.StageTimings[stage]; !ok {
+			t.Errorf("no timing for stage %v: %v", stage, diag.StageTimings)
+		}
+	}
+}
+
+func TestStageTimer_OnStage(t *testing.T) {
+	next := stagesRecorder{}
+	timer := newStageTimer(&next)
+	timer.OnStage(StageGray, time.Now(), 2*time.Millisecond, nil)
+	timer.OnStage(StageThreshold, time.Now(), 3*time.Millisecond, nil)
+
+	if timer.timings[StageGray] != 2*time.Millisecond || timer.timings[StageThreshold] != 3*time.Millisecond {
+		t.Errorf("bad timings: %v", timer.timings)
+	}
+	if len(next.stages) != 2 || next.stages[0] != StageGray || next.stages[1] != StageThreshold {
+		t.Errorf("stages not forwarded: %v", next.stages)
+	}
+}
@@ -0,0 +1,98 @@
+package part
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"strings"
+)
+
+// ProcessingMode defines how camera frames are processed
+type ProcessingMode int
+
+const (
+	// ProcessingFull processes every frame
+	ProcessingFull ProcessingMode = iota
+	// ProcessingReduced processes only one frame over ProcessingPolicy.ReducedRate
+	ProcessingReduced
+	// ProcessingPaused ignores all frames
+	ProcessingPaused
+)
+
+func (m ProcessingMode) String() string {
+	switch m {
+	case ProcessingFull:
+		return "full"
+	case ProcessingReduced:
+		return "reduced"
+	case ProcessingPaused:
+		return "pause"
+	default:
+		return fmt.Sprintf("ProcessingMode(%d)", int(m))
+	}
+}
+
+func parseProcessingMode(value string) (ProcessingMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "full":
+		return ProcessingFull, nil
+	case "reduced":
+		return ProcessingReduced, nil
+	case "pause", "paused":
+		return ProcessingPaused, nil
+	default:
+		return ProcessingFull, fmt.Errorf("invalid processing mode '%v', should be one of full, reduced or pause", value)
+	}
+}
+
+// ProcessingPolicy maps drive modes to processing modes
+type ProcessingPolicy struct {
+	modes map[events.DriveMode]ProcessingMode
+	// ReducedRate is the number of frames between two processed frames in reduced mode
+	ReducedRate int
+}
+
+// DefaultProcessingPolicy processes all frames whatever the drive mode
+func DefaultProcessingPolicy() *ProcessingPolicy {
+	return &ProcessingPolicy{
+		modes:       make(map[events.DriveMode]ProcessingMode),
+		ReducedRate: 1,
+	}
+}
+
+// ParseProcessingPolicy builds a policy from a comma separated list of DRIVE_MODE=processing_mode
+// (ex: "INVALID=pause,USER=reduced,PILOT=full"). Drive modes not listed are fully processed.
+func ParseProcessingPolicy(value string, reducedRate int) (*ProcessingPolicy, error) {
+	if reducedRate < 1 {
+		return nil, fmt.Errorf("invalid reduced rate %v, should be >= 1", reducedRate)
+	}
+	policy := DefaultProcessingPolicy()
+	policy.ReducedRate = reducedRate
+
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		driveMode, processing, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid processing policy entry '%v', should be DRIVE_MODE=processing_mode", entry)
+		}
+		dm, ok := events.DriveMode_value[strings.ToUpper(strings.TrimSpace(driveMode))]
+		if !ok {
+			return nil, fmt.Errorf("invalid drive mode '%v' in processing policy", driveMode)
+		}
+		pm, err := parseProcessingMode(processing)
+		if err != nil {
+			return nil, err
+		}
+		policy.modes[events.DriveMode(dm)] = pm
+	}
+	return policy, nil
+}
+
+// ProcessingMode returns processing mode to apply for driveMode
+func (p *ProcessingPolicy) ProcessingMode(driveMode events.DriveMode) ProcessingMode {
+	if pm, ok := p.modes[driveMode]; ok {
+		return pm
+	}
+	return ProcessingFull
+}
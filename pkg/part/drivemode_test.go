@@ -0,0 +1,83 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestParseProcessingPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    string
+		expected map[events.DriveMode]ProcessingMode
+		wantErr  bool
+	}{
+		{"empty", "", map[events.DriveMode]ProcessingMode{
+			events.DriveMode_INVALID: ProcessingFull, events.DriveMode_USER: ProcessingFull, events.DriveMode_PILOT: ProcessingFull},
+			false,
+		},
+		{"all modes", "INVALID=pause, user=reduced,PILOT=full", map[events.DriveMode]ProcessingMode{
+			events.DriveMode_INVALID: ProcessingPaused, events.DriveMode_USER: ProcessingReduced, events.DriveMode_PILOT: ProcessingFull},
+			false,
+		},
+		{"partial", "USER=pause", map[events.DriveMode]ProcessingMode{
+			events.DriveMode_INVALID: ProcessingFull, events.DriveMode_USER: ProcessingPaused, events.DriveMode_PILOT: ProcessingFull},
+			false,
+		},
+		{"unknown drive mode", "PARKED=pause", nil, true},
+		{"unknown processing mode", "USER=slow", nil, true},
+		{"missing separator", "USER", nil, true},
+	}
+
+	for _, c := range cases {
+		policy, err := ParseProcessingPolicy(c.value, 3)
+		if (err != nil) != c.wantErr {
+			t.Errorf("[%v] ParseProcessingPolicy(%v) error: %v, wants error: %v", c.name, c.value, err, c.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for dm, pm := range c.expected {
+			if policy.ProcessingMode(dm) != pm {
+				t.Errorf("[%v] bad processing mode for %v: %v, wants %v", c.name, dm, policy.ProcessingMode(dm), pm)
+			}
+		}
+	}
+
+	if _, err := ParseProcessingPolicy("", 0); err == nil {
+		t.Errorf("ParseProcessingPolicy() with reduced rate 0 should fail")
+	}
+}
+
+func TestRoadPart_ShouldProcessFrame(t *testing.T) {
+	policy, err := ParseProcessingPolicy("INVALID=pause,USER=reduced,PILOT=full", 3)
+	if err != nil {
+		t.Fatalf("unable to build policy: %v", err)
+	}
+	r := RoadPart{processingPolicy: policy}
+
+	cases := []struct {
+		driveMode events.DriveMode
+		expected  []bool
+	}{
+		{events.DriveMode_INVALID, []bool{false, false, false}},
+		{events.DriveMode_USER, []bool{true, false, false, true, false, false, true}},
+		{events.DriveMode_PILOT, []bool{true, true, true}},
+		{events.DriveMode_USER, []bool{true, false, false, true}},
+	}
+
+	for _, c := range cases {
+		payload, err := proto.Marshal(&events.DriveModeMessage{DriveMode: c.driveMode})
+		if err != nil {
+			t.Fatalf("unable to marshal drive mode: %v", err)
+		}
+		r.OnDriveMode("topic/drive-mode", payload)
+		for idx, expected := range c.expected {
+			if process := r.shouldProcessFrame(); process != expected {
+				t.Errorf("[%v] bad processing decision for frame %v: %v, wants %v", c.driveMode, idx, process, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FramePolicy defines which frames are rejected because they are outdated
+type FramePolicy struct {
+	// MaxAge rejects frames received more than MaxAge after their creation by camera, 0 disables this check.
+	// Camera and road service clocks must be synchronized.
+	MaxAge time.Duration
+	// RejectOutOfOrder rejects frames created before the last accepted one. Frames are ordered by FrameRef.CreatedAt,
+	// or by FrameRef.Id when it is a number (as camera timestamp in milliseconds).
+	RejectOutOfOrder bool
+}
+
+// WithFramePolicy rejects stale and out-of-order frames according to policy. By default, all frames are accepted.
+func WithFramePolicy(policy FramePolicy) Option {
+	return func(r *RoadPart) {
+		r.frameFilter = newFrameFilter(policy)
+	}
+}
+
+type frameVerdict int
+
+const (
+	frameAccepted frameVerdict = iota
+	frameStale
+	frameOutOfOrder
+)
+
+// frameFilter applies FramePolicy and keeps track of the last accepted frame
+type frameFilter struct {
+	policy FramePolicy
+
+	mu            sync.Mutex
+	lastCreatedAt time.Time
+	lastId        int64
+	hasLastId     bool
+}
+
+func newFrameFilter(policy FramePolicy) *frameFilter {
+	return &frameFilter{policy: policy}
+}
+
+// Accept returns true if frame has to be processed, and records it as last accepted frame
+func (f *frameFilter) Accept(ref *events.FrameRef, receivedAt time.Time) bool {
+	switch f.check(ref, receivedAt) {
+	case frameStale:
+		metricFramesStale.Add(1)
+		zap.S().Debugf("reject stale frame %v created at %v", ref.GetId(), ref.GetCreatedAt().AsTime())
+		return false
+	case frameOutOfOrder:
+		metricFramesOutOfOrder.Add(1)
+		zap.S().Debugf("reject out-of-order frame %v created at %v", ref.GetId(), ref.GetCreatedAt().AsTime())
+		return false
+	default:
+		return true
+	}
+}
+
+func (f *frameFilter) check(ref *events.FrameRef, receivedAt time.Time) frameVerdict {
+	var createdAt time.Time
+	if ref.GetCreatedAt() != nil {
+		createdAt = ref.GetCreatedAt().AsTime()
+	}
+	if f.policy.MaxAge > 0 && !createdAt.IsZero() && receivedAt.Sub(createdAt) > f.policy.MaxAge {
+		return frameStale
+	}
+	if !f.policy.RejectOutOfOrder {
+		return frameAccepted
+	}
+
+	id, err := strconv.ParseInt(ref.GetId(), 10, 64)
+	hasId := err == nil
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case !createdAt.IsZero() && !f.lastCreatedAt.IsZero():
+		if createdAt.Before(f.lastCreatedAt) {
+			return frameOutOfOrder
+		}
+	case hasId && f.hasLastId:
+		if id < f.lastId {
+			return frameOutOfOrder
+		}
+	}
+
+	if !createdAt.IsZero() {
+		f.lastCreatedAt = createdAt
+	}
+	if hasId {
+		f.lastId = id
+		f.hasLastId = true
+	}
+	return frameAccepted
+}
@@ -0,0 +1,47 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+func TestFrameFilter_Check(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 30, 15, 0, time.UTC)
+	frameAt := func(id string, age time.Duration) *events.FrameRef {
+		return &events.FrameRef{Id: id, CreatedAt: timestamppb.New(now.Add(-age))}
+	}
+
+	cases := []struct {
+		name     string
+		policy   FramePolicy
+		frames   []*events.FrameRef
+		expected []frameVerdict
+	}{
+		{"accept all by default", FramePolicy{},
+			[]*events.FrameRef{frameAt("1", time.Hour), frameAt("2", 2*time.Hour)},
+			[]frameVerdict{frameAccepted, frameAccepted}},
+		{"max age", FramePolicy{MaxAge: 200 * time.Millisecond},
+			[]*events.FrameRef{frameAt("1", 50*time.Millisecond), frameAt("2", 300*time.Millisecond), frameAt("3", -time.Second), {Id: "4"}},
+			[]frameVerdict{frameAccepted, frameStale, frameAccepted, frameAccepted}},
+		{"out of order by timestamp", FramePolicy{RejectOutOfOrder: true},
+			[]*events.FrameRef{frameAt("a", 100*time.Millisecond), frameAt("b", 50*time.Millisecond), frameAt("c", 80*time.Millisecond), frameAt("d", 50*time.Millisecond)},
+			[]frameVerdict{frameAccepted, frameAccepted, frameOutOfOrder, frameAccepted}},
+		{"out of order by id", FramePolicy{RejectOutOfOrder: true},
+			[]*events.FrameRef{{Id: "1654086615500"}, {Id: "1654086615550"}, {Id: "1654086615520"}, {Id: "camera"}, {Id: "1654086615600"}},
+			[]frameVerdict{frameAccepted, frameAccepted, frameOutOfOrder, frameAccepted, frameAccepted}},
+		{"stale before out of order", FramePolicy{MaxAge: time.Second, RejectOutOfOrder: true},
+			[]*events.FrameRef{frameAt("1", 10*time.Millisecond), frameAt("2", time.Minute), frameAt("3", 5*time.Millisecond)},
+			[]frameVerdict{frameAccepted, frameStale, frameAccepted}},
+	}
+
+	for _, c := range cases {
+		f := newFrameFilter(c.policy)
+		for idx, ref := range c.frames {
+			if verdict := f.check(ref, now); verdict != c.expected[idx] {
+				t.Errorf("[%v] bad verdict for frame %v: %v, wants %v", c.name, idx, verdict, c.expected[idx])
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package part
+
+import (
+	"bytes"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"gocv.io/x/gocv"
+	"google.golang.org/protobuf/proto"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Run with: go test ./pkg/part -run '^$' -fuzz FuzzRoadPart_ReadFrame -fuzztime 1m
+
+// fuzzSeedImages returns testdata jpeg images and small png images of various channels and depths
+func fuzzSeedImages(f *testing.F) [][]byte {
+	var seeds [][]byte
+	paths, err := filepath.Glob("testdata/*.jpg")
+	if err != nil {
+		f.Fatalf("unable to list testdata images: %v", err)
+	}
+	for _, path := range paths {
+		img, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatalf("unable to load %v: %v", path, err)
+		}
+		seeds = append(seeds, img)
+	}
+
+	bounds := image.Rect(0, 0, 16, 12)
+	gray := image.NewGray(bounds)
+	gray16 := image.NewGray16(bounds)
+	rgba := image.NewNRGBA(bounds)
+	for y := 6; y < 12; y++ {
+		for x := 4; x < 12; x++ {
+			gray.SetGray(x, y, color.Gray{Y: 255})
+			gray16.SetGray16(x, y, color.Gray16{Y: 0xffff})
+			rgba.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+		}
+	}
+	for _, img := range []image.Image{gray, gray16, rgba} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			f.Fatalf("unable to encode %T seed: %v", img, err)
+		}
+		seeds = append(seeds, buf.Bytes())
+	}
+	return append(seeds, []byte("not an image"), nil)
+}
+
+func FuzzRoadPart_ReadFrame(f *testing.F) {
+	for _, img := range fuzzSeedImages(f) {
+		payload, err := proto.Marshal(&events.FrameMessage{Id: &events.FrameRef{Name: "camera", Id: "1"}, Frame: img})
+		if err != nil {
+			f.Fatalf("unable to marshal seed: %v", err)
+		}
+		f.Add(payload)
+	}
+	f.Add([]byte{0xff, 0x00, 0x12})
+
+	roadTopic := "topic/road"
+	bus := newRecordingTransport()
+	rp := NewRoadPart(bus, 20, "topic/camera", roadTopic)
+	defer rp.Stop()
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		bus.mu.Lock()
+		bus.messages = nil
+		bus.mu.Unlock()
+
+		frame := rp.readFrame(payload, time.Now())
+		if frame == nil {
+			return
+		}
+		rows, cols := frame.Rows(), frame.Cols()
+		rp.processFrame(frame)
+		rp.closeFrame(frame)
+
+		published := bus.last(roadTopic)
+		if published == nil {
+			t.Fatalf("no road message published for a decoded frame")
+		}
+		var msg events.RoadMessage
+		if err := proto.Unmarshal(published, &msg); err != nil {
+			t.Fatalf("invalid road message: %v", err)
+		}
+		for _, pt := range msg.GetContour() {
+			if pt.GetX() < 0 || int(pt.GetX()) >= cols || pt.GetY() < 0 || int(pt.GetY()) >= rows {
+				t.Errorf("contour point %v outside of %vx%v image", pt, cols, rows)
+			}
+		}
+	})
+}
+
+// fuzzImage builds an image of at most 31x31 pixels with 1, 3 or 4 channels, filled with data
+func fuzzImage(data []byte, width, height, channels uint8) gocv.Mat {
+	cols, rows := int(width%32), int(height%32)
+	ch := []int{1, 3, 4}[int(channels)%3]
+	if cols == 0 || rows == 0 {
+		return gocv.NewMat()
+	}
+
+	pixels := make([]byte, rows*cols*ch)
+	if len(data) > 0 {
+		for i := range pixels {
+			pixels[i] = data[i%len(data)]
+		}
+	}
+	mt := map[int]gocv.MatType{1: gocv.MatTypeCV8UC1, 3: gocv.MatTypeCV8UC3, 4: gocv.MatTypeCV8UC4}[ch]
+	img, err := gocv.NewMatFromBytes(rows, cols, mt, pixels)
+	if err != nil {
+		return gocv.NewMat()
+	}
+	return img
+}
+
+func FuzzRoadDetector_DetectRoadContour(f *testing.F) {
+	f.Add([]byte{0, 255}, uint8(16), uint8(12), uint8(0), int16(4))
+	f.Add([]byte{255, 255, 255, 0, 0, 0}, uint8(31), uint8(31), uint8(1), int16(0))
+	f.Add([]byte{10, 200, 30, 255}, uint8(8), uint8(20), uint8(2), int16(50))
+	f.Add([]byte{}, uint8(0), uint8(10), uint8(1), int16(-3))
+
+	f.Fuzz(func(t *testing.T, data []byte, width, height, channels uint8, horizon int16) {
+		img := fuzzImage(data, width, height, channels)
+		defer img.Close()
+
+		config := DefaultDetectorConfig()
+		config.Horizon = int(horizon)
+		if config.Horizon < 0 {
+			config.Horizon = -config.Horizon
+		}
+		rd := NewRoadDetectorWithConfig(config)
+		defer rd.Close()
+
+		road := rd.DetectRoadContour(&img, int(horizon))
+		for i := 0; i < road.Size(); i++ {
+			if pt := road.At(i); !pt.In(image.Rect(0, 0, img.Cols(), img.Rows())) {
+				t.Errorf("contour point %v outside of %vx%v image", pt, img.Cols(), img.Rows())
+			}
+		}
+		road.Close()
+
+		detection := rd.Detect(img, nil)
+		if detection.Road.Size() > 0 && detection.Mask.Empty() {
+			t.Errorf("road detected without mask")
+		}
+		_ = detection.Close()
+	})
+}
@@ -0,0 +1,220 @@
+package part
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Regenerate golden files after detector changes with: go test ./pkg/part -run Golden -update
+var updateGoldens = flag.Bool("update", false, "write detector output of testdata images to golden files")
+
+const goldenDir = "testdata/golden"
+
+// goldenTolerance is the max difference allowed between golden and actual detection
+type goldenTolerance struct {
+	// Point is the max distance in pixels on each axis for contour points and ellipse center
+	Point int32 `json:"point"`
+	// Size is the max difference in pixels of ellipse width and height
+	Size int32 `json:"size"`
+	// Angle is the max difference in degrees of ellipse angle
+	Angle float32 `json:"angle"`
+	// Confidence is the max difference of ellipse confidence
+	Confidence float32 `json:"confidence"`
+}
+
+var defaultGoldenTolerance = goldenTolerance{Point: 2, Size: 2, Angle: 1., Confidence: 0.01}
+
+// goldenDetection is the expected detection of a testdata image, stored as json in goldenDir
+type goldenDetection struct {
+	Tolerance goldenTolerance `json:"tolerance"`
+	DetectResponse
+}
+
+func TestRoadDetector_Golden(t *testing.T) {
+	images, err := filepath.Glob("testdata/*.jpg")
+	if err != nil || len(images) == 0 {
+		t.Fatalf("no testdata image: %v", err)
+	}
+
+	rd := NewRoadDetector()
+	defer rd.Close()
+
+	for _, path := range images {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		goldenPath := filepath.Join(goldenDir, name+".json")
+
+		img := gocv.IMRead(path, gocv.IMReadColor)
+		detection := rd.Detect(img, nil)
+		actual := detectResponse(detection)
+
+		golden, err := readGolden(goldenPath)
+		switch {
+		case *updateGoldens:
+			if err == nil {
+				actual.Tolerance = golden.Tolerance
+			}
+			if err := writeGolden(goldenPath, actual); err != nil {
+				t.Errorf("[%v] unable to update golden file: %v", name, err)
+			}
+		case err != nil:
+			t.Errorf("[%v] unable to read golden file, run tests with -update to create it: %v", name, err)
+		default:
+			diffPath := filepath.Join(goldenDir, name+".diff.png")
+			diffs := golden.compare(actual.DetectResponse)
+			if len(diffs) == 0 {
+				_ = os.Remove(diffPath)
+				break
+			}
+			if err := writeGoldenDiff(diffPath, img, golden.DetectResponse, actual.DetectResponse); err != nil {
+				t.Logf("[%v] unable to write diff image: %v", name, err)
+			}
+			t.Errorf("[%v] detection differs from %v (see %v, expected in green, actual in red):\n  %v",
+				name, goldenPath, diffPath, strings.Join(diffs, "\n  "))
+		}
+
+		_ = detection.Close()
+		_ = img.Close()
+	}
+}
+
+func detectResponse(detection *Detection) *goldenDetection {
+	return &goldenDetection{
+		Tolerance: defaultGoldenTolerance,
+		DetectResponse: *newDetectResponse(&events.RoadMessage{
+			Contour: detection.Contour(),
+			Ellipse: detection.Ellipse,
+		}),
+	}
+}
+
+func readGolden(path string) (*goldenDetection, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var golden goldenDetection
+	if err := json.Unmarshal(content, &golden); err != nil {
+		return nil, fmt.Errorf("invalid golden file %v: %w", path, err)
+	}
+	return &golden, nil
+}
+
+func writeGolden(path string, golden *goldenDetection) error {
+	content, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// compare returns differences between golden and actual detection that exceed tolerance
+func (g *goldenDetection) compare(actual DetectResponse) []string {
+	var diffs []string
+	tol := g.Tolerance
+
+	if len(actual.Contour) != len(g.Contour) {
+		diffs = append(diffs, fmt.Sprintf("contour: %v point(s) %v, wants %v point(s) %v", len(actual.Contour), actual.Contour, len(g.Contour), g.Contour))
+	} else {
+		for i, pt := range g.Contour {
+			if !closePoints(pt, actual.Contour[i], tol.Point) {
+				diffs = append(diffs, fmt.Sprintf("contour point %v: %v, wants %v", i, actual.Contour[i], pt))
+			}
+		}
+	}
+
+	switch {
+	case g.Ellipse == nil && actual.Ellipse == nil:
+	case g.Ellipse == nil || actual.Ellipse == nil:
+		diffs = append(diffs, fmt.Sprintf("ellipse: %+v, wants %+v", actual.Ellipse, g.Ellipse))
+	default:
+		e, a := g.Ellipse, actual.Ellipse
+		if !closePoints(e.Center, a.Center, tol.Point) {
+			diffs = append(diffs, fmt.Sprintf("ellipse center: %v, wants %v", a.Center, e.Center))
+		}
+		if abs32(e.Width-a.Width) > tol.Size || abs32(e.Height-a.Height) > tol.Size {
+			diffs = append(diffs, fmt.Sprintf("ellipse size: %vx%v, wants %vx%v", a.Width, a.Height, e.Width, e.Height))
+		}
+		if angleDistance(e.Angle, a.Angle) > tol.Angle {
+			diffs = append(diffs, fmt.Sprintf("ellipse angle: %v, wants %v", a.Angle, e.Angle))
+		}
+	}
+
+	if math.Abs(float64(g.Confidence-actual.Confidence)) > float64(tol.Confidence) {
+		diffs = append(diffs, fmt.Sprintf("confidence: %v, wants %v", actual.Confidence, g.Confidence))
+	}
+	return diffs
+}
+
+func closePoints(p1, p2 DetectPoint, tolerance int32) bool {
+	return abs32(p1.X-p2.X) <= tolerance && abs32(p1.Y-p2.Y) <= tolerance
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// angleDistance returns the difference in degrees between two ellipse angles, an ellipse being unchanged by a 180°
+// rotation
+func angleDistance(a1, a2 float32) float32 {
+	d := float32(math.Mod(math.Abs(float64(a1-a2)), 180.))
+	if d > 90. {
+		return 180. - d
+	}
+	return d
+}
+
+var (
+	colorGoldenExpected = color.RGBA{R: 0, G: 255, B: 0, A: 255}
+	colorGoldenActual   = color.RGBA{R: 255, G: 0, B: 0, A: 255}
+)
+
+// writeGoldenDiff renders expected and actual detections on img
+func writeGoldenDiff(path string, img gocv.Mat, expected, actual DetectResponse) error {
+	diff := img.Clone()
+	defer diff.Close()
+
+	drawDetection(&diff, expected, colorGoldenExpected)
+	drawDetection(&diff, actual, colorGoldenActual)
+	gocv.PutText(&diff, "expected", image.Point{X: 2, Y: 10}, gocv.FontHersheyPlain, 0.8, colorGoldenExpected, 1)
+	gocv.PutText(&diff, "actual", image.Point{X: 2, Y: 20}, gocv.FontHersheyPlain, 0.8, colorGoldenActual, 1)
+
+	if !gocv.IMWrite(path, diff) {
+		return fmt.Errorf("unable to write image %v", path)
+	}
+	return nil
+}
+
+func drawDetection(img *gocv.Mat, detection DetectResponse, c color.RGBA) {
+	if len(detection.Contour) > 0 {
+		pts := make([]image.Point, 0, len(detection.Contour))
+		for _, pt := range detection.Contour {
+			pts = append(pts, image.Point{X: int(pt.X), Y: int(pt.Y)})
+		}
+		contours := gocv.NewPointsVectorFromPoints([][]image.Point{pts})
+		defer contours.Close()
+		gocv.DrawContours(img, contours, 0, c, 1)
+	}
+	if e := detection.Ellipse; e != nil {
+		gocv.Ellipse(img,
+			image.Point{X: int(e.Center.X), Y: int(e.Center.Y)},
+			image.Point{X: int(e.Width / 2), Y: int(e.Height / 2)},
+			float64(e.Angle), 0., 360., c, 1)
+	}
+}
@@ -0,0 +1,100 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/stats"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxClockSkew is the max duration a frame can be created in the future before reporting clock skew
+	DefaultMaxClockSkew = 50 * time.Millisecond
+	// DefaultMaxLatency is the camera to publish latency over which frame timestamp is considered implausibly old
+	DefaultMaxLatency = 10 * time.Second
+	// DefaultLatencyWindow is the number of last frames used to summarize latency metric
+	DefaultLatencyWindow = 200
+
+	// clockSkewWarningInterval limits clock skew warnings to one by interval
+	clockSkewWarningInterval = 10 * time.Second
+)
+
+// WithClockSkewThresholds overrides thresholds used to report clock skew between camera and road service: frames
+// created more than maxClockSkew in the future or more than maxLatency in the past.
+func WithClockSkewThresholds(maxClockSkew, maxLatency time.Duration) Option {
+	return func(r *RoadPart) {
+		r.maxClockSkew = maxClockSkew
+		r.maxLatency = maxLatency
+	}
+}
+
+// observeLatency computes latency between frame creation by camera and publishedAt. It returns false if frame has
+// no timestamp or if latency is implausible because of clock skew, in which case latency isn't recorded.
+func (r *RoadPart) observeLatency(ref *events.FrameRef, publishedAt time.Time) (time.Duration, bool) {
+	if ref.GetCreatedAt() == nil {
+		return 0, false
+	}
+	createdAt := ref.GetCreatedAt().AsTime()
+	latency := publishedAt.Sub(createdAt)
+
+	switch {
+	case latency < -r.maxClockSkew:
+		r.warnClockSkew(publishedAt, "frame %v created %v in the future (%v), camera clock is ahead", ref.GetId(), -latency, createdAt)
+		return latency, false
+	case latency > r.maxLatency:
+		r.warnClockSkew(publishedAt, "frame %v created %v ago (%v), camera clock is late or frame is implausibly old", ref.GetId(), latency, createdAt)
+		return latency, false
+	}
+
+	metricLatency.Add(latency)
+	zap.S().Debugf("frame %v published %v after its creation", ref.GetId(), latency)
+	return latency, true
+}
+
+func (r *RoadPart) warnClockSkew(now time.Time, template string, args ...interface{}) {
+	metricClockSkewFrames.Add(1)
+
+	r.muClockSkew.Lock()
+	defer r.muClockSkew.Unlock()
+	r.clockSkewFrames++
+	if now.Sub(r.lastClockSkewWarning) < clockSkewWarningInterval {
+		return
+	}
+	zap.S().Warnf(template, args...)
+	if r.clockSkewFrames > 1 {
+		zap.S().Warnf("%v frames with clock skew since last warning", r.clockSkewFrames)
+	}
+	r.lastClockSkewWarning = now
+	r.clockSkewFrames = 0
+}
+
+// latencyWindow keeps last latencies to summarize them
+type latencyWindow struct {
+	mu     sync.Mutex
+	values []time.Duration
+	next   int
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{values: make([]time.Duration, 0, size)}
+}
+
+// Add records latency, replacing the oldest one when window is full
+func (w *latencyWindow) Add(latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.values) < cap(w.values) {
+		w.values = append(w.values, latency)
+		return
+	}
+	w.values[w.next] = latency
+	w.next = (w.next + 1) % len(w.values)
+}
+
+// Summary describes latencies of the window
+func (w *latencyWindow) Summary() stats.Summary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return stats.Summarize(w.values)
+}
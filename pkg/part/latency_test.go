@@ -0,0 +1,56 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+func TestRoadPart_ObserveLatency(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 30, 15, 0, time.UTC)
+	r := RoadPart{maxClockSkew: DefaultMaxClockSkew, maxLatency: DefaultMaxLatency}
+
+	cases := []struct {
+		name              string
+		createdAt         *timestamppb.Timestamp
+		expectedLatency   time.Duration
+		expectedPlausible bool
+		expectedSkew      int64
+	}{
+		{"no timestamp", nil, 0, false, 0},
+		{"nominal", timestamppb.New(now.Add(-35 * time.Millisecond)), 35 * time.Millisecond, true, 0},
+		{"small skew", timestamppb.New(now.Add(10 * time.Millisecond)), -10 * time.Millisecond, true, 0},
+		{"future", timestamppb.New(now.Add(2 * time.Second)), -2 * time.Second, false, 1},
+		{"too old", timestamppb.New(now.Add(-time.Minute)), time.Minute, false, 1},
+	}
+
+	for _, c := range cases {
+		skewBefore := metricClockSkewFrames.Value()
+		countBefore := metricLatency.Summary().Count
+
+		latency, plausible := r.observeLatency(&events.FrameRef{Id: c.name, CreatedAt: c.createdAt}, now)
+		if latency != c.expectedLatency || plausible != c.expectedPlausible {
+			t.Errorf("[%v] bad latency: %v (plausible: %v), wants %v (plausible: %v)", c.name, latency, plausible, c.expectedLatency, c.expectedPlausible)
+		}
+		if skew := metricClockSkewFrames.Value() - skewBefore; skew != c.expectedSkew {
+			t.Errorf("[%v] bad clock skew frames: %v, wants %v", c.name, skew, c.expectedSkew)
+		}
+		recorded := metricLatency.Summary().Count - countBefore
+		if plausible != (recorded == 1) && countBefore < DefaultLatencyWindow {
+			t.Errorf("[%v] bad recorded latency count: %v", c.name, recorded)
+		}
+	}
+}
+
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow(3)
+	for _, l := range []time.Duration{100, 1, 2, 3} {
+		w.Add(l * time.Millisecond)
+	}
+
+	summary := w.Summary()
+	if summary.Count != 3 || summary.Min != time.Millisecond || summary.Max != 3*time.Millisecond {
+		t.Errorf("bad summary, oldest latency should be replaced: %+v", summary)
+	}
+}
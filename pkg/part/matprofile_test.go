@@ -0,0 +1,148 @@
+//go:build matprofile
+// +build matprofile
+
+package part
+
+import (
+	"bytes"
+	"context"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"gocv.io/x/gocv"
+	"google.golang.org/protobuf/proto"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags matprofile ./pkg/part
+
+// assertNoLeakedMats runs f and fails if some gocv Mat created by f are still open after it returns
+func assertNoLeakedMats(t *testing.T, name string, f func()) {
+	t.Helper()
+	before := gocv.MatProfile.Count()
+	f()
+	if leaked := gocv.MatProfile.Count() - before; leaked != 0 {
+		var b bytes.Buffer
+		_ = gocv.MatProfile.WriteTo(&b, 1)
+		t.Errorf("[%v] %v Mat(s) leaked, open Mats:\n%v", name, leaked, b.String())
+	}
+}
+
+// syntheticImage returns a white color image with a dark filled rectangle for each of roads
+func syntheticImage(roads ...image.Rectangle) gocv.Mat {
+	img := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 255, 255, 0), 128, 160, gocv.MatTypeCV8UC3)
+	for _, r := range roads {
+		gocv.Rectangle(&img, r, color.RGBA{R: 20, G: 20, B: 20, A: 255}, FILLED)
+	}
+	return img
+}
+
+func TestMatLeaks_RoadDetector(t *testing.T) {
+	img1 := image1()
+	defer img1.Close()
+	multi := syntheticImage(image.Rect(5, 60, 50, 120), image.Rect(70, 40, 150, 125))
+	defer multi.Close()
+	empty := syntheticImage()
+	defer empty.Close()
+
+	cases := []struct {
+		name               string
+		img                gocv.Mat
+		expectedCandidates int
+	}{
+		{"single road", *img1, 1},
+		{"multi contours", multi, 2},
+		{"empty image", empty, 0},
+	}
+
+	for _, c := range cases {
+		assertNoLeakedMats(t, c.name, func() {
+			rd := NewRoadDetector()
+			defer rd.Close()
+
+			detection := rd.Detect(c.img, nil)
+			if detection.Candidates < c.expectedCandidates {
+				t.Errorf("[%v] bad candidate contours: %v, wants at least %v", c.name, detection.Candidates, c.expectedCandidates)
+			}
+			annotated := AnnotateRoad(c.img, detection.Road, detection.Ellipse, rd.Horizon())
+			if _, err := encodeJPEG(annotated); err != nil {
+				t.Errorf("[%v] unable to encode annotated image: %v", c.name, err)
+			}
+			_ = annotated.Close()
+			_ = detection.Close()
+
+			gray := toGray(c.img)
+			road := rd.DetectRoadContour(gray, rd.Horizon())
+			road.Close()
+			mask := rd.DetectRoadMask(gray, rd.Horizon())
+			_ = mask.Close()
+			_ = gray.Close()
+		})
+	}
+}
+
+func TestMatLeaks_RoadPart(t *testing.T) {
+	img, err := ioutil.ReadFile("testdata/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to load data test image: %v", err)
+	}
+	empty := syntheticImage()
+	emptyJPEG, err := encodeJPEG(empty)
+	_ = empty.Close()
+	if err != nil {
+		t.Fatalf("unable to encode empty image: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		frame       []byte
+		publication bool
+	}{
+		{"road frame", img, true},
+		{"empty frame", emptyJPEG, true},
+		{"undecodable frame", []byte("not an image"), false},
+	}
+
+	for _, c := range cases {
+		msg := events.FrameMessage{Id: &events.FrameRef{Name: "camera", Id: "1"}, Frame: c.frame}
+		payload, err := proto.Marshal(&msg)
+		if err != nil {
+			t.Fatalf("unable to marshal frame: %v", err)
+		}
+
+		assertNoLeakedMats(t, c.name, func() {
+			cameraTopic := "topic/camera"
+			published := make(chan struct{}, 1)
+			bus := newRecordingTransport()
+			bus.onPublish = func(_ string) {
+				select {
+				case published <- struct{}{}:
+				default:
+				}
+			}
+
+			rp := NewRoadPart(bus, 20, cameraTopic, "topic/road", WithSequentialProcessing())
+			go func() {
+				if err := rp.Start(context.Background()); err != nil {
+					t.Errorf("[%v] unable to start roadPart: %v", c.name, err)
+				}
+			}()
+
+			rp.OnFrame(cameraTopic, payload)
+			if c.publication {
+				select {
+				case <-published:
+				case <-time.After(5 * time.Second):
+					t.Errorf("[%v] frame not processed", c.name)
+				}
+			}
+
+			_, _ = rp.DetectRoad(&msg)
+			_, _, _ = rp.DetectAnnotatedRoad(&msg)
+
+			rp.Stop()
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package part
+
+import "expvar"
+
+// Metrics exposed through expvar (/debug/vars when http server is enabled)
+var (
+	metricPublishedMessages  = expvar.NewInt("road_published_messages")
+	metricPublishErrors      = expvar.NewInt("road_publish_errors")
+	metricFramesSkipped      = expvar.NewInt("road_frames_skipped")
+	metricFramesStale        = expvar.NewInt("road_frames_stale")
+	metricFramesOutOfOrder   = expvar.NewInt("road_frames_out_of_order")
+	metricDriveMode          = expvar.NewString("road_drive_mode")
+	metricProcessingMode     = expvar.NewString("road_processing_mode")
+	metricConfigApplied      = expvar.NewInt("road_config_applied")
+	metricConfigRejected     = expvar.NewInt("road_config_rejected")
+	metricClockSkewFrames    = expvar.NewInt("road_clock_skew_frames")
+	metricDiagnosticsDropped = expvar.NewInt("road_diagnostics_dropped")
+
+	// metricLatency summarizes camera to publish latency (nanoseconds) of last frames
+	metricLatency = newLatencyWindow(DefaultLatencyWindow)
+)
+
+func init() {
+	expvar.Publish("road_latency", expvar.Func(func() interface{} { return metricLatency.Summary() }))
+}
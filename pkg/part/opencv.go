@@ -1,19 +1,19 @@
 package part
 
 import (
+	"fmt"
 	"github.com/cyrilix/robocar-protobuf/go/events"
 	"go.uber.org/zap"
 	"gocv.io/x/gocv"
 	"image"
 	"image/color"
+	"time"
 )
 
 const FILLED = -1
 
 type RoadDetector struct {
-	kernelSize                               int
-	morphoIterations                         int
-	approxPolyEpsilonFactor                  float64
+	config                                   DetectorConfig
 	previousBoundingBox                      *image.Rectangle
 	previousRoad                             *[]image.Point
 	thresholdLowerBound, thresholdUpperBound gocv.Mat
@@ -34,85 +34,190 @@ func (rd *RoadDetector) Close() error {
 }
 
 func NewRoadDetector() *RoadDetector {
+	return NewRoadDetectorWithConfig(DefaultDetectorConfig())
+}
+
+// NewRoadDetectorWithConfig builds a RoadDetector with custom parameters, config should be valid
+func NewRoadDetectorWithConfig(config DetectorConfig) *RoadDetector {
 
 	return &RoadDetector{
-		kernelSize:              4,
-		morphoIterations:        3,
-		approxPolyEpsilonFactor: 0.01,
-		thresholdLowerBound:     gocv.NewMatFromScalar(gocv.NewScalar(120.0, 120.0, 120.0, 120.0), gocv.MatTypeCV8U),
-		thresholdUpperBound:     gocv.NewMatFromScalar(gocv.NewScalar(250.0, 250.0, 250.0, 250.0), gocv.MatTypeCV8U),
+		config:              config,
+		thresholdLowerBound: gocv.NewMatFromScalar(gocv.NewScalar(120.0, 120.0, 120.0, 120.0), gocv.MatTypeCV8U),
+		thresholdUpperBound: gocv.NewMatFromScalar(gocv.NewScalar(250.0, 250.0, 250.0, 250.0), gocv.MatTypeCV8U),
 	}
 }
 
-func (rd *RoadDetector) DetectRoadContour(imgGray *gocv.Mat, horizonRow int) *gocv.PointVector {
+// Config returns current detector parameters
+func (rd *RoadDetector) Config() DetectorConfig {
+	return rd.config
+}
 
-	kernel := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(1, 1, 1, 1), rd.kernelSize, rd.kernelSize, gocv.MatTypeCV8U)
+// Configure replaces detector parameters after validation.
+// It is not safe to call Configure while a detection is running.
+func (rd *RoadDetector) Configure(config DetectorConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	rd.config = config
+	return nil
+}
 
-	img := imgGray.Clone()
+// Horizon returns configured number of rows to ignore on top of images
+func (rd *RoadDetector) Horizon() int {
+	return rd.config.Horizon
+}
+
+func (rd *RoadDetector) DetectRoadContour(imgGray *gocv.Mat, horizonRow int) *gocv.PointVector {
+	img := rd.DetectRoadMask(imgGray, horizonRow)
 	defer func() {
 		if err := img.Close(); err != nil {
 			zap.S().Warnf("unable to close mat resource: %v", err)
 		}
 	}()
 
-	for i := rd.morphoIterations; i > 0; i-- {
+	road, _ := rd.detectRoadContour(&img)
+	return road
+}
+
+// DetectRoadMask computes binary image where road pixels are white and others black.
+// Caller is responsible for closing returned Mat.
+func (rd *RoadDetector) DetectRoadMask(imgGray *gocv.Mat, horizonRow int) gocv.Mat {
+	return rd.detectRoadMask(imgGray, horizonRow, nil)
+}
+
+func (rd *RoadDetector) detectRoadMask(imgGray *gocv.Mat, horizonRow int, obs StageObserver) gocv.Mat {
+	start := time.Now()
+
+	img, err := toGrayscale(*imgGray)
+	if err != nil {
+		zap.S().Warnf("unable to compute road mask: %v", err)
+		return img
+	}
+
+	kernel := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(1, 1, 1, 1), rd.config.KernelSize, rd.config.KernelSize, gocv.MatTypeCV8U)
+	defer func() {
+		if err := kernel.Close(); err != nil {
+			zap.S().Warnf("unable to close kernel resource: %v", err)
+		}
+	}()
+
+	for i := rd.config.MorphoIterations; i > 0; i-- {
 		gocv.Dilate(img, &img, kernel)
 	}
-	for i := rd.morphoIterations; i > 0; i-- {
+	for i := rd.config.MorphoIterations; i > 0; i-- {
 		gocv.Erode(img, &img, kernel)
 	}
 	gocv.Dilate(img, &img, kernel)
+	observe(obs, StageMorphology, start, &img)
 
-	gocv.Threshold(img, &img, 180, 255, gocv.ThresholdBinaryInv)
+	start = time.Now()
+	gocv.Threshold(img, &img, float32(rd.config.Threshold), float32(rd.config.ThresholdMaxValue), gocv.ThresholdBinaryInv)
+	observe(obs, StageThreshold, start, &img)
 
+	start = time.Now()
 	// Draw black rectangle above horizon
-	horizon := gocv.NewMatWithSize(1, 4, gocv.MatTypeCV32S)
-	horizon.SetIntAt(0, 0, 0)                     // X1
-	horizon.SetIntAt(0, 1, int32(horizonRow))     // Y1
-	horizon.SetIntAt(0, 2, int32(imgGray.Cols())) // X2
-	horizon.SetIntAt(0, 3, int32(horizonRow))     // Y2
-	rectangle := image.Rect(0, 0, int(horizon.GetIntAt(0, 2)), int(horizon.GetIntAt(0, 3)))
-	gocv.Rectangle(&img, rectangle, color.RGBA{0, 0, 0, 0}, FILLED)
+	if horizonRow > img.Rows() {
+		horizonRow = img.Rows()
+	}
+	if horizonRow > 0 {
+		rectangle := image.Rect(0, 0, img.Cols(), horizonRow)
+		gocv.Rectangle(&img, rectangle, color.RGBA{0, 0, 0, 0}, FILLED)
+	}
+	observe(obs, StageHorizon, start, &img)
 
-	return rd.detectRoadContour(&img)
+	return img
 }
 
-func (rd *RoadDetector) detectRoadContour(imgInversed *gocv.Mat) *gocv.PointVector {
+// detectRoadContour returns the approximated polygon of the biggest contour and the number of candidate contours
+func (rd *RoadDetector) detectRoadContour(imgInversed *gocv.Mat) (*gocv.PointVector, int) {
 
-	var (
-		epsilon float64
-		cntr    gocv.PointVector
-	)
+	if imgInversed.Empty() {
+		emptyContours := gocv.NewPointVector()
+		return &emptyContours, 0
+	}
 
 	ptsVec := gocv.FindContours(*imgInversed, gocv.RetrievalExternal, gocv.ChainApproxSimple)
 	defer ptsVec.Close()
 
-	if ptsVec.Size() == 0 {
+	candidates := ptsVec.Size()
+	if candidates == 0 {
 		emptyContours := gocv.NewPointVector()
-		return &emptyContours
-	} else if ptsVec.Size() == 1 {
-		epsilon = rd.approxPolyEpsilonFactor * gocv.ArcLength(ptsVec.At(0), true)
-		cntr = ptsVec.At(0)
-	} else {
-		// Search biggest contour
-		peris := make([]float64, ptsVec.Size())
-		maxArcIdx := 0
-		maxArcValue := 0.
-		//for i, c := range cntrs {
-		for i := 0; i< ptsVec.Size(); i++ {
-			c := ptsVec.At(i)
-			peri := gocv.ArcLength(c, true)
-			peris[i] = peri
-			if peri > maxArcValue {
-				maxArcValue = peri
-				maxArcIdx = i
-			}
-			cntr = ptsVec.At(maxArcIdx)
+		return &emptyContours, 0
+	}
+
+	// Search biggest contour
+	maxArcIdx := 0
+	maxArcValue := 0.
+	for i := 0; i < candidates; i++ {
+		peri := gocv.ArcLength(ptsVec.At(i), true)
+		if peri > maxArcValue {
+			maxArcValue = peri
+			maxArcIdx = i
+		}
+	}
+
+	// Contours of ptsVec are released on return, approximation is a new vector owned by caller
+	approx := gocv.ApproxPolyDP(ptsVec.At(maxArcIdx), rd.config.ApproxPolyEpsilonFactor*maxArcValue, true)
+	return &approx, candidates
+}
+
+// toGrayscale converts img, a gray (1 channel), 3 or 4 channels color image of any depth, to a new 8 bits gray image. Caller is responsible for closing returned Mat, even on error.
+func toGrayscale(img gocv.Mat) (gocv.Mat, error) {
+	gray := gocv.NewMat()
+	if img.Empty() {
+		return gray, fmt.Errorf("empty image")
+	}
+	channels := img.Channels()
+	if channels != 1 && channels != 3 && channels != 4 {
+		return gray, fmt.Errorf("unsupported image with %v channels", channels)
+	}
+
+	// Color conversions don't support all depths, scale values to 8 bits first
+	src := img
+	if depth := matDepth(img); depth != gocv.MatTypeCV8U {
+		img.ConvertToWithParams(&gray, gocv.MatTypeCV8U, depthScale(depth), 0.)
+		if channels == 1 {
+			return gray, nil
 		}
-		epsilon = rd.approxPolyEpsilonFactor * peris[maxArcIdx]
+		src = gray
+	}
+
+	// IMDecode returns BGR(A) images, but camera frames have always been converted with RGB weights: thresholds of
+	// detector configurations are tuned on these gray levels, so RGB weights are kept for both 3 and 4 channels.
+	switch channels {
+	case 1:
+		src.CopyTo(&gray)
+	case 3:
+		gocv.CvtColor(src, &gray, gocv.ColorRGBToGray)
+	case 4:
+		gocv.CvtColor(src, &gray, gocv.ColorRGBAToGray)
+	}
+	return gray, nil
+}
+
+// matDepthMask selects depth bits of a Mat type, higher bits encode the number of channels
+const matDepthMask = 7
+
+// matDepth returns the depth of img elements (gocv.MatTypeCV8U, gocv.MatTypeCV16U, ...) whatever its number of channels
+func matDepth(img gocv.Mat) gocv.MatType {
+	return img.Type() & matDepthMask
+}
+
+// depthScale returns the factor that maps values of depth to 8 bits range
+func depthScale(depth gocv.MatType) float32 {
+	switch depth {
+	case gocv.MatTypeCV16U:
+		return 1. / 256.
+	case gocv.MatTypeCV16S:
+		return 1. / 128.
+	case gocv.MatTypeCV32S:
+		return 1. / (1 << 23)
+	case gocv.MatTypeCV32F, gocv.MatTypeCV64F:
+		// Floating point images are expected in [0, 1]
+		return 255.
+	default:
+		return 1.
 	}
-	approx := gocv.ApproxPolyDP(cntr, epsilon, true)
-	return &approx
 }
 
 var EllipseNotFound = events.Ellipse{Confidence: 0.}
@@ -140,10 +245,10 @@ func (rd *RoadDetector) ComputeEllipsis(road *gocv.PointVector) *events.Ellipse
 }
 
 func (rd *RoadDetector) computeTrustFromCenter(ellipsisCenter *image.Point) float32 {
-	safeMinX := 48
-	safeMaxX := 115
-	safeMinY := 69
-	safeMaxY := 119
+	safeMinX := rd.config.TrustRegion.MinX
+	safeMaxX := rd.config.TrustRegion.MaxX
+	safeMinY := rd.config.TrustRegion.MinY
+	safeMaxY := rd.config.TrustRegion.MaxY
 
 	if safeMinX <= ellipsisCenter.X && ellipsisCenter.X <= safeMaxX && safeMinY <= ellipsisCenter.Y && ellipsisCenter.Y <= safeMaxY {
 		return 1.0
@@ -160,6 +265,29 @@ func (rd *RoadDetector) computeTrustFromCenter(ellipsisCenter *image.Point) floa
 	return rd.computeTrustOnAxis(safeMaxY, safeMinY, ellipsisCenter.Y) * rd.computeTrustOnAxis(safeMaxX, safeMinX, ellipsisCenter.X)
 }
 
+// ConfidenceFactors details ellipse confidence: the trust of ellipse center position on each axis, relative to trust
+// region. Confidence is the product of both factors.
+type ConfidenceFactors struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+func (rd *RoadDetector) confidenceFactors(ellipse *events.Ellipse) ConfidenceFactors {
+	if ellipse.GetConfidence() == 0. {
+		return ConfidenceFactors{}
+	}
+	x := int(ellipse.GetCenter().GetX())
+	y := int(ellipse.GetCenter().GetY())
+	factors := ConfidenceFactors{X: 1., Y: 1.}
+	if x < rd.config.TrustRegion.MinX || x > rd.config.TrustRegion.MaxX {
+		factors.X = rd.computeTrustOnAxis(rd.config.TrustRegion.MaxX, rd.config.TrustRegion.MinX, x)
+	}
+	if y < rd.config.TrustRegion.MinY || y > rd.config.TrustRegion.MaxY {
+		factors.Y = rd.computeTrustOnAxis(rd.config.TrustRegion.MaxY, rd.config.TrustRegion.MinY, y)
+	}
+	return factors
+}
+
 func (rd *RoadDetector) computeTrustOnAxis(safeMax, safeMin, value int) float32 {
 	trust := 1.
 	if value > safeMax {
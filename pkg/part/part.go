@@ -1,144 +1,625 @@
 package part
 
 import (
-	"github.com/cyrilix/robocar-base/service"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/cyrilix/robocar-protobuf/go/events"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"github.com/cyrilix/robocar-road/pkg/mjpeg"
+	"github.com/cyrilix/robocar-road/pkg/transport"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"gocv.io/x/gocv"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
-	"log"
+	"image"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout is the max duration to wait for in-flight frames on Stop
+const DefaultStopTimeout = 2 * time.Second
+
+var (
+	// ErrStopped is returned when a detection is requested after Stop
+	ErrStopped = errors.New("road part stopped")
+	// ErrInvalidImage is returned when frame content can't be decoded as image
+	ErrInvalidImage = errors.New("invalid image")
 )
 
 type RoadPart struct {
-	client                 mqtt.Client
+	transport              transport.Transport
 	frameChan              chan frameToProcess
-	readyForNext           chan interface{}
-	cancel                 chan interface{}
 	roadDetector           *RoadDetector
-	horizon                int
 	cameraTopic, roadTopic string
+	roadJSONTopic          string
+
+	waypointsTopic     string
+	waypointsExtractor *lane.Extractor
+	poseTopic          string
+	groundHomography   lane.Homography
+
+	// muConfig is held for read during frame processing and for write to apply a new configuration
+	muConfig                    sync.RWMutex
+	configTopic, configAckTopic string
+
+	imgStream, maskStream *mjpeg.Stream
+	stageStreams          map[Stage]*mjpeg.Stream
+
+	driveModeTopic   string
+	processingPolicy *ProcessingPolicy
+	muDriveMode      sync.Mutex
+	driveMode        events.DriveMode
+	frameCount       int
+
+	// ctx is cancelled when Stop is called
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	frameFilter    *frameFilter
+	diagnosticSink DiagnosticSink
+	tracer         trace.Tracer
+
+	maxClockSkew, maxLatency time.Duration
+	muClockSkew              sync.Mutex
+	lastClockSkewWarning     time.Time
+	clockSkewFrames          int
+
+	muStopped   sync.Mutex
+	stopped     bool
+	inFlight    sync.WaitGroup
+	stopTimeout time.Duration
+	sequential  bool
+	stopOnce    sync.Once
+	releaseOnce sync.Once
+}
+
+// Option configures optional features of RoadPart
+type Option func(r *RoadPart)
+
+// WithDebugStreams publishes processed frames annotated with detection result on imgStream,
+// and binary road mask on maskStream. A nil stream is ignored.
+func WithDebugStreams(imgStream, maskStream *mjpeg.Stream) Option {
+	return func(r *RoadPart) {
+		r.imgStream = imgStream
+		r.maskStream = maskStream
+	}
 }
 
-func NewRoadPart(client mqtt.Client, horizon int, cameraTopic, roadTopic string) *RoadPart {
-	return &RoadPart{
-		client:       client,
+// WithStageStreams publishes intermediate images of pipeline stages on streams by stage (see StageObserver).
+// Stages that don't produce image are ignored.
+func WithStageStreams(streams map[Stage]*mjpeg.Stream) Option {
+	return func(r *RoadPart) {
+		r.stageStreams = streams
+	}
+}
+
+// WithDriveModeProcessing subscribes to driveModeTopic and applies policy to process, reduce or pause
+// frames processing according to the current drive mode
+func WithDriveModeProcessing(driveModeTopic string, policy *ProcessingPolicy) Option {
+	return func(r *RoadPart) {
+		r.driveModeTopic = driveModeTopic
+		r.processingPolicy = policy
+	}
+}
+
+// WithConfigTopic subscribes to configTopic to update detector parameters at runtime from json documents.
+// Result of each update is published on ackTopic if not empty.
+func WithConfigTopic(configTopic, ackTopic string) Option {
+	return func(r *RoadPart) {
+		r.configTopic = configTopic
+		r.configAckTopic = ackTopic
+	}
+}
+
+// WithDetectorConfig replaces default detector configuration, horizon included. Configuration should be valid
+// (see DetectorConfig.Validate).
+func WithDetectorConfig(config DetectorConfig) Option {
+	return func(r *RoadPart) {
+		r.roadDetector.config = config
+	}
+}
+
+// WithSequentialProcessing processes frames one after the other, in reception order, instead of concurrently.
+// Useful to get deterministic output, when replaying records for example.
+func WithSequentialProcessing() Option {
+	return func(r *RoadPart) {
+		r.sequential = true
+	}
+}
+
+// WithJSONTopic publishes road messages as canonical protobuf json on topic, in addition to road topic
+func WithJSONTopic(topic string) Option {
+	return func(r *RoadPart) {
+		r.roadJSONTopic = topic
+	}
+}
+
+// WithStopTimeout overrides max duration to wait for in-flight frames on Stop
+func WithStopTimeout(timeout time.Duration) Option {
+	return func(r *RoadPart) {
+		r.stopTimeout = timeout
+	}
+}
+
+func NewRoadPart(t transport.Transport, horizon int, cameraTopic, roadTopic string, opts ...Option) *RoadPart {
+	ctx, cancel := context.WithCancel(context.Background())
+	config := DefaultDetectorConfig()
+	config.Horizon = horizon
+	r := &RoadPart{
+		transport:    t,
 		frameChan:    make(chan frameToProcess),
-		cancel:       make(chan interface{}),
-		roadDetector: NewRoadDetector(),
-		horizon:      horizon,
+		roadDetector: NewRoadDetectorWithConfig(config),
 		cameraTopic:  cameraTopic,
 		roadTopic:    roadTopic,
+		ctx:          ctx,
+		cancel:       cancel,
+		stopTimeout:  DefaultStopTimeout,
+		frameFilter:  newFrameFilter(FramePolicy{}),
+		maxClockSkew: DefaultMaxClockSkew,
+		maxLatency:   DefaultMaxLatency,
+		tracer:       defaultTracer(),
+
+		processingPolicy: DefaultProcessingPolicy(),
+		driveMode:        events.DriveMode_INVALID,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	metricDriveMode.Set(r.driveMode.String())
+	metricProcessingMode.Set(r.processingPolicy.ProcessingMode(r.driveMode).String())
+	return r
 }
 
-func (r *RoadPart) Start() error {
+// Start subscribes to camera topic and processes frames until ctx is cancelled or Stop is called.
+func (r *RoadPart) Start(ctx context.Context) error {
 	log := zap.S()
-	registerCallBacks(r)
-
-	var frame = frameToProcess{}
-	defer func() {
-		if err := frame.Close(); err != nil {
-			log.Errorf("unable to close msg: %v", err)
-		}
-	}()
+	if err := r.subscribe(); err != nil {
+		return err
+	}
 
 	for {
 		select {
 		case f := <-r.frameChan:
 			log.Debug("new msg")
-			oldFrame := frame
-			frame = f
-			if err := oldFrame.Close(); err != nil {
-				log.Errorf("unable to close msg: %v", err)
+			if !r.trackInFlight() {
+				r.closeFrame(&f)
+				continue
 			}
 			log.Debug("process msg")
-			go r.processFrame(&frame)
-		case <-r.cancel:
+			process := func() {
+				defer r.inFlight.Done()
+				defer r.closeFrame(&f)
+				r.processFrame(&f)
+			}
+			if r.sequential {
+				process()
+			} else {
+				go process()
+			}
+		case <-ctx.Done():
+			log.Infof("context done, stop processing frames: %v", ctx.Err())
+			return nil
+		case <-r.ctx.Done():
 			log.Infof("Stop service")
 			return nil
 		}
 	}
 }
 
-var registerCallBacks = func(r *RoadPart) {
-	err := service.RegisterCallback(r.client, r.cameraTopic, r.OnFrame)
-	if err != nil {
-		log.Panicf("unable to register callback to topic %v:%v", r.cameraTopic, err)
+// handlers returns message handlers by topic to subscribe
+func (r *RoadPart) handlers() map[string]transport.Handler {
+	handlers := make(map[string]transport.Handler)
+	if r.cameraTopic != "" {
+		// Without camera topic, frames are only provided by direct calls to OnFrame
+		handlers[r.cameraTopic] = r.OnFrame
+	}
+	if r.driveModeTopic != "" {
+		handlers[r.driveModeTopic] = r.OnDriveMode
+	}
+	if r.configTopic != "" {
+		handlers[r.configTopic] = r.OnConfig
+	}
+	return handlers
+}
+
+func (r *RoadPart) subscribe() error {
+	for topic, handler := range r.handlers() {
+		zap.S().Infof("Register callback on topic %v", topic)
+		if err := r.transport.Subscribe(topic, handler); err != nil {
+			return fmt.Errorf("unable to register callback to topic %v: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+func (r *RoadPart) unsubscribe() {
+	topics := make([]string, 0, 3)
+	for topic := range r.handlers() {
+		topics = append(topics, topic)
+	}
+	if err := r.transport.Unsubscribe(topics...); err != nil {
+		zap.S().Errorf("unable to unsubscribe: %v", err)
+	}
+}
+
+// trackInFlight registers a new frame to process, returns false if part is stopped
+func (r *RoadPart) trackInFlight() bool {
+	r.muStopped.Lock()
+	defer r.muStopped.Unlock()
+	if r.stopped {
+		return false
 	}
+	r.inFlight.Add(1)
+	return true
 }
 
+// Stop unsubscribes from camera topic, waits for in-flight frames and releases resources.
+//
+// It is safe to call Stop several times. If in-flight frames are not processed before stop timeout,
+// resources are released in background as soon as the last frame completes.
 func (r *RoadPart) Stop() {
-	defer func() {
+	r.stopOnce.Do(func() {
+		zap.S().Infof("Stop road service")
+		r.cancel()
+		r.muStopped.Lock()
+		r.stopped = true
+		r.muStopped.Unlock()
+
+		r.unsubscribe()
+
+		drained := make(chan struct{})
+		go func() {
+			r.inFlight.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+			r.release()
+		case <-time.After(r.stopTimeout):
+			zap.S().Warnf("in-flight frames not processed after %v, release resources in background", r.stopTimeout)
+			go func() {
+				<-drained
+				r.release()
+			}()
+		}
+	})
+}
+
+func (r *RoadPart) release() {
+	r.releaseOnce.Do(func() {
 		if err := r.roadDetector.Close(); err != nil {
 			zap.S().Errorf("unable to close roadDetector: %v", err)
 		}
-	}()
-	close(r.readyForNext)
-	close(r.cancel)
-	service.StopService("road", r.client, r.roadTopic)
+	})
+}
+
+// OnFrame decodes a events.FrameMessage payload and queues it for processing
+func (r *RoadPart) OnFrame(_ string, payload []byte) {
+	receivedAt := time.Now()
+	if r.ctx.Err() != nil {
+		zap.S().Debugf("service stopped, ignore frame")
+		return
+	}
+	if !r.shouldProcessFrame() {
+		metricFramesSkipped.Add(1)
+		return
+	}
+
+	frame := r.readFrame(payload, receivedAt)
+	if frame == nil {
+		return
+	}
+	select {
+	case r.frameChan <- *frame:
+	case <-r.ctx.Done():
+		r.closeFrame(frame)
+	}
 }
 
-func (r *RoadPart) OnFrame(_ mqtt.Client, msg mqtt.Message) {
+// readFrame unmarshals a events.FrameMessage payload and decodes its image. It returns nil if payload is invalid or
+// if frame is rejected by frame filter.
+func (r *RoadPart) readFrame(payload []byte, receivedAt time.Time) *frameToProcess {
 	var frameMsg events.FrameMessage
-	err := proto.Unmarshal(msg.Payload(), &frameMsg)
+	err := proto.Unmarshal(payload, &frameMsg)
 	if err != nil {
 		zap.S().Errorf("unable to unmarshal %T message: %v", frameMsg, err)
-		return
+		return nil
+	}
+	ctx, span := r.startFrameSpan(context.Background(), "road.frame", frameMsg.GetId())
+	if !r.frameFilter.Accept(frameMsg.GetId(), receivedAt) {
+		span.AddEvent("frame rejected")
+		span.End()
+		return nil
 	}
 
-	img, err := gocv.IMDecode(frameMsg.GetFrame(), gocv.IMReadUnchanged)
+	start := time.Now()
+	img, err := decodeImage(frameMsg.GetFrame())
 	if err != nil {
 		zap.S().Errorf("unable to decode image: %v", err)
+		span.RecordError(err)
+		span.End()
+		return nil
+	}
+	decodeDuration := time.Since(start)
+	observe(spanObserver{ctx: ctx, tracer: r.tracer}, StageDecode, start, nil)
+	return &frameToProcess{
+		ctx:            ctx,
+		ref:            frameMsg.GetId(),
+		receivedAt:     receivedAt,
+		decodeDuration: decodeDuration,
+		Mat:            img,
+	}
+}
+
+// decodeImage decodes a jpeg or png image, ErrInvalidImage is returned if data is not a valid image.
+// Caller is responsible for closing returned Mat if no error is returned.
+func decodeImage(data []byte) (gocv.Mat, error) {
+	img, err := gocv.IMDecode(data, gocv.IMReadUnchanged)
+	if err != nil {
+		return img, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if img.Empty() {
+		if err := img.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+		return img, fmt.Errorf("%w: unsupported image format", ErrInvalidImage)
+	}
+	return img, nil
+}
+
+// OnDriveMode updates current drive mode and so the frames processing mode
+func (r *RoadPart) OnDriveMode(_ string, payload []byte) {
+	var driveModeMsg events.DriveModeMessage
+	err := proto.Unmarshal(payload, &driveModeMsg)
+	if err != nil {
+		zap.S().Errorf("unable to unmarshal %T message: %v", driveModeMsg, err)
 		return
 	}
-	frame := frameToProcess{
-		ref: frameMsg.GetId(),
-		Mat: img,
+	r.setDriveMode(driveModeMsg.GetDriveMode())
+}
+
+func (r *RoadPart) setDriveMode(driveMode events.DriveMode) {
+	r.muDriveMode.Lock()
+	defer r.muDriveMode.Unlock()
+	if driveMode == r.driveMode {
+		return
+	}
+	previous := r.driveMode
+	r.driveMode = driveMode
+	r.frameCount = 0
+
+	processing := r.processingPolicy.ProcessingMode(driveMode)
+	metricDriveMode.Set(driveMode.String())
+	metricProcessingMode.Set(processing.String())
+	if processing == ProcessingReduced {
+		zap.S().Infof("drive mode changed from %v to %v, frame processing: %v (1 frame over %v)", previous, driveMode, processing, r.processingPolicy.ReducedRate)
+	} else {
+		zap.S().Infof("drive mode changed from %v to %v, frame processing: %v", previous, driveMode, processing)
+	}
+}
+
+// shouldProcessFrame applies processing policy for the current drive mode
+func (r *RoadPart) shouldProcessFrame() bool {
+	r.muDriveMode.Lock()
+	defer r.muDriveMode.Unlock()
+
+	switch r.processingPolicy.ProcessingMode(r.driveMode) {
+	case ProcessingPaused:
+		return false
+	case ProcessingReduced:
+		process := r.frameCount%r.processingPolicy.ReducedRate == 0
+		r.frameCount++
+		return process
+	default:
+		return true
 	}
-	r.frameChan <- frame
+}
+
+func (r *RoadPart) closeFrame(frame *frameToProcess) {
+	if err := frame.Close(); err != nil {
+		zap.S().Errorf("unable to close msg: %v", err)
+	}
+	trace.SpanFromContext(frame.ctx).End()
 }
 
 type frameToProcess struct {
-	ref *events.FrameRef
+	// ctx holds the frame span
+	ctx            context.Context
+	ref            *events.FrameRef
+	receivedAt     time.Time
+	decodeDuration time.Duration
 	gocv.Mat
 }
 
 func (r *RoadPart) processFrame(frame *frameToProcess) {
-	img := frame.Mat
-	imgGray := gocv.NewMatWithSize(img.Rows(), img.Cols(), gocv.MatTypeCV8UC1)
+	result, _ := r.detectRoad(frame.ctx, frame.Mat, frame.ref, false, true)
+
+	_, span := r.tracer.Start(frame.ctx, "publish")
+	r.publishRoad(result.msg)
+	r.publishWaypoints(frame.ref, result)
+	r.publishPose(frame.ref, result)
+	span.End()
+
+	publishedAt := time.Now()
+	r.observeLatency(frame.ref, publishedAt)
+	r.writeDiagnostic(frame, result, publishedAt)
+}
+
+// DetectRoad runs road detection on frame with the detector shared with bus processing, and returns result without
+// publishing it. Drive mode processing policy is not applied and debug streams are not updated, they only show
+// camera frames.
+func (r *RoadPart) DetectRoad(frame *events.FrameMessage) (*events.RoadMessage, error) {
+	result, err := r.detectFrame(frame, false)
+	if err != nil {
+		return nil, err
+	}
+	return result.msg, nil
+}
+
+// DetectAnnotatedRoad is like DetectRoad, but also returns frame annotated with detection result, as jpeg image
+func (r *RoadPart) DetectAnnotatedRoad(frame *events.FrameMessage) (*events.RoadMessage, []byte, error) {
+	result, err := r.detectFrame(frame, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.msg, result.annotated, nil
+}
+
+func (r *RoadPart) detectFrame(frame *events.FrameMessage, annotate bool) (*detectionResult, error) {
+	if !r.trackInFlight() {
+		return nil, ErrStopped
+	}
+	defer r.inFlight.Done()
+
+	ctx, span := r.startFrameSpan(context.Background(), "road.detect", frame.GetId())
+	defer span.End()
+
+	start := time.Now()
+	img, err := decodeImage(frame.GetFrame())
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	defer func() {
-		if err := imgGray.Close(); err != nil {
+		if err := img.Close(); err != nil {
 			zap.S().Warnf("unable to close Mat resource: %v", err)
 		}
 	}()
-	gocv.CvtColor(img, &imgGray, gocv.ColorRGBToGray)
+	observe(spanObserver{ctx: ctx, tracer: r.tracer}, StageDecode, start, nil)
+	return r.detectRoad(ctx, img, frame.GetId(), annotate, false)
+}
 
-	road := r.roadDetector.DetectRoadContour(&imgGray, r.horizon)
-	defer road.Close()
+// detectionResult is the road message computed on a frame, with processing details
+type detectionResult struct {
+	msg *events.RoadMessage
+	// annotated is the jpeg image annotated with detection, only if requested
+	annotated  []byte
+	config     DetectorConfig
+	candidates int
+	confidence ConfidenceFactors
+	timings    map[Stage]time.Duration
+	// waypoints of road centerline and pose of car, only computed if published
+	waypoints []lane.Waypoint
+	imageSize image.Point
+	pose      lane.Pose
+}
 
-	ellipse := r.roadDetector.ComputeEllipsis(road)
+// detectRoad runs road detection on img. If annotate is set, img annotated with detection result is also returned as
+// jpeg image. Debug and stage streams are updated only if debugStreams is set, for camera frames. A span is recorded
+// for each stage as child of the span in ctx.
+func (r *RoadPart) detectRoad(ctx context.Context, img gocv.Mat, ref *events.FrameRef, annotate, debugStreams bool) (*detectionResult, error) {
+	// Keep detector configuration unchanged during processing
+	r.muConfig.RLock()
+	defer r.muConfig.RUnlock()
 
-	cntr := make([]*events.Point, 0, road.Size())
-	for i := 0; i < road.Size(); i++ {
-		pt := road.At(i)
-		cntr = append(cntr, &events.Point{X: int32(pt.X), Y: int32(pt.Y)})
+	var obs StageObserver = spanObserver{ctx: ctx, tracer: r.tracer}
+	if debugStreams && len(r.stageStreams) > 0 {
+		obs = &streamObserver{streams: r.stageStreams, next: obs}
 	}
+	timer := newStageTimer(obs)
+	detection := r.roadDetector.Detect(img, timer)
+	defer func() {
+		if err := detection.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+	}()
 
-	msg := events.RoadMessage{
-		Contour:  cntr,
-		Ellipse:  ellipse,
-		FrameRef: frame.ref,
+	if debugStreams {
+		r.updateDebugStreams(img, detection.Mask, detection.Road, detection.Ellipse)
 	}
 
-	payload, err := proto.Marshal(&msg)
+	result := detectionResult{
+		msg: &events.RoadMessage{
+			Contour:  detection.Contour(),
+			Ellipse:  detection.Ellipse,
+			FrameRef: ref,
+		},
+		config:     r.roadDetector.Config(),
+		candidates: detection.Candidates,
+		confidence: detection.Confidence,
+		timings:    timer.timings,
+	}
+	result.imageSize = image.Point{X: img.Cols(), Y: img.Rows()}
+	if r.waypointsTopic != "" {
+		result.waypoints = r.roadWaypoints(detection.Mask, detection.Road)
+	}
+	if r.poseTopic != "" {
+		result.pose = lane.EstimatePose(detection.Road.ToPoints(), result.imageSize, r.groundHomography)
+	}
+	setRoadAttributes(trace.SpanFromContext(ctx), result.msg)
+	if !annotate {
+		return &result, nil
+	}
+
+	annotated := AnnotateRoad(img, detection.Road, detection.Ellipse, r.roadDetector.Horizon())
+	defer func() {
+		if err := annotated.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+	}()
+	jpeg, err := encodeJPEG(annotated)
 	if err != nil {
-		zap.S().Errorf("unable to marshal %T to protobuf: %err", msg, err)
+		return nil, err
+	}
+	result.annotated = jpeg
+	return &result, nil
+}
+
+// publishRoad publishes msg as protobuf on road topic and as json on road json topic, if configured
+func (r *RoadPart) publishRoad(msg *events.RoadMessage) {
+	if r.roadTopic != "" {
+		payload, err := proto.Marshal(msg)
+		if err != nil {
+			zap.S().Errorf("unable to marshal %T to protobuf: %v", msg, err)
+		} else {
+			r.publishPayload(r.roadTopic, payload)
+		}
+	}
+
+	if r.roadJSONTopic != "" {
+		// Emit zero values (ex: x = 0) to keep the same structure for all messages
+		payload, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+		if err != nil {
+			zap.S().Errorf("unable to marshal %T to json: %v", msg, err)
+		} else {
+			r.publishPayload(r.roadJSONTopic, payload)
+		}
+	}
+}
+
+func (r *RoadPart) publishPayload(topic string, payload []byte) {
+	if err := r.transport.Publish(topic, payload); err != nil {
+		metricPublishErrors.Add(1)
+		zap.S().Errorf("unable to publish road message on topic %v: %v", topic, err)
 		return
 	}
-	publish(r.client, r.roadTopic, &payload)
+	metricPublishedMessages.Add(1)
 }
 
-var publish = func(client mqtt.Client, topic string, payload *[]byte) {
-	client.Publish(topic, 0, false, *payload)
+// updateDebugStreams encodes and publishes debug images, only if some http clients watch them
+func (r *RoadPart) updateDebugStreams(img, mask gocv.Mat, road *gocv.PointVector, ellipse *events.Ellipse) {
+	if r.imgStream != nil && r.imgStream.HasClients() {
+		annotated := AnnotateRoad(img, road, ellipse, r.roadDetector.Horizon())
+		jpeg, err := encodeJPEG(annotated)
+		if err := annotated.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+		if err != nil {
+			zap.S().Errorf("unable to publish annotated image on stream: %v", err)
+		} else {
+			r.imgStream.Update(jpeg)
+		}
+	}
+
+	if r.maskStream != nil && r.maskStream.HasClients() {
+		jpeg, err := encodeJPEG(mask)
+		if err != nil {
+			zap.S().Errorf("unable to publish mask image on stream: %v", err)
+		} else {
+			r.maskStream.Update(jpeg)
+		}
+	}
 }
@@ -1,69 +1,81 @@
 package part
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/cyrilix/robocar-base/testtools"
 	"github.com/cyrilix/robocar-protobuf/go/events"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/cyrilix/robocar-road/pkg/mjpeg"
+	"github.com/cyrilix/robocar-road/pkg/transport"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 )
 
 func TestRoadPart_OnFrame(t *testing.T) {
-	oldRegister := registerCallBacks
-	oldPublish := publish
-	defer func() {
-		registerCallBacks = oldRegister
-		publish = oldPublish
-	}()
+	cameraTopic := "topic/camera"
+	roadTopic := "topic/road"
 
-	registerCallBacks = func(_ *RoadPart) {}
+	bus := transport.NewMemory()
+	defer bus.Close()
 
-	var muEventsPublished sync.Mutex
-	eventsPublished := make(map[string][]byte)
-	publish = func(client mqtt.Client, topic string, payload *[]byte) {
-		muEventsPublished.Lock()
-		defer muEventsPublished.Unlock()
-		eventsPublished[topic] = *payload
+	roadMessages := make(chan []byte, 1)
+	err := bus.Subscribe(roadTopic, func(_ string, payload []byte) {
+		select {
+		case roadMessages <- payload:
+		default:
+			// Drop results of frames published again while waiting for first result
+		}
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe to road topic: %v", err)
 	}
 
-	cameraTopic := "topic/camera"
-	roadTopic := "topic/road"
-
-	rp := NewRoadPart(nil, 20, cameraTopic, roadTopic)
+	rp := NewRoadPart(bus, 20, cameraTopic, roadTopic)
+	defer rp.Stop()
 	go func() {
-		if err := rp.Start(); err != nil {
+		if err := rp.Start(context.Background()); err != nil {
 			t.Errorf("unable to start roadPart: %v", err)
-			t.FailNow()
 		}
 	}()
 
 	cases := []struct {
 		name            string
-		msg             mqtt.Message
+		payload         []byte
 		expectedCntr    []*events.Point
 		expectedEllipse events.Ellipse
 	}{
 		{
 			name:            "image1",
-			msg:             loadFrame(t, cameraTopic, "image"),
+			payload:         loadFrame(t, "image"),
 			expectedCntr:    []*events.Point{&events.Point{X: 0, Y: int32(45)}, &events.Point{X: 0, Y: 127}, &events.Point{X: 144, Y: 127}, &events.Point{X: 95, Y: 21}, &events.Point{X: 43, Y: 21}},
 			expectedEllipse: events.Ellipse{Center: &events.Point{X: 71, Y: 87}, Width: 139, Height: 176, Angle: 92.66927, Confidence: 1.},
 		},
 	}
 
 	for _, c := range cases {
-		rp.OnFrame(nil, c.msg)
-
-		time.Sleep(20 * time.Millisecond)
+		// Publish frame until part has subscribed to camera topic
+		var roadPayload []byte
+		for roadPayload == nil {
+			if err := bus.Publish(cameraTopic, c.payload); err != nil {
+				t.Fatalf("unable to publish frame: %v", err)
+			}
+			select {
+			case roadPayload = <-roadMessages:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
 
 		var roadMsg events.RoadMessage
-		err := proto.Unmarshal(eventsPublished[roadTopic], &roadMsg)
+		err := proto.Unmarshal(roadPayload, &roadMsg)
 		if err != nil {
 			t.Errorf("unable to unmarshal response, bad return type: %v", err)
 			continue
@@ -80,13 +92,153 @@ func TestRoadPart_OnFrame(t *testing.T) {
 		if roadMsg.Ellipse.String() != c.expectedEllipse.String() {
 			t.Errorf("[%v] bad ellipse: %v, wants %v", c.name, roadMsg.Ellipse, c.expectedEllipse)
 		}
-		frameRef := frameRefFromPayload(c.msg.Payload())
+		frameRef := frameRefFromPayload(c.payload)
 		if frameRef.String() != roadMsg.GetFrameRef().String() {
 			t.Errorf("[%v] invalid frameRef: %v, wants %v", c.name, roadMsg.GetFrameRef(), frameRef)
 		}
 	}
 }
 
+func TestRoadPart_Lifecycle(t *testing.T) {
+	// Block publication to simulate slow in-flight frame
+	publishing := make(chan struct{})
+	releasePublish := make(chan struct{})
+	bus := newRecordingTransport()
+	bus.onPublish = func(_ string) {
+		close(publishing)
+		<-releasePublish
+	}
+
+	cameraTopic := "topic/camera"
+	rp := NewRoadPart(bus, 20, cameraTopic, "topic/road", WithStopTimeout(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error)
+	go func() {
+		started <- rp.Start(ctx)
+	}()
+
+	rp.OnFrame(cameraTopic, loadFrame(t, "image"))
+	select {
+	case <-publishing:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("frame not processed")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		rp.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop() blocked by in-flight frame")
+	}
+	close(releasePublish)
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("Start() returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Start() doesn't return after Stop()")
+	}
+
+	// Frames received after Stop are ignored without blocking caller
+	done := make(chan struct{})
+	go func() {
+		rp.OnFrame(cameraTopic, loadFrame(t, "image"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("OnFrame() blocked after Stop()")
+	}
+
+	// Stop is idempotent
+	rp.Stop()
+	cancel()
+}
+
+func TestRoadPart_StartReturnsOnContextDone(t *testing.T) {
+	rp := NewRoadPart(newRecordingTransport(), 20, "topic/camera", "topic/road")
+	defer rp.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error)
+	go func() {
+		started <- rp.Start(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("Start() returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Start() doesn't return after context cancellation")
+	}
+}
+
+func TestRoadPart_PublishRoad(t *testing.T) {
+	createdAt := time.Date(2022, 6, 1, 12, 30, 15, 500000000, time.UTC)
+	msg := events.RoadMessage{
+		Contour: []*events.Point{{X: 0, Y: 45}, {X: 144, Y: 127}},
+		Ellipse: &events.Ellipse{Center: &events.Point{X: 71, Y: 87}, Width: 139, Height: 176, Angle: 92.5, Confidence: 1.},
+		FrameRef: &events.FrameRef{
+			Name:      "camera",
+			Id:        "frame-1",
+			CreatedAt: &timestamp.Timestamp{Seconds: createdAt.Unix(), Nanos: int32(createdAt.Nanosecond())},
+		},
+	}
+
+	cases := []struct {
+		name           string
+		roadTopic      string
+		jsonTopic      string
+		expectedTopics []string
+	}{
+		{"protobuf only", "topic/road", "", []string{"topic/road"}},
+		{"json only", "", "topic/road/json", []string{"topic/road/json"}},
+		{"protobuf and json", "topic/road", "topic/road/json", []string{"topic/road", "topic/road/json"}},
+	}
+
+	for _, c := range cases {
+		published := newRecordingTransport()
+		r := RoadPart{transport: published, roadTopic: c.roadTopic, roadJSONTopic: c.jsonTopic}
+		r.publishRoad(&msg)
+
+		if len(published.messages) != len(c.expectedTopics) {
+			t.Errorf("[%v] bad number of published messages: %v, wants %v", c.name, len(published.messages), len(c.expectedTopics))
+		}
+		if c.roadTopic != "" {
+			var roadMsg events.RoadMessage
+			if err := proto.Unmarshal(published.last(c.roadTopic), &roadMsg); err != nil || !proto.Equal(&roadMsg, &msg) {
+				t.Errorf("[%v] bad protobuf message: %v (err: %v), wants %v", c.name, &roadMsg, err, &msg)
+			}
+		}
+		if c.jsonTopic != "" {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(published.last(c.jsonTopic), &doc); err != nil {
+				t.Errorf("[%v] invalid json message: %v", c.name, err)
+				continue
+			}
+			frameRef, _ := doc["frameRef"].(map[string]interface{})
+			if frameRef["name"] != "camera" || frameRef["id"] != "frame-1" || frameRef["createdAt"] != "2022-06-01T12:30:15.500Z" {
+				t.Errorf("[%v] bad json frameRef: %v", c.name, doc["frameRef"])
+			}
+			contour, _ := doc["contour"].([]interface{})
+			if len(contour) != 2 || fmt.Sprint(contour[0]) != "map[x:0 y:45]" {
+				t.Errorf("[%v] bad json contour: %v", c.name, doc["contour"])
+			}
+		}
+	}
+}
+
 func frameRefFromPayload(payload []byte) *events.FrameRef {
 	var msg events.FrameMessage
 	err := proto.Unmarshal(payload, &msg)
@@ -96,7 +248,7 @@ func frameRefFromPayload(payload []byte) *events.FrameRef {
 	return msg.GetId()
 }
 
-func loadFrame(t *testing.T, topic string, name string) mqtt.Message {
+func loadFrame(t *testing.T, name string) []byte {
 	img, err := ioutil.ReadFile(fmt.Sprintf("testdata/%s.jpg", name))
 	if err != nil {
 		t.Fatalf("unable to load data test image: %v", err)
@@ -114,5 +266,143 @@ func loadFrame(t *testing.T, topic string, name string) mqtt.Message {
 		},
 		Frame: img,
 	}
-	return testtools.NewFakeMessageFromProtobuf(topic, &msg)
+	payload, err := proto.Marshal(&msg)
+	if err != nil {
+		t.Fatalf("unable to marshal frame: %v", err)
+	}
+	return payload
+}
+
+type publishedMessage struct {
+	topic   string
+	payload []byte
+}
+
+// recordingTransport keeps published messages in memory and ignores subscriptions
+type recordingTransport struct {
+	mu        sync.Mutex
+	messages  []publishedMessage
+	onPublish func(topic string)
+}
+
+func newRecordingTransport() *recordingTransport {
+	return &recordingTransport{}
+}
+
+func (f *recordingTransport) Subscribe(_ string, _ transport.Handler) error { return nil }
+func (f *recordingTransport) Unsubscribe(_ ...string) error                 { return nil }
+func (f *recordingTransport) Close() error                                  { return nil }
+
+func (f *recordingTransport) Publish(topic string, payload []byte) error {
+	if f.onPublish != nil {
+		f.onPublish(topic)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, publishedMessage{topic: topic, payload: payload})
+	return nil
+}
+
+// last returns the last payload published on topic
+func (f *recordingTransport) last(topic string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if f.messages[i].topic == topic {
+			return f.messages[i].payload
+		}
+	}
+	return nil
+}
+
+// watchStream connects an http client to stream and returns a channel that receives a value for each image sent to
+// client. Client is disconnected at the end of test.
+func watchStream(t *testing.T, stream *mjpeg.Stream) <-chan struct{} {
+	srv := httptest.NewServer(stream)
+	t.Cleanup(srv.Close)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to connect to stream: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("bad stream content-type: %v", err)
+	}
+	images := make(chan struct{}, 10)
+	go func() {
+		reader := multipart.NewReader(resp.Body, params["boundary"])
+		for {
+			if _, err := reader.NextPart(); err != nil {
+				return
+			}
+			select {
+			case images <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !stream.HasClients() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for stream client registration")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return images
+}
+
+func TestRoadPart_DebugStreamsOnlyShowCameraFrames(t *testing.T) {
+	imgStream, maskStream := mjpeg.NewStream(), mjpeg.NewStream()
+	stageStreams := map[Stage]*mjpeg.Stream{
+		StageGray:       mjpeg.NewStream(),
+		StageMorphology: mjpeg.NewStream(),
+		StageThreshold:  mjpeg.NewStream(),
+	}
+	rp := NewRoadPart(newRecordingTransport(), 20, "topic/camera", "topic/road",
+		WithDebugStreams(imgStream, maskStream), WithStageStreams(stageStreams))
+	defer rp.Stop()
+
+	images := map[string]<-chan struct{}{
+		"road": watchStream(t, imgStream),
+		"mask": watchStream(t, maskStream),
+	}
+	for stage, stream := range stageStreams {
+		images[string(stage)] = watchStream(t, stream)
+	}
+
+	var frame events.FrameMessage
+	if err := proto.Unmarshal(loadFrame(t, "image"), &frame); err != nil {
+		t.Fatalf("unable to unmarshal frame: %v", err)
+	}
+	if _, err := rp.DetectRoad(&frame); err != nil {
+		t.Fatalf("unable to detect road: %v", err)
+	}
+	if _, _, err := rp.DetectAnnotatedRoad(&frame); err != nil {
+		t.Fatalf("unable to detect annotated road: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for name, c := range images {
+		select {
+		case <-c:
+			t.Errorf("[%v] stream updated by detect request", name)
+		default:
+		}
+	}
+
+	cameraFrame := rp.readFrame(loadFrame(t, "image"), time.Now())
+	if cameraFrame == nil {
+		t.Fatalf("unable to read camera frame")
+	}
+	rp.processFrame(cameraFrame)
+	rp.closeFrame(cameraFrame)
+	for name, c := range images {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Errorf("[%v] stream not updated by camera frame", name)
+		}
+	}
 }
@@ -0,0 +1,49 @@
+package part
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"go.uber.org/zap"
+	"time"
+)
+
+// PoseMessage holds lane-keeping signals of a frame, published as json on pose topic
+type PoseMessage struct {
+	FrameId   string `json:"frame_id"`
+	FrameName string `json:"frame_name"`
+	// CreatedAt is the frame creation date by camera, if known
+	CreatedAt *time.Time `json:"created_at"`
+	lane.Pose
+}
+
+// WithPoseTopic publishes lateral offset and heading error of car, estimated from road contour, as json PoseMessage
+// on topic. Ground measures are added if homography is calibrated.
+func WithPoseTopic(topic string, homography lane.Homography) Option {
+	return func(r *RoadPart) {
+		r.poseTopic = topic
+		r.groundHomography = homography
+	}
+}
+
+// publishPose publishes pose of result on pose topic, if configured
+func (r *RoadPart) publishPose(ref *events.FrameRef, result *detectionResult) {
+	if r.poseTopic == "" {
+		return
+	}
+	msg := PoseMessage{
+		FrameId:   ref.GetId(),
+		FrameName: ref.GetName(),
+		Pose:      result.pose,
+	}
+	if ref.GetCreatedAt() != nil {
+		createdAt := ref.GetCreatedAt().AsTime()
+		msg.CreatedAt = &createdAt
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal %T to json: %v", msg, err)
+		return
+	}
+	r.publishPayload(r.poseTopic, payload)
+}
@@ -0,0 +1,59 @@
+package part
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"testing"
+)
+
+func TestRoadPart_PublishPose(t *testing.T) {
+	published := newRecordingTransport()
+	homography := lane.Homography{10, 0, -800, 0, 0, 1000, 0, 1, -20}
+	rp := NewRoadPart(published, 20, "topic/camera", "topic/road", WithPoseTopic("topic/pose", homography))
+	defer rp.Stop()
+
+	var frame events.FrameMessage
+	if err := proto.Unmarshal(loadFrame(t, "image"), &frame); err != nil {
+		t.Fatalf("unable to unmarshal frame: %v", err)
+	}
+	result, err := rp.detectFrame(&frame, false)
+	if err != nil {
+		t.Fatalf("unable to detect road: %v", err)
+	}
+	rp.publishPose(frame.GetId(), result)
+
+	var msg PoseMessage
+	if err := json.Unmarshal(published.last("topic/pose"), &msg); err != nil {
+		t.Fatalf("invalid pose message: %v", err)
+	}
+	if msg.FrameId != frame.GetId().GetId() || msg.FrameName != frame.GetId().GetName() || msg.CreatedAt == nil {
+		t.Errorf("bad frame ref: %v/%v/%v, wants %v", msg.FrameId, msg.FrameName, msg.CreatedAt, frame.GetId())
+	}
+
+	// Road contour of image is (0, 45), (0, 127), (144, 127), (95, 21), (43, 21): road bottom is [0, 144], car is
+	// on the right of road center, and road right edge goes to the left
+	if expected := (79.5 - 72) / 72; math.Abs(msg.Offset-expected) > 0.01 {
+		t.Errorf("bad offset: %v, wants %v", msg.Offset, expected)
+	}
+	if msg.Heading >= 0 || msg.Heading < -0.5 {
+		t.Errorf("bad heading: %v, wants slightly to the left", msg.Heading)
+	}
+	if msg.Confidence < 0.9 {
+		t.Errorf("bad confidence: %v", msg.Confidence)
+	}
+	if msg.OffsetCm == nil || *msg.OffsetCm <= 0 || msg.HeadingDeg == nil {
+		t.Errorf("bad ground measures: %v cm, %v deg", msg.OffsetCm, msg.HeadingDeg)
+	}
+}
+
+func TestRoadPart_PublishPoseDisabled(t *testing.T) {
+	published := newRecordingTransport()
+	r := RoadPart{transport: published}
+	r.publishPose(nil, &detectionResult{})
+	if len(published.messages) != 0 {
+		t.Errorf("pose published without topic: %v", published.messages)
+	}
+}
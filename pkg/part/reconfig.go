@@ -0,0 +1,90 @@
+package part
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+)
+
+const (
+	ConfigApplied  = "applied"
+	ConfigRejected = "rejected"
+)
+
+// ConfigAck is published after each configuration update request
+type ConfigAck struct {
+	// Status is ConfigApplied or ConfigRejected
+	Status string `json:"status"`
+	// Error explains why configuration has been rejected
+	Error string `json:"error,omitempty"`
+	// Config is the effective configuration after update
+	Config DetectorConfig `json:"config"`
+}
+
+// OnConfig applies a new detector configuration from a json document (see DetectorConfig).
+// Fields missing from document keep their current value.
+func (r *RoadPart) OnConfig(_ string, payload []byte) {
+	config, err := r.ApplyConfig(payload)
+	ack := ConfigAck{Status: ConfigApplied, Config: config}
+	if err != nil {
+		metricConfigRejected.Add(1)
+		zap.S().Errorf("reject new configuration: %v", err)
+		ack.Status = ConfigRejected
+		ack.Error = err.Error()
+	} else {
+		metricConfigApplied.Add(1)
+		zap.S().Infof("new configuration applied: %+v", config)
+	}
+
+	if r.configAckTopic == "" {
+		return
+	}
+	ackPayload, err := json.Marshal(&ack)
+	if err != nil {
+		zap.S().Errorf("unable to marshal %T to json: %v", ack, err)
+		return
+	}
+	if err := r.transport.Publish(r.configAckTopic, ackPayload); err != nil {
+		zap.S().Errorf("unable to publish config acknowledgment on topic %v: %v", r.configAckTopic, err)
+	}
+}
+
+// ApplyConfig validates and applies configuration json document between two frames processing.
+// It returns effective configuration.
+func (r *RoadPart) ApplyConfig(doc []byte) (DetectorConfig, error) {
+	// Wait for in-progress frames and block next ones until update is done
+	r.muConfig.Lock()
+	defer r.muConfig.Unlock()
+
+	config, err := ParseDetectorConfig(doc, r.roadDetector.Config())
+	if err != nil {
+		return r.roadDetector.Config(), err
+	}
+	if err := r.roadDetector.Configure(config); err != nil {
+		return r.roadDetector.Config(), fmt.Errorf("invalid configuration: %w", err)
+	}
+	return config, nil
+}
+
+// ParseDetectorConfig reads json document on top of base configuration, unknown fields and trailing data are rejected
+func ParseDetectorConfig(doc []byte, base DetectorConfig) (DetectorConfig, error) {
+	config := base
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return base, fmt.Errorf("invalid configuration document: %w", err)
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return base, fmt.Errorf("invalid configuration document: unexpected data after json object")
+	}
+	return config, nil
+}
+
+// Config returns current detector configuration
+func (r *RoadPart) Config() DetectorConfig {
+	r.muConfig.RLock()
+	defer r.muConfig.RUnlock()
+	return r.roadDetector.Config()
+}
@@ -0,0 +1,81 @@
+package part
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRoadPart_ApplyConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		doc      string
+		expected func(c *DetectorConfig)
+		wantErr  bool
+	}{
+		{"partial update", `{"horizon": 30, "kernel_size": 5}`,
+			func(c *DetectorConfig) { c.Horizon = 30; c.KernelSize = 5 }, false},
+		{"trust region", `{"trust_region": {"min_x": 10, "max_x": 150, "min_y": 50, "max_y": 127}}`,
+			func(c *DetectorConfig) { c.TrustRegion = TrustRegion{MinX: 10, MaxX: 150, MinY: 50, MaxY: 127} }, false},
+		{"invalid value", `{"horizon": 30, "kernel_size": 0}`, func(c *DetectorConfig) {}, true},
+		{"unknown field", `{"horizont": 30}`, func(c *DetectorConfig) {}, true},
+		{"bad json", `{"horizon": `, func(c *DetectorConfig) {}, true},
+		{"trailing object", `{"horizon": 30} {"kernel_size": 5}`, func(c *DetectorConfig) {}, true},
+		{"trailing garbage", `{"horizon": 30} xx`, func(c *DetectorConfig) {}, true},
+		{"trailing spaces", "{\"horizon\": 30}\n ", func(c *DetectorConfig) { c.Horizon = 30 }, false},
+	}
+
+	for _, c := range cases {
+		r := RoadPart{roadDetector: &RoadDetector{config: DefaultDetectorConfig()}}
+		expected := DefaultDetectorConfig()
+		c.expected(&expected)
+
+		config, err := r.ApplyConfig([]byte(c.doc))
+		if (err != nil) != c.wantErr {
+			t.Errorf("[%v] ApplyConfig() error: %v, wants error: %v", c.name, err, c.wantErr)
+		}
+		if config != expected {
+			t.Errorf("[%v] bad returned config: %+v, wants %+v", c.name, config, expected)
+		}
+		if r.Config() != expected {
+			t.Errorf("[%v] bad effective config: %+v, wants %+v", c.name, r.Config(), expected)
+		}
+	}
+}
+
+func TestRoadPart_OnConfig(t *testing.T) {
+	published := newRecordingTransport()
+	r := RoadPart{
+		transport:      published,
+		roadDetector:   &RoadDetector{config: DefaultDetectorConfig()},
+		configTopic:    "topic/config",
+		configAckTopic: "topic/config/ack",
+	}
+
+	cases := []struct {
+		name           string
+		doc            string
+		expectedStatus string
+		expectedHoriz  int
+	}{
+		{"valid", `{"horizon": 42}`, ConfigApplied, 42},
+		{"invalid", `{"horizon": -1}`, ConfigRejected, 42},
+	}
+	for _, c := range cases {
+		r.OnConfig(r.configTopic, []byte(c.doc))
+
+		var ack ConfigAck
+		if err := json.Unmarshal(published.last(r.configAckTopic), &ack); err != nil {
+			t.Errorf("[%v] unable to unmarshal acknowledgment: %v", c.name, err)
+			continue
+		}
+		if ack.Status != c.expectedStatus {
+			t.Errorf("[%v] bad status: %v, wants %v", c.name, ack.Status, c.expectedStatus)
+		}
+		if c.expectedStatus == ConfigRejected && ack.Error == "" {
+			t.Errorf("[%v] rejected acknowledgment without error", c.name)
+		}
+		if ack.Config.Horizon != c.expectedHoriz {
+			t.Errorf("[%v] bad effective horizon: %v, wants %v", c.name, ack.Config.Horizon, c.expectedHoriz)
+		}
+	}
+}
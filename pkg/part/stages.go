@@ -0,0 +1,121 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/mjpeg"
+	"go.uber.org/zap"
+	"gocv.io/x/gocv"
+	"time"
+)
+
+// Stage is a step of road detection pipeline
+type Stage string
+
+const (
+	StageDecode     Stage = "decode"
+	StageGray       Stage = "gray"
+	StageMorphology Stage = "morphology"
+	StageThreshold  Stage = "threshold"
+	StageHorizon    Stage = "horizon"
+	StageContour    Stage = "contour"
+	StageEllipse    Stage = "ellipse"
+)
+
+// Stages lists pipeline steps in execution order
+var Stages = []Stage{StageDecode, StageGray, StageMorphology, StageThreshold, StageHorizon, StageContour, StageEllipse}
+
+// StageObserver is notified at the end of each pipeline stage.
+//
+// img is the stage result when stage produces an image, nil otherwise. It's only valid during the call.
+type StageObserver interface {
+	OnStage(stage Stage, start time.Time, duration time.Duration, img *gocv.Mat)
+}
+
+func observe(obs StageObserver, stage Stage, start time.Time, img *gocv.Mat) {
+	if obs != nil {
+		obs.OnStage(stage, start, time.Since(start), img)
+	}
+}
+
+// streamObserver publishes stage images on debug streams and forwards notifications to next observer
+type streamObserver struct {
+	streams map[Stage]*mjpeg.Stream
+	next    StageObserver
+}
+
+func (s *streamObserver) OnStage(stage Stage, start time.Time, duration time.Duration, img *gocv.Mat) {
+	// Encoding is only done if some http clients watch stage
+	if stream, ok := s.streams[stage]; ok && img != nil && stream.HasClients() {
+		jpeg, err := encodeJPEG(*img)
+		if err != nil {
+			zap.S().Errorf("unable to publish %v image on stream: %v", stage, err)
+		} else {
+			stream.Update(jpeg)
+		}
+	}
+	if s.next != nil {
+		s.next.OnStage(stage, start, duration, img)
+	}
+}
+
+// Detection is the result of road detection on one image
+type Detection struct {
+	// Mask is the binary image of road pixels
+	Mask    gocv.Mat
+	Road    *gocv.PointVector
+	Ellipse *events.Ellipse
+	// Candidates is the number of contours found on mask, road being the biggest one
+	Candidates int
+	Confidence ConfidenceFactors
+}
+
+func (d *Detection) Close() error {
+	d.Road.Close()
+	return d.Mask.Close()
+}
+
+// Contour returns road contour as protobuf points
+func (d *Detection) Contour() []*events.Point {
+	cntr := make([]*events.Point, 0, d.Road.Size())
+	for i := 0; i < d.Road.Size(); i++ {
+		pt := d.Road.At(i)
+		cntr = append(cntr, &events.Point{X: int32(pt.X), Y: int32(pt.Y)})
+	}
+	return cntr
+}
+
+// Detect runs the whole road detection pipeline on img, a color image, with configured horizon.
+// obs, if not nil, is notified after each stage. Caller is responsible for closing returned Detection.
+func (rd *RoadDetector) Detect(img gocv.Mat, obs StageObserver) *Detection {
+	start := time.Now()
+	imgGray, err := toGrayscale(img)
+	defer func() {
+		if err := imgGray.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+	}()
+	if err != nil {
+		zap.S().Warnf("unable to convert image to gray: %v", err)
+		road := gocv.NewPointVector()
+		return &Detection{Mask: gocv.NewMat(), Road: &road, Ellipse: &EllipseNotFound}
+	}
+	observe(obs, StageGray, start, &imgGray)
+
+	mask := rd.detectRoadMask(&imgGray, rd.config.Horizon, obs)
+
+	start = time.Now()
+	road, candidates := rd.detectRoadContour(&mask)
+	observe(obs, StageContour, start, nil)
+
+	start = time.Now()
+	ellipse := rd.ComputeEllipsis(road)
+	observe(obs, StageEllipse, start, nil)
+
+	return &Detection{
+		Mask:       mask,
+		Road:       road,
+		Ellipse:    ellipse,
+		Candidates: candidates,
+		Confidence: rd.confidenceFactors(ellipse),
+	}
+}
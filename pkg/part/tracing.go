@@ -0,0 +1,66 @@
+package part
+
+import (
+	"context"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gocv.io/x/gocv"
+	"time"
+)
+
+// TracerName is the instrumentation name of road detection spans
+const TracerName = "github.com/cyrilix/robocar-road/pkg/part"
+
+// Span attributes
+const (
+	AttrFrameId           = attribute.Key("frame.id")
+	AttrFrameName         = attribute.Key("frame.name")
+	AttrFrameCreatedAt    = attribute.Key("frame.created_at")
+	AttrContourSize       = attribute.Key("road.contour.size")
+	AttrEllipseConfidence = attribute.Key("road.ellipse.confidence")
+)
+
+// WithTracerProvider records spans with provider instead of the global tracer provider
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(r *RoadPart) {
+		r.tracer = provider.Tracer(TracerName)
+	}
+}
+
+// defaultTracer relies on global tracer provider, spans are dropped until a provider is registered
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// startFrameSpan starts the root span of frame processing
+func (r *RoadPart) startFrameSpan(ctx context.Context, name string, ref *events.FrameRef) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		AttrFrameId.String(ref.GetId()),
+		AttrFrameName.String(ref.GetName()),
+	}
+	if ref.GetCreatedAt() != nil {
+		attrs = append(attrs, AttrFrameCreatedAt.String(ref.GetCreatedAt().AsTime().Format(time.RFC3339Nano)))
+	}
+	return r.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+}
+
+// setRoadAttributes adds detection result to span
+func setRoadAttributes(span trace.Span, msg *events.RoadMessage) {
+	span.SetAttributes(
+		AttrContourSize.Int(len(msg.GetContour())),
+		AttrEllipseConfidence.Float64(float64(msg.GetEllipse().GetConfidence())),
+	)
+}
+
+// spanObserver records a child span of ctx for each pipeline stage
+type spanObserver struct {
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+func (o spanObserver) OnStage(stage Stage, start time.Time, duration time.Duration, _ *gocv.Mat) {
+	_, span := o.tracer.Start(o.ctx, string(stage), trace.WithTimestamp(start))
+	span.End(trace.WithTimestamp(start.Add(duration)))
+}
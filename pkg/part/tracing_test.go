@@ -0,0 +1,56 @@
+package part
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRoadPart_DetectRoadSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	img, err := ioutil.ReadFile("testdata/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to load data test image: %v", err)
+	}
+	rp := NewRoadPart(newRecordingTransport(), 20, "", "", WithTracerProvider(provider))
+	defer rp.Stop()
+
+	_, err = rp.DetectRoad(&events.FrameMessage{Id: &events.FrameRef{Name: "camera", Id: "frame-1"}, Frame: img})
+	if err != nil {
+		t.Fatalf("unable to detect road: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != len(Stages)+1 {
+		t.Fatalf("bad number of spans: %v, wants %v", len(spans), len(Stages)+1)
+	}
+	root := spans[len(spans)-1]
+	if root.Name() != "road.detect" {
+		t.Errorf("bad root span: %v", root.Name())
+	}
+	for idx, stage := range Stages {
+		if spans[idx].Name() != string(stage) || spans[idx].Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("bad span for stage %v: %v (parent %v)", stage, spans[idx].Name(), spans[idx].Parent().SpanID())
+		}
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range root.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	expected := map[string]string{
+		string(AttrFrameId):           "frame-1",
+		string(AttrFrameName):         "camera",
+		string(AttrContourSize):       "5",
+		string(AttrEllipseConfidence): "1",
+	}
+	for k, v := range expected {
+		if attrs[k] != v {
+			t.Errorf("bad attribute %v: %v, wants %v", k, attrs[k], v)
+		}
+	}
+}
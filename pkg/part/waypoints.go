@@ -0,0 +1,82 @@
+package part
+
+import (
+	"encoding/json"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"go.uber.org/zap"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+	"time"
+)
+
+// WaypointsMessage is the road centerline of a frame, published as json on waypoints topic
+type WaypointsMessage struct {
+	FrameId   string `json:"frame_id"`
+	FrameName string `json:"frame_name"`
+	// CreatedAt is the frame creation date by camera, if known
+	CreatedAt *time.Time `json:"created_at"`
+	// ImageWidth and ImageHeight are frame dimensions, waypoints are in this frame coordinates
+	ImageWidth  int    `json:"image_width"`
+	ImageHeight int    `json:"image_height"`
+	Spacing     string `json:"spacing"`
+	// Waypoints are ordered from bottom of image, empty if road is not found
+	Waypoints []lane.Waypoint `json:"waypoints"`
+}
+
+// WithWaypointsTopic publishes waypoints of road centerline, computed by extractor, as json WaypointsMessage on topic
+func WithWaypointsTopic(topic string, extractor *lane.Extractor) Option {
+	return func(r *RoadPart) {
+		r.waypointsTopic = topic
+		r.waypointsExtractor = extractor
+	}
+}
+
+// roadWaypoints returns waypoints of centerline of mask pixels inside road contour
+func (r *RoadPart) roadWaypoints(mask gocv.Mat, road *gocv.PointVector) []lane.Waypoint {
+	if mask.Empty() || mask.Type() != gocv.MatTypeCV8U || road.Size() < 3 {
+		return []lane.Waypoint{}
+	}
+
+	roadMask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), mask.Rows(), mask.Cols(), gocv.MatTypeCV8U)
+	defer func() {
+		if err := roadMask.Close(); err != nil {
+			zap.S().Warnf("unable to close Mat resource: %v", err)
+		}
+	}()
+	contours := gocv.NewPointsVector()
+	defer contours.Close()
+	contours.Append(*road)
+	// Other candidate contours of mask are not part of road
+	gocv.FillPoly(&roadMask, contours, color.RGBA{R: 255, G: 255, B: 255})
+	gocv.BitwiseAnd(mask, roadMask, &roadMask)
+
+	gray := image.Gray{Pix: roadMask.ToBytes(), Stride: roadMask.Cols(), Rect: image.Rect(0, 0, roadMask.Cols(), roadMask.Rows())}
+	return r.waypointsExtractor.Waypoints(&gray)
+}
+
+// publishWaypoints publishes waypoints of result on waypoints topic, if configured
+func (r *RoadPart) publishWaypoints(ref *events.FrameRef, result *detectionResult) {
+	if r.waypointsTopic == "" {
+		return
+	}
+	msg := WaypointsMessage{
+		FrameId:     ref.GetId(),
+		FrameName:   ref.GetName(),
+		ImageWidth:  result.imageSize.X,
+		ImageHeight: result.imageSize.Y,
+		Spacing:     r.waypointsExtractor.Config().Spacing,
+		Waypoints:   result.waypoints,
+	}
+	if ref.GetCreatedAt() != nil {
+		createdAt := ref.GetCreatedAt().AsTime()
+		msg.CreatedAt = &createdAt
+	}
+	payload, err := json.Marshal(&msg)
+	if err != nil {
+		zap.S().Errorf("unable to marshal %T to json: %v", msg, err)
+		return
+	}
+	r.publishPayload(r.waypointsTopic, payload)
+}
@@ -0,0 +1,106 @@
+package part
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cyrilix/robocar-road/pkg/lane"
+	"github.com/cyrilix/robocar-road/pkg/transport"
+	"gocv.io/x/gocv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoadPart_PublishWaypoints(t *testing.T) {
+	cameraTopic := "topic/camera"
+	waypointsTopic := "topic/waypoints"
+
+	bus := transport.NewMemory()
+	defer bus.Close()
+
+	messages := make(chan []byte, 1)
+	err := bus.Subscribe(waypointsTopic, func(_ string, payload []byte) {
+		select {
+		case messages <- payload:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe to waypoints topic: %v", err)
+	}
+
+	extractor, err := lane.NewExtractor(lane.DefaultConfig())
+	if err != nil {
+		t.Fatalf("unable to create waypoints extractor: %v", err)
+	}
+	rp := NewRoadPart(bus, 20, cameraTopic, "topic/road", WithWaypointsTopic(waypointsTopic, extractor))
+	defer rp.Stop()
+	go func() {
+		if err := rp.Start(context.Background()); err != nil {
+			t.Errorf("unable to start roadPart: %v", err)
+		}
+	}()
+
+	payload := loadFrame(t, "image")
+	ref := frameRefFromPayload(payload)
+	// Publish frame until part has subscribed to camera topic
+	var msgPayload []byte
+	for deadline := time.Now().Add(5 * time.Second); msgPayload == nil && time.Now().Before(deadline); {
+		if err := bus.Publish(cameraTopic, payload); err != nil {
+			t.Fatalf("unable to publish frame: %v", err)
+		}
+		select {
+		case msgPayload = <-messages:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if msgPayload == nil {
+		t.Fatalf("no waypoints published")
+	}
+
+	var msg WaypointsMessage
+	if err := json.Unmarshal(msgPayload, &msg); err != nil {
+		t.Fatalf("invalid waypoints message: %v", err)
+	}
+	if msg.FrameId != ref.GetId() || msg.FrameName != ref.GetName() || msg.CreatedAt == nil || !msg.CreatedAt.Equal(ref.GetCreatedAt().AsTime()) {
+		t.Errorf("bad frame ref: %v/%v/%v, wants %v", msg.FrameId, msg.FrameName, msg.CreatedAt, ref)
+	}
+	if msg.ImageWidth != 160 || msg.ImageHeight != 128 || msg.Spacing != lane.SpacingRows {
+		t.Errorf("bad image size or spacing: %vx%v, %v", msg.ImageWidth, msg.ImageHeight, msg.Spacing)
+	}
+
+	// Road contour of image is (0, 45), (0, 127), (144, 127), (95, 21), (43, 21)
+	if len(msg.Waypoints) != 10 {
+		t.Fatalf("bad waypoints count: %v, wants 10", len(msg.Waypoints))
+	}
+	if bottom := msg.Waypoints[0]; bottom.Y != 127 || bottom.X < 62 || bottom.X > 82 {
+		t.Errorf("bad bottom waypoint: %+v, wants middle of road bottom", bottom)
+	}
+	if top := msg.Waypoints[9]; top.Y > 30 || top.X < 43 || top.X > 95 {
+		t.Errorf("bad top waypoint: %+v, wants on road top", top)
+	}
+	for i := 1; i < len(msg.Waypoints); i++ {
+		if msg.Waypoints[i].Y >= msg.Waypoints[i-1].Y {
+			t.Errorf("waypoint %v not above previous one: %+v, %+v", i, msg.Waypoints[i], msg.Waypoints[i-1])
+		}
+	}
+}
+
+func TestRoadPart_PublishWaypointsWithoutRoad(t *testing.T) {
+	extractor, err := lane.NewExtractor(lane.DefaultConfig())
+	if err != nil {
+		t.Fatalf("unable to create waypoints extractor: %v", err)
+	}
+	published := newRecordingTransport()
+	r := RoadPart{transport: published, waypointsTopic: "topic/waypoints", waypointsExtractor: extractor}
+
+	mask := gocv.NewMat()
+	defer mask.Close()
+	road := gocv.NewPointVector()
+	defer road.Close()
+	r.publishWaypoints(nil, &detectionResult{waypoints: r.roadWaypoints(mask, &road)})
+
+	if payload := string(published.last("topic/waypoints")); !strings.Contains(payload, `"waypoints":[]`) {
+		t.Errorf("bad waypoints message without road: %v", payload)
+	}
+}
@@ -0,0 +1,173 @@
+// Package record reads and writes camera frames streams.
+//
+// A record file starts with a magic header, followed by records appended one after the other:
+//
+//	| received at: int64, unix nanoseconds | payload size: uint32 | payload: raw events.FrameMessage |
+//
+// All integers are big endian.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const magic = "RCROAD1\n"
+
+// MaxPayloadSize protects reader against corrupted files
+const MaxPayloadSize = 16 * 1024 * 1024
+
+var ErrBadHeader = errors.New("not a frames record file")
+
+// Record is a frame payload with its receive time
+type Record struct {
+	ReceivedAt time.Time
+	Payload    []byte
+}
+
+type Writer struct {
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewWriter writes header and returns a writer to append records to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return nil, fmt.Errorf("unable to write header: %w", err)
+	}
+	return &Writer{w: bw}, nil
+}
+
+// OpenFile opens or creates a record file to append records
+func OpenFile(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open record file %v: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to stat record file %v: %w", path, err)
+	}
+	if info.Size() == 0 {
+		w, err := NewWriter(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		w.closer = f
+		return w, nil
+	}
+
+	// Existing file, check it's a record file before appending
+	if err := readHeader(io.NewSectionReader(f, 0, int64(len(magic)))); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to append to %v: %w", path, err)
+	}
+	return &Writer{w: bufio.NewWriter(f), closer: f}, nil
+}
+
+// Write appends a record, records are buffered until Flush or Close
+func (w *Writer) Write(r Record) error {
+	if len(r.Payload) > MaxPayloadSize {
+		return fmt.Errorf("payload too large: %v bytes", len(r.Payload))
+	}
+	var header [12]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(r.ReceivedAt.UnixNano()))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(r.Payload)))
+	if _, err := w.w.Write(header[:]); err != nil {
+		return fmt.Errorf("unable to write record header: %w", err)
+	}
+	if _, err := w.w.Write(r.Payload); err != nil {
+		return fmt.Errorf("unable to write record payload: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Close flushes pending records and closes underlying file if any
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if w.closer != nil {
+		if errClose := w.closer.Close(); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+type Reader struct {
+	r      *bufio.Reader
+	closer io.Closer
+}
+
+// NewReader checks header and returns a reader of records from r
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	if err := readHeader(br); err != nil {
+		return nil, err
+	}
+	return &Reader{r: br}, nil
+}
+
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open record file %v: %w", path, err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to read %v: %w", path, err)
+	}
+	r.closer = f
+	return r, nil
+}
+
+// Next returns the next record, or io.EOF at end of stream.
+// io.ErrUnexpectedEOF is returned if last record is truncated.
+func (r *Reader) Next() (Record, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return Record{}, err
+	}
+	size := binary.BigEndian.Uint32(header[8:12])
+	if size > MaxPayloadSize {
+		return Record{}, fmt.Errorf("corrupted record, invalid payload size: %v", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	return Record{
+		ReceivedAt: time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		Payload:    payload,
+	}, nil
+}
+
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func readHeader(r io.Reader) error {
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != magic {
+		return ErrBadHeader
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterReader(t *testing.T) {
+	now := time.Now()
+	records := []Record{
+		{ReceivedAt: now, Payload: []byte("frame1")},
+		{ReceivedAt: now.Add(50 * time.Millisecond), Payload: []byte{}},
+		{ReceivedAt: now.Add(100 * time.Millisecond), Payload: []byte("frame3")},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("unable to create writer: %v", err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unable to close writer: %v", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unable to create reader: %v", err)
+	}
+	checkRecords(t, r, records)
+}
+
+func TestOpenFile_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frames.rec")
+	now := time.Now()
+	records := []Record{
+		{ReceivedAt: now, Payload: []byte("frame1")},
+		{ReceivedAt: now.Add(time.Second), Payload: []byte("frame2")},
+	}
+
+	for _, rec := range records {
+		w, err := OpenFile(path)
+		if err != nil {
+			t.Fatalf("unable to open record file: %v", err)
+		}
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("unable to close record file: %v", err)
+		}
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("unable to open record file: %v", err)
+	}
+	defer r.Close()
+	checkRecords(t, r, records)
+}
+
+func TestReader_Errors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("garbage content"))); !errors.Is(err, ErrBadHeader) {
+		t.Errorf("NewReader() on bad header: %v, wants %v", err, ErrBadHeader)
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	_ = w.Write(Record{ReceivedAt: time.Now(), Payload: []byte("truncated frame")})
+	_ = w.Flush()
+	truncated := buf.Bytes()[:buf.Len()-3]
+
+	r, err := NewReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("unable to create reader: %v", err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() on truncated record: %v, wants %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func checkRecords(t *testing.T, r *Reader, expected []Record) {
+	for idx, e := range expected {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatalf("unable to read record %v: %v", idx, err)
+		}
+		if !rec.ReceivedAt.Equal(e.ReceivedAt) {
+			t.Errorf("bad receive time for record %v: %v, wants %v", idx, rec.ReceivedAt, e.ReceivedAt)
+		}
+		if !bytes.Equal(rec.Payload, e.Payload) {
+			t.Errorf("bad payload for record %v: %s, wants %s", idx, rec.Payload, e.Payload)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next() after last record: %v, wants io.EOF", err)
+	}
+}
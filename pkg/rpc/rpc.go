@@ -0,0 +1,191 @@
+// Package rpc exposes road detection as a gRPC service, see road.proto.
+//
+// Service messages are robocar events, so no code is generated from road.proto: service descriptor is declared
+// in this package.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+const (
+	ServiceName = "robocar.road.RoadDetection"
+
+	detectRoadMethod       = "/" + ServiceName + "/DetectRoad"
+	detectRoadStreamMethod = "/" + ServiceName + "/DetectRoadStream"
+)
+
+// Detector detects road on a camera frame.
+//
+// Errors implementing `GRPCStatus() *status.Status` are returned as is to clients, others as internal errors.
+type Detector interface {
+	DetectRoad(frame *events.FrameMessage) (*events.RoadMessage, error)
+}
+
+// RoadDetectionServer is the server API of RoadDetection service
+type RoadDetectionServer interface {
+	DetectRoad(ctx context.Context, frame *events.FrameMessage) (*events.RoadMessage, error)
+	DetectRoadStream(stream grpc.ServerStream) error
+}
+
+// Server implements RoadDetectionServer on top of a Detector
+type Server struct {
+	detector Detector
+}
+
+func NewServer(detector Detector) *Server {
+	return &Server{detector: detector}
+}
+
+// Register adds RoadDetection service to grpc server
+func (s *Server) Register(srv *grpc.Server) {
+	RegisterRoadDetectionServer(srv, s)
+}
+
+// RegisterRoadDetectionServer adds RoadDetection service implemented by impl to grpc server
+func RegisterRoadDetectionServer(srv grpc.ServiceRegistrar, impl RoadDetectionServer) {
+	srv.RegisterService(&serviceDesc, impl)
+}
+
+// DetectRoad returns road detected on frame
+func (s *Server) DetectRoad(ctx context.Context, frame *events.FrameMessage) (*events.RoadMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return s.detect(frame)
+}
+
+// DetectRoadStream replies to each frame received with the detected road, until client closes the stream.
+// Stream is aborted on first detection error.
+func (s *Server) DetectRoadStream(stream grpc.ServerStream) error {
+	for {
+		var frame events.FrameMessage
+		err := stream.RecvMsg(&frame)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		road, err := s.detect(&frame)
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(road); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) detect(frame *events.FrameMessage) (*events.RoadMessage, error) {
+	road, err := s.detector.DetectRoad(frame)
+	if err == nil {
+		return road, nil
+	}
+	if st, ok := status.FromError(err); ok {
+		return nil, st.Err()
+	}
+	zap.S().Errorf("unable to detect road on frame %v: %v", frame.GetId(), err)
+	return nil, status.Errorf(codes.Internal, "unable to detect road: %v", err)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*RoadDetectionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DetectRoad",
+			Handler:    detectRoadHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DetectRoadStream",
+			Handler:       detectRoadStreamHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "road.proto",
+}
+
+func detectRoadHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var frame events.FrameMessage
+	if err := dec(&frame); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoadDetectionServer).DetectRoad(ctx, &frame)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: detectRoadMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoadDetectionServer).DetectRoad(ctx, req.(*events.FrameMessage))
+	}
+	return interceptor(ctx, &frame, info, handler)
+}
+
+func detectRoadStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RoadDetectionServer).DetectRoadStream(stream)
+}
+
+// Client calls a remote RoadDetection service
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// DetectRoad returns road detected by server on frame
+func (c *Client) DetectRoad(ctx context.Context, frame *events.FrameMessage, opts ...grpc.CallOption) (*events.RoadMessage, error) {
+	var road events.RoadMessage
+	if err := c.conn.Invoke(ctx, detectRoadMethod, frame, &road, opts...); err != nil {
+		return nil, err
+	}
+	return &road, nil
+}
+
+// DetectRoadStream opens a bidirectional stream of frames and road messages
+func (c *Client) DetectRoadStream(ctx context.Context, opts ...grpc.CallOption) (*DetectRoadStream, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], detectRoadStreamMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DetectRoadStream{stream: stream}, nil
+}
+
+// DetectRoadStream is the client side of a DetectRoadStream call
+type DetectRoadStream struct {
+	stream grpc.ClientStream
+}
+
+// Send sends a new frame to process
+func (s *DetectRoadStream) Send(frame *events.FrameMessage) error {
+	return s.stream.SendMsg(frame)
+}
+
+// Recv returns the road detected for the next frame sent, io.EOF once server has closed the stream
+func (s *DetectRoadStream) Recv() (*events.RoadMessage, error) {
+	var road events.RoadMessage
+	if err := s.stream.RecvMsg(&road); err != nil {
+		return nil, err
+	}
+	return &road, nil
+}
+
+// CloseSend notifies server that no more frames will be sent
+func (s *DetectRoadStream) CloseSend() error {
+	return s.stream.CloseSend()
+}
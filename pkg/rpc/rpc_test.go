@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeDetector returns a road with a single point whose x is frame size
+type fakeDetector struct{}
+
+func (f *fakeDetector) DetectRoad(frame *events.FrameMessage) (*events.RoadMessage, error) {
+	switch string(frame.GetFrame()) {
+	case "invalid":
+		return nil, status.Error(codes.InvalidArgument, "invalid image")
+	case "error":
+		return nil, errors.New("unexpected error")
+	}
+	return &events.RoadMessage{
+		Contour:  []*events.Point{{X: int32(len(frame.GetFrame())), Y: 0}},
+		FrameRef: frame.GetId(),
+	}, nil
+}
+
+func newTestClient(t *testing.T) *Client {
+	listener := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	NewServer(&fakeDetector{}).Register(srv)
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			t.Errorf("unable to serve: %v", err)
+		}
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unable to dial server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return NewClient(conn)
+}
+
+func TestServer_DetectRoad(t *testing.T) {
+	client := newTestClient(t)
+
+	cases := []struct {
+		name         string
+		frame        string
+		expectedCode codes.Code
+		expectedX    int32
+	}{
+		{"valid frame", "image", codes.OK, 5},
+		{"invalid frame", "invalid", codes.InvalidArgument, 0},
+		{"detector error", "error", codes.Internal, 0},
+	}
+
+	for _, c := range cases {
+		frame := events.FrameMessage{Id: &events.FrameRef{Name: "camera", Id: c.name}, Frame: []byte(c.frame)}
+		road, err := client.DetectRoad(context.Background(), &frame)
+		if status.Code(err) != c.expectedCode {
+			t.Errorf("[%v] bad status code: %v, wants %v", c.name, status.Code(err), c.expectedCode)
+		}
+		if err != nil {
+			continue
+		}
+		if road.GetContour()[0].GetX() != c.expectedX {
+			t.Errorf("[%v] bad road: %v", c.name, road)
+		}
+		if road.GetFrameRef().GetId() != c.name {
+			t.Errorf("[%v] bad frame ref: %v, wants %v", c.name, road.GetFrameRef(), c.name)
+		}
+	}
+}
+
+func TestServer_DetectRoadStream(t *testing.T) {
+	client := newTestClient(t)
+
+	stream, err := client.DetectRoadStream(context.Background())
+	if err != nil {
+		t.Fatalf("unable to open stream: %v", err)
+	}
+
+	frames := []string{"a", "bb", "ccc"}
+	for _, f := range frames {
+		if err := stream.Send(&events.FrameMessage{Id: &events.FrameRef{Id: f}, Frame: []byte(f)}); err != nil {
+			t.Fatalf("unable to send frame %v: %v", f, err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("unable to close stream: %v", err)
+	}
+
+	for idx, f := range frames {
+		road, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("unable to receive road %v: %v", idx, err)
+		}
+		if road.GetFrameRef().GetId() != f || road.GetContour()[0].GetX() != int32(len(f)) {
+			t.Errorf("bad road at position %v: %v, wants frame %v", idx, road, f)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("stream not closed by server: %v", err)
+	}
+}
+
+func TestServer_DetectRoadStreamError(t *testing.T) {
+	client := newTestClient(t)
+
+	stream, err := client.DetectRoadStream(context.Background())
+	if err != nil {
+		t.Fatalf("unable to open stream: %v", err)
+	}
+	if err := stream.Send(&events.FrameMessage{Frame: []byte("invalid")}); err != nil {
+		t.Fatalf("unable to send frame: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad stream error: %v, wants %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	durations := make([]time.Duration, 0, 100)
+	// Unsorted values from 100ms to 1ms
+	for i := 100; i > 0; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	s := Summarize(durations)
+	expected := Summary{
+		Count: 100,
+		Min:   1 * time.Millisecond,
+		Mean:  50500 * time.Microsecond,
+		P50:   50 * time.Millisecond,
+		P95:   95 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+	}
+	if s != expected {
+		t.Errorf("Summarize(): %+v, wants %+v", s, expected)
+	}
+	if durations[0] != 100*time.Millisecond {
+		t.Errorf("Summarize() modified input values")
+	}
+
+	if empty := Summarize(nil); empty != (Summary{}) {
+		t.Errorf("Summarize(nil): %+v, wants empty summary", empty)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	cases := []struct {
+		values   []time.Duration
+		p        float64
+		expected time.Duration
+	}{
+		{[]time.Duration{3, 1, 2}, 50, 2},
+		{[]time.Duration{3, 1, 2}, 99, 3},
+		{[]time.Duration{3, 1, 2}, 0, 1},
+		{[]time.Duration{5}, 95, 5},
+		{nil, 50, 0},
+	}
+	for _, c := range cases {
+		if v := Percentile(c.values, c.p); v != c.expected {
+			t.Errorf("Percentile(%v, %v): %v, wants %v", c.values, c.p, v, c.expected)
+		}
+	}
+}
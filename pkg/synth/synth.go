@@ -0,0 +1,317 @@
+// Package synth renders synthetic track images with the exact ground truth of road pixels
+package synth
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Shape is the road layout from image bottom to horizon
+type Shape string
+
+const (
+	Straight   Shape = "straight"
+	LeftCurve  Shape = "left-curve"
+	RightCurve Shape = "right-curve"
+	// SBend turns left then right, road top is aligned with road bottom
+	SBend Shape = "s-bend"
+)
+
+// Shapes lists all road layouts
+var Shapes = []Shape{Straight, LeftCurve, RightCurve, SBend}
+
+// Spec describes a synthetic track image
+type Spec struct {
+	// Width and Height of image in pixels
+	Width, Height int
+	Shape         Shape
+	// Horizon is the first row of road, rows above show only ground
+	Horizon int
+	// BottomWidth and TopWidth are road widths in pixels on last row and on horizon row
+	BottomWidth, TopWidth float64
+	// Offset is the lateral position of road center on last row, as a fraction of image width from image center
+	// (negative on the left)
+	Offset float64
+	// Curvature is the lateral shift of road center on horizon row for curves, as a fraction of image width
+	Curvature float64
+	// RoadLevel and GroundLevel are gray levels of road and ground before lighting, noise and shadows
+	RoadLevel, GroundLevel uint8
+	// Gradient is the brightness variation from left to right border, 0.2 means -10% on left and +10% on right
+	Gradient float64
+	// Noise is the standard deviation of gaussian noise added to pixels, in gray levels
+	Noise float64
+	// Shadows is the number of elliptic shadows randomly drawn on image
+	Shadows int
+	// ShadowLevel is the brightness factor in shadows, in [0, 1]
+	ShadowLevel float64
+	// Seed initializes random generator of noise and shadows, same seed renders same image
+	Seed int64
+}
+
+// DefaultSpec returns a straight, clean road on a 160x128 image, as seen by car camera
+func DefaultSpec() Spec {
+	return Spec{
+		Width:       160,
+		Height:      128,
+		Shape:       Straight,
+		Horizon:     30,
+		BottomWidth: 110,
+		TopWidth:    40,
+		Curvature:   0.3,
+		RoadLevel:   70,
+		GroundLevel: 220,
+		ShadowLevel: 0.85,
+		Seed:        1,
+	}
+}
+
+// Validate checks spec consistency
+func (s *Spec) Validate() error {
+	if s.Width < 1 || s.Height < 2 {
+		return fmt.Errorf("invalid image size %vx%v", s.Width, s.Height)
+	}
+	if s.Horizon < 0 || s.Horizon >= s.Height-1 {
+		return fmt.Errorf("invalid horizon %v, should be in [0, %v[", s.Horizon, s.Height-1)
+	}
+	if s.BottomWidth <= 0 || s.TopWidth <= 0 {
+		return fmt.Errorf("invalid road widths %v/%v, should be > 0", s.BottomWidth, s.TopWidth)
+	}
+	switch s.Shape {
+	case Straight, LeftCurve, RightCurve, SBend:
+	default:
+		return fmt.Errorf("unknown shape '%v', should be one of %v", s.Shape, Shapes)
+	}
+	if s.Noise < 0 || s.Shadows < 0 || s.ShadowLevel < 0 || s.ShadowLevel > 1 {
+		return fmt.Errorf("invalid noise %v or shadows %v/%v", s.Noise, s.Shadows, s.ShadowLevel)
+	}
+	return nil
+}
+
+// Sample is a rendered track image with its ground truth
+type Sample struct {
+	Spec  Spec
+	Image *image.RGBA
+	// Road is the ground truth road polygon, left border from horizon to bottom then right border from bottom to
+	// horizon, clipped to image
+	Road []image.Point
+	// Mask is the ground truth: 255 for road pixels, 0 for others
+	Mask *image.Gray
+}
+
+// Generate renders a track image described by spec
+func Generate(spec Spec) (*Sample, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, spec.Width, spec.Height)
+	sample := Sample{
+		Spec:  spec,
+		Image: image.NewRGBA(bounds),
+		Mask:  image.NewGray(bounds),
+	}
+	rnd := rand.New(rand.NewSource(spec.Seed))
+	shadows := randomShadows(rnd, spec)
+
+	left := make([]image.Point, 0, spec.Height-spec.Horizon)
+	right := make([]image.Point, 0, spec.Height-spec.Horizon)
+	for y := 0; y < spec.Height; y++ {
+		xMin, xMax := spec.roadBorders(y)
+		if y >= spec.Horizon && xMin <= xMax {
+			left = append(left, image.Point{X: xMin, Y: y})
+			right = append(right, image.Point{X: xMax, Y: y})
+		}
+		for x := 0; x < spec.Width; x++ {
+			level := float64(spec.GroundLevel)
+			if y >= spec.Horizon && x >= xMin && x <= xMax {
+				level = float64(spec.RoadLevel)
+				sample.Mask.SetGray(x, y, color.Gray{Y: 255})
+			}
+			level *= spec.lighting(x)
+			for _, s := range shadows {
+				if s.contains(x, y) {
+					level *= spec.ShadowLevel
+				}
+			}
+			level += rnd.NormFloat64() * spec.Noise
+			v := uint8(math.Max(0, math.Min(255, math.Round(level))))
+			sample.Image.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+
+	sample.Road = make([]image.Point, 0, len(left)+len(right))
+	sample.Road = append(sample.Road, left...)
+	for i := len(right) - 1; i >= 0; i-- {
+		sample.Road = append(sample.Road, right[i])
+	}
+	return &sample, nil
+}
+
+// roadBorders returns the first and last road pixels on row y, clipped to image. xMin > xMax if no road pixel
+func (s *Spec) roadBorders(y int) (int, int) {
+	if y < s.Horizon {
+		return 1, 0
+	}
+	center, halfWidth := s.road(y)
+	// Pixels whose center is in road
+	xMin := int(math.Ceil(center - halfWidth - 0.5))
+	xMax := int(math.Floor(center + halfWidth - 0.5))
+	if xMin < 0 {
+		xMin = 0
+	}
+	if xMax > s.Width-1 {
+		xMax = s.Width - 1
+	}
+	return xMin, xMax
+}
+
+// road returns center and half width of road on row y
+func (s *Spec) road(y int) (float64, float64) {
+	// t is 0 on last row and 1 on horizon row
+	t := float64(s.Height-1-y) / float64(s.Height-1-s.Horizon)
+	halfWidth := (s.BottomWidth + (s.TopWidth-s.BottomWidth)*t) / 2.
+	w := float64(s.Width)
+	center := w/2. + s.Offset*w
+	switch s.Shape {
+	case LeftCurve:
+		center -= s.Curvature * w * t * t
+	case RightCurve:
+		center += s.Curvature * w * t * t
+	case SBend:
+		center -= s.Curvature * w * math.Sin(2*math.Pi*t) / 2.
+	}
+	return center, halfWidth
+}
+
+// lighting returns brightness factor of column x
+func (s *Spec) lighting(x int) float64 {
+	if s.Width < 2 {
+		return 1.
+	}
+	return 1. + s.Gradient*(float64(x)/float64(s.Width-1)-0.5)
+}
+
+type shadow struct {
+	cx, cy, rx, ry float64
+}
+
+func (s shadow) contains(x, y int) bool {
+	dx := (float64(x) + 0.5 - s.cx) / s.rx
+	dy := (float64(y) + 0.5 - s.cy) / s.ry
+	return dx*dx+dy*dy <= 1.
+}
+
+func randomShadows(rnd *rand.Rand, spec Spec) []shadow {
+	shadows := make([]shadow, 0, spec.Shadows)
+	for i := 0; i < spec.Shadows; i++ {
+		shadows = append(shadows, shadow{
+			cx: rnd.Float64() * float64(spec.Width),
+			cy: rnd.Float64() * float64(spec.Height),
+			rx: (0.05 + rnd.Float64()*0.15) * float64(spec.Width),
+			ry: (0.05 + rnd.Float64()*0.15) * float64(spec.Height),
+		})
+	}
+	return shadows
+}
+
+// PolygonMask rasterizes polygon on an image of bounds: pixels whose center is inside polygon are set to 255.
+// Polygon border pixels are also set, like pixels of a contour found on a binary image.
+func PolygonMask(bounds image.Rectangle, polygon []image.Point) *image.Gray {
+	mask := image.NewGray(bounds)
+	n := len(polygon)
+	if n == 0 {
+		return mask
+	}
+
+	// Scan line fill with even-odd rule
+	xs := make([]float64, 0, n)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		yc := float64(y)
+		xs = xs[:0]
+		for i := 0; i < n; i++ {
+			p1, p2 := polygon[i], polygon[(i+1)%n]
+			y1, y2 := float64(p1.Y), float64(p2.Y)
+			if (y1 <= yc && yc < y2) || (y2 <= yc && yc < y1) {
+				xs = append(xs, float64(p1.X)+(yc-y1)*float64(p2.X-p1.X)/(y2-y1))
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := int(math.Ceil(xs[i])); float64(x) <= xs[i+1]; x++ {
+				mask.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		drawLine(mask, polygon[i], polygon[(i+1)%n])
+	}
+	return mask
+}
+
+// drawLine sets pixels of segment p1-p2 with Bresenham algorithm
+func drawLine(img *image.Gray, p1, p2 image.Point) {
+	dx, dy := abs(p2.X-p1.X), -abs(p2.Y-p1.Y)
+	sx, sy := sign(p2.X-p1.X), sign(p2.Y-p1.Y)
+	err := dx + dy
+	x, y := p1.X, p1.Y
+	for {
+		img.SetGray(x, y, color.Gray{Y: 255})
+		if x == p2.X && y == p2.Y {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func sign(v int) int {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// IoU returns the intersection over union of pixels set (>= 128) in a and b, on bounds of a. It returns 1 if both
+// masks are empty.
+func IoU(a, b *image.Gray) float64 {
+	var inter, union int
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inA := a.GrayAt(x, y).Y >= 128
+			inB := b.GrayAt(x, y).Y >= 128
+			if inA && inB {
+				inter++
+			}
+			if inA || inB {
+				union++
+			}
+		}
+	}
+	if union == 0 {
+		return 1.
+	}
+	return float64(inter) / float64(union)
+}
@@ -0,0 +1,188 @@
+package synth
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSpec_Validate(t *testing.T) {
+	cases := []struct {
+		name      string
+		update    func(s *Spec)
+		wantError bool
+	}{
+		{"default", func(_ *Spec) {}, false},
+		{"no width", func(s *Spec) { s.Width = 0 }, true},
+		{"one row", func(s *Spec) { s.Height = 1 }, true},
+		{"negative horizon", func(s *Spec) { s.Horizon = -1 }, true},
+		{"horizon on last row", func(s *Spec) { s.Horizon = s.Height - 1 }, true},
+		{"no road width", func(s *Spec) { s.TopWidth = 0 }, true},
+		{"unknown shape", func(s *Spec) { s.Shape = "loop" }, true},
+		{"negative noise", func(s *Spec) { s.Noise = -1 }, true},
+		{"too bright shadows", func(s *Spec) { s.ShadowLevel = 1.5 }, true},
+	}
+	for _, c := range cases {
+		spec := DefaultSpec()
+		c.update(&spec)
+		err := spec.Validate()
+		if (err != nil) != c.wantError {
+			t.Errorf("[%v] bad validation: %v, wants error: %v", c.name, err, c.wantError)
+		}
+		if _, err := Generate(spec); (err != nil) != c.wantError {
+			t.Errorf("[%v] bad generation: %v, wants error: %v", c.name, err, c.wantError)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	cases := []struct {
+		name   string
+		update func(s *Spec)
+		// centerShift is the expected sign of road center shift from bottom to horizon
+		centerShift int
+	}{
+		{"straight", func(_ *Spec) {}, 0},
+		{"left curve", func(s *Spec) { s.Shape = LeftCurve }, -1},
+		{"right curve", func(s *Spec) { s.Shape = RightCurve }, 1},
+		{"s-bend", func(s *Spec) { s.Shape = SBend }, 0},
+		{"narrow road", func(s *Spec) { s.BottomWidth, s.TopWidth = 50, 20 }, 0},
+		{"road wider than image", func(s *Spec) { s.BottomWidth = 200 }, 0},
+		{"road partly out of image", func(s *Spec) { s.Offset = 0.4 }, 1},
+		{"noisy road in shadows", func(s *Spec) { s.Shape, s.Noise, s.Shadows, s.Gradient = SBend, 10, 4, 0.3 }, 0},
+	}
+
+	for _, c := range cases {
+		spec := DefaultSpec()
+		c.update(&spec)
+		sample, err := Generate(spec)
+		if err != nil {
+			t.Errorf("[%v] unable to generate sample: %v", c.name, err)
+			continue
+		}
+
+		if iou := IoU(PolygonMask(sample.Mask.Bounds(), sample.Road), sample.Mask); iou < 0.99 {
+			t.Errorf("[%v] road polygon doesn't match mask, IoU: %v", c.name, iou)
+		}
+		for _, pt := range sample.Road {
+			if !pt.In(sample.Mask.Bounds()) || pt.Y < spec.Horizon {
+				t.Errorf("[%v] road point %v outside of image or above horizon", c.name, pt)
+			}
+		}
+
+		bottom, top := rowCenter(sample.Mask, spec.Height-1), rowCenter(sample.Mask, spec.Horizon)
+		shift := 0
+		switch {
+		case top-bottom > 5:
+			shift = 1
+		case top-bottom < -5:
+			shift = -1
+		}
+		if shift != c.centerShift {
+			t.Errorf("[%v] bad road center shift from %v to %v, wants direction %v", c.name, bottom, top, c.centerShift)
+		}
+	}
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Noise, spec.Shadows = 10, 3
+
+	s1, err := Generate(spec)
+	if err != nil {
+		t.Fatalf("unable to generate sample: %v", err)
+	}
+	s2, _ := Generate(spec)
+	if string(s1.Image.Pix) != string(s2.Image.Pix) {
+		t.Errorf("same seed renders different images")
+	}
+
+	spec.Seed = 2
+	s3, _ := Generate(spec)
+	if string(s1.Image.Pix) == string(s3.Image.Pix) {
+		t.Errorf("different seeds render same image")
+	}
+	if string(s1.Mask.Pix) != string(s3.Mask.Pix) {
+		t.Errorf("noise and shadows change ground truth")
+	}
+}
+
+func TestGenerate_Lighting(t *testing.T) {
+	spec := DefaultSpec()
+	spec.Gradient = 0.2
+	sample, err := Generate(spec)
+	if err != nil {
+		t.Fatalf("unable to generate sample: %v", err)
+	}
+	left, right := sample.Image.RGBAAt(0, 0).R, sample.Image.RGBAAt(spec.Width-1, 0).R
+	if left != 198 || right != 242 {
+		t.Errorf("bad ground levels with gradient: %v/%v, wants 198/242", left, right)
+	}
+	if road := sample.Image.RGBAAt(spec.Width/2, spec.Height-1).R; road < 69 || road > 71 {
+		t.Errorf("bad road level: %v", road)
+	}
+}
+
+func TestIoU(t *testing.T) {
+	bounds := image.Rect(0, 0, 10, 10)
+	cases := []struct {
+		name     string
+		a, b     *image.Gray
+		expected float64
+	}{
+		{"empty masks", image.NewGray(bounds), image.NewGray(bounds), 1.},
+		{"one empty mask", rectMask(bounds, image.Rect(0, 0, 5, 5)), image.NewGray(bounds), 0.},
+		{"same masks", rectMask(bounds, image.Rect(0, 0, 5, 5)), rectMask(bounds, image.Rect(0, 0, 5, 5)), 1.},
+		{"half overlap", rectMask(bounds, image.Rect(0, 0, 4, 4)), rectMask(bounds, image.Rect(0, 2, 4, 6)), 1. / 3.},
+		{"disjoint", rectMask(bounds, image.Rect(0, 0, 2, 2)), rectMask(bounds, image.Rect(5, 5, 7, 7)), 0.},
+	}
+	for _, c := range cases {
+		if iou := IoU(c.a, c.b); iou != c.expected {
+			t.Errorf("[%v] bad IoU: %v, wants %v", c.name, iou, c.expected)
+		}
+	}
+}
+
+func TestPolygonMask(t *testing.T) {
+	bounds := image.Rect(0, 0, 10, 10)
+	cases := []struct {
+		name     string
+		polygon  []image.Point
+		expected *image.Gray
+	}{
+		{"no polygon", nil, image.NewGray(bounds)},
+		{"rectangle", []image.Point{{2, 2}, {2, 5}, {6, 5}, {6, 2}}, rectMask(bounds, image.Rect(2, 2, 7, 6))},
+		{"single point", []image.Point{{3, 4}}, rectMask(bounds, image.Rect(3, 4, 4, 5))},
+		{"clipped", []image.Point{{-5, -5}, {-5, 20}, {4, 20}, {4, -5}}, rectMask(bounds, image.Rect(0, 0, 5, 10))},
+	}
+	for _, c := range cases {
+		if mask := PolygonMask(bounds, c.polygon); string(mask.Pix) != string(c.expected.Pix) {
+			t.Errorf("[%v] bad mask: %v, wants %v", c.name, mask.Pix, c.expected.Pix)
+		}
+	}
+}
+
+func rectMask(bounds, r image.Rectangle) *image.Gray {
+	mask := image.NewGray(bounds)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			mask.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	return mask
+}
+
+// rowCenter returns the mean x of mask pixels on row y
+func rowCenter(mask *image.Gray, y int) int {
+	var sum, count int
+	for x := mask.Bounds().Min.X; x < mask.Bounds().Max.X; x++ {
+		if mask.GrayAt(x, y).Y > 0 {
+			sum += x
+			count++
+		}
+	}
+	if count == 0 {
+		return -1
+	}
+	return sum / count
+}
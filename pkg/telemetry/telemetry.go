@@ -0,0 +1,102 @@
+// Package telemetry configures OpenTelemetry spans export
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.uber.org/multierr"
+	"os"
+)
+
+const (
+	// ExporterNone disables tracing
+	ExporterNone = "none"
+	// ExporterStdout writes spans as json on stdout
+	ExporterStdout = "stdout"
+	// ExporterFile writes spans as json to Config.File
+	ExporterFile = "file"
+	// ExporterOTLP sends spans to an OTLP grpc collector at Config.Endpoint
+	ExporterOTLP = "otlp"
+)
+
+// Config defines how spans are exported
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterStdout, ExporterFile or ExporterOTLP
+	Exporter string `yaml:"exporter"`
+	// File is the path of the file where spans are appended with ExporterFile
+	File string `yaml:"file"`
+	// Endpoint is the host:port of the collector with ExporterOTLP
+	Endpoint string `yaml:"endpoint"`
+	// Insecure disables TLS with ExporterOTLP
+	Insecure bool `yaml:"insecure"`
+	// ServiceName identifies spans source
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the fraction of traces to export, between 0 and 1
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// ShutdownFunc flushes pending spans and releases exporter
+type ShutdownFunc func(ctx context.Context) error
+
+// Setup registers a global tracer provider that exports spans as described by cfg
+func Setup(ctx context.Context, cfg Config) (ShutdownFunc, error) {
+	if cfg.SampleRatio < 0. || cfg.SampleRatio > 1. {
+		return nil, fmt.Errorf("invalid sample ratio %v, should be between 0 and 1", cfg.SampleRatio)
+	}
+
+	var exporter sdktrace.SpanExporter
+	var closeOutput func() error
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(_ context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("no file to export spans")
+		}
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open spans file: %w", err)
+		}
+		closeOutput = f.Close
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid exporter '%v', should be one of %v, %v, %v or %v", cfg.Exporter, ExporterNone, ExporterStdout, ExporterFile, ExporterOTLP)
+	}
+	if err != nil {
+		if closeOutput != nil {
+			_ = closeOutput()
+		}
+		return nil, fmt.Errorf("unable to create %v exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			err = multierr.Append(err, closeOutput())
+		}
+		return err
+	}, nil
+}
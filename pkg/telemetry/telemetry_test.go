@@ -0,0 +1,121 @@
+package telemetry
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetup_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: file, ServiceName: "test", SampleRatio: 1.})
+	if err != nil {
+		t.Fatalf("unable to setup tracing: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "road.frame")
+	span.SetAttributes(attribute.String("frame.id", "frame-1"))
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("unable to shutdown tracing: %v", err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read spans file: %v", err)
+	}
+	if !strings.Contains(string(content), `"Name":"road.frame"`) || !strings.Contains(string(content), "frame-1") {
+		t.Errorf("span not exported to file: %s", content)
+	}
+}
+
+// fakeCollector is a local stand-in for an OTLP collector
+type fakeCollector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	mu    sync.Mutex
+	spans []string
+}
+
+func (f *fakeCollector) Export(_ context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				f.spans = append(f.spans, s.GetName())
+			}
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+func (f *fakeCollector) Spans() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.spans...)
+}
+
+func TestSetup_OTLP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	collector := fakeCollector{}
+	srv := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(srv, &collector)
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	shutdown, err := Setup(context.Background(), Config{
+		Exporter:    ExporterOTLP,
+		Endpoint:    listener.Addr().String(),
+		Insecure:    true,
+		ServiceName: "test",
+		SampleRatio: 1.,
+	})
+	if err != nil {
+		t.Fatalf("unable to setup tracing: %v", err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "road.frame")
+	_, child := otel.Tracer("test").Start(ctx, "threshold")
+	child.End()
+	parent.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(shutdownCtx); err != nil {
+		t.Errorf("unable to shutdown tracing: %v", err)
+	}
+
+	spans := collector.Spans()
+	if len(spans) != 2 || spans[0] != "threshold" || spans[1] != "road.frame" {
+		t.Errorf("bad spans received by collector: %v", spans)
+	}
+}
+
+func TestSetup_Invalid(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown exporter", Config{Exporter: "zipkin", SampleRatio: 1.}},
+		{"file without path", Config{Exporter: ExporterFile, SampleRatio: 1.}},
+		{"bad sample ratio", Config{Exporter: ExporterStdout, SampleRatio: 2.}},
+	}
+	for _, c := range cases {
+		if _, err := Setup(context.Background(), c.cfg); err == nil {
+			t.Errorf("[%v] Setup() should fail", c.name)
+		}
+	}
+}
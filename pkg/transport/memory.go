@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultQueueSize is the number of messages buffered by subscription before Publish blocks
+	DefaultQueueSize = 64
+	// DefaultPublishTimeout is the max duration Publish waits for room in a full subscription queue
+	DefaultPublishTimeout = time.Second
+)
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+type subscription struct {
+	filter  string
+	handler Handler
+	queue   chan message
+	// stopped is closed on unsubscribe, queue is never closed as publishers may send without lock
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+func (s *subscription) run() {
+	defer close(s.done)
+	for {
+		select {
+		case msg := <-s.queue:
+			s.handler(msg.topic, msg.payload)
+		case <-s.stopped:
+			// Deliver pending messages
+			for {
+				select {
+				case msg := <-s.queue:
+					s.handler(msg.topic, msg.payload)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send queues msg, it returns false if msg is dropped because subscription is stopped or its queue stays full
+// during timeout
+func (s *subscription) send(msg message, timeout time.Duration) bool {
+	select {
+	case <-s.stopped:
+		return false
+	case s.queue <- msg:
+		return true
+	default:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.stopped:
+		return false
+	case s.queue <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Memory is an in-process Transport. Each subscription delivers its messages in publication order from its own
+// goroutine, as a mqtt client does.
+//
+// Publish blocks while a matching subscription queue is full, at most for the publish timeout, then the message is
+// dropped for this subscription (see Dropped). A handler that publishes on its own topic doesn't deadlock, but may
+// lose messages.
+type Memory struct {
+	mu             sync.RWMutex
+	subscriptions  map[string]*subscription
+	queueSize      int
+	publishTimeout time.Duration
+	closed         bool
+	dropped        int64
+}
+
+func NewMemory() *Memory {
+	return NewMemoryWithQueueSize(DefaultQueueSize)
+}
+
+// NewMemoryWithQueueSize creates a Memory transport where Publish blocks when a subscriber has queueSize pending messages
+func NewMemoryWithQueueSize(queueSize int) *Memory {
+	return &Memory{
+		subscriptions:  make(map[string]*subscription),
+		queueSize:      queueSize,
+		publishTimeout: DefaultPublishTimeout,
+	}
+}
+
+func (m *Memory) Subscribe(topic string, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if previous, ok := m.subscriptions[topic]; ok {
+		// Replace handler, as mqtt does
+		m.stop(previous)
+	}
+	s := &subscription{
+		filter:  topic,
+		handler: handler,
+		queue:   make(chan message, m.queueSize),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	m.subscriptions[topic] = s
+	go s.run()
+	return nil
+}
+
+func (m *Memory) Unsubscribe(topics ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, topic := range topics {
+		if s, ok := m.subscriptions[topic]; ok {
+			m.stop(s)
+		}
+	}
+	return nil
+}
+
+// stop removes subscription, pending messages are still delivered. Lock should be held.
+func (m *Memory) stop(s *subscription) {
+	delete(m.subscriptions, s.filter)
+	close(s.stopped)
+}
+
+// Publish copies payload and queues it for all matching subscriptions
+func (m *Memory) Publish(topic string, payload []byte) error {
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return ErrClosed
+	}
+	matching := make([]*subscription, 0, len(m.subscriptions))
+	for filter, s := range m.subscriptions {
+		if Match(filter, topic) {
+			matching = append(matching, s)
+		}
+	}
+	// Lock is released before sending: a full queue must not block Subscribe, Unsubscribe, Close or handlers
+	m.mu.RUnlock()
+
+	for _, s := range matching {
+		p := make([]byte, len(payload))
+		copy(p, payload)
+		if !s.send(message{topic: topic, payload: p}, m.publishTimeout) {
+			atomic.AddInt64(&m.dropped, 1)
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of messages not delivered to a subscription because its queue stayed full or it was
+// stopped during Publish
+func (m *Memory) Dropped() int64 {
+	return atomic.LoadInt64(&m.dropped)
+}
+
+// Close unsubscribes all topics and waits for pending messages delivery
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	subscriptions := make([]*subscription, 0, len(m.subscriptions))
+	for _, s := range m.subscriptions {
+		subscriptions = append(subscriptions, s)
+		m.stop(s)
+	}
+	m.mu.Unlock()
+
+	for _, s := range subscriptions {
+		<-s.done
+	}
+	return nil
+}
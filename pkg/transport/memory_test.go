@@ -0,0 +1,176 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (c *collector) handle(topic string, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, topic+":"+string(payload))
+}
+
+func (c *collector) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.messages...)
+}
+
+func TestMemory_PublishSubscribe(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+
+	var camera, all collector
+	if err := m.Subscribe("robocar/camera", camera.handle); err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+	if err := m.Subscribe("robocar/#", all.handle); err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	payload := []byte("frame1")
+	_ = m.Publish("robocar/camera", payload)
+	// Publisher can reuse its buffer
+	copy(payload, "xxxxxx")
+	_ = m.Publish("robocar/road", []byte("road1"))
+	_ = m.Publish("robocar/camera", []byte("frame2"))
+	_ = m.Publish("other/camera", []byte("ignored"))
+
+	waitMessages(t, &camera, []string{"robocar/camera:frame1", "robocar/camera:frame2"})
+	waitMessages(t, &all, []string{"robocar/camera:frame1", "robocar/road:road1", "robocar/camera:frame2"})
+
+	if err := m.Unsubscribe("robocar/camera"); err != nil {
+		t.Errorf("unable to unsubscribe: %v", err)
+	}
+	_ = m.Publish("robocar/camera", []byte("frame3"))
+	waitMessages(t, &all, []string{"robocar/camera:frame1", "robocar/road:road1", "robocar/camera:frame2", "robocar/camera:frame3"})
+	if msgs := camera.get(); len(msgs) != 2 {
+		t.Errorf("messages received after unsubscribe: %v", msgs)
+	}
+}
+
+func TestMemory_Close(t *testing.T) {
+	m := NewMemoryWithQueueSize(10)
+
+	var c collector
+	_ = m.Subscribe("topic", func(topic string, payload []byte) {
+		time.Sleep(time.Millisecond)
+		c.handle(topic, payload)
+	})
+	expected := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		_ = m.Publish("topic", []byte(fmt.Sprint(i)))
+		expected = append(expected, fmt.Sprintf("topic:%d", i))
+	}
+
+	// Pending messages are delivered before Close returns
+	if err := m.Close(); err != nil {
+		t.Errorf("unable to close transport: %v", err)
+	}
+	if msgs := c.get(); fmt.Sprint(msgs) != fmt.Sprint(expected) {
+		t.Errorf("bad messages after close: %v, wants %v", msgs, expected)
+	}
+
+	if err := m.Publish("topic", nil); err != ErrClosed {
+		t.Errorf("Publish() after Close(): %v, wants %v", err, ErrClosed)
+	}
+	if err := m.Subscribe("topic", c.handle); err != ErrClosed {
+		t.Errorf("Subscribe() after Close(): %v, wants %v", err, ErrClosed)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("second Close(): %v", err)
+	}
+}
+
+func TestMemory_PublishFromHandler(t *testing.T) {
+	m := NewMemoryWithQueueSize(1)
+	m.publishTimeout = 50 * time.Millisecond
+	defer m.Close()
+
+	var c collector
+	release := make(chan struct{})
+	err := m.Subscribe("topic", func(topic string, payload []byte) {
+		c.handle(topic, payload)
+		if string(payload) != "start" {
+			return
+		}
+		// Fill own queue then publish again: the last message can't be queued while handler runs
+		_ = m.Publish("topic", []byte("echo1"))
+		_ = m.Publish("topic", []byte("echo2"))
+		close(release)
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+	_ = m.Publish("topic", []byte("start"))
+
+	select {
+	case <-release:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler deadlocked publishing on its own topic")
+	}
+	waitMessages(t, &c, []string{"topic:start", "topic:echo1"})
+	if dropped := m.Dropped(); dropped != 1 {
+		t.Errorf("bad dropped messages: %v, wants 1", dropped)
+	}
+}
+
+func TestMemory_SubscribeWhilePublishBlocked(t *testing.T) {
+	m := NewMemoryWithQueueSize(1)
+	m.publishTimeout = time.Minute
+
+	block := make(chan struct{})
+	var c collector
+	_ = m.Subscribe("topic", func(topic string, payload []byte) {
+		<-block
+		c.handle(topic, payload)
+	})
+	// First message is handled, second one fills queue and third one blocks publisher
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 3; i++ {
+			_ = m.Publish("topic", []byte(fmt.Sprint(i)))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	subscribed := make(chan error)
+	go func() { subscribed <- m.Subscribe("other", c.handle) }()
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			t.Errorf("unable to subscribe: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Subscribe() blocked by a full subscription queue")
+	}
+
+	close(block)
+	<-published
+	if err := m.Close(); err != nil {
+		t.Errorf("unable to close transport: %v", err)
+	}
+	if msgs := c.get(); fmt.Sprint(msgs) != "[topic:0 topic:1 topic:2]" {
+		t.Errorf("bad messages: %v", msgs)
+	}
+}
+
+func waitMessages(t *testing.T, c *collector, expected []string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for fmt.Sprint(c.get()) != fmt.Sprint(expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("bad messages: %v, wants %v", c.get(), expected)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
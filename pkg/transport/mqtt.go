@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-base/service"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the max duration to wait for broker acknowledgment when qos > 0
+const DefaultTimeout = 1 * time.Second
+
+// MQTT is a Transport backed by a mqtt client
+type MQTT struct {
+	client  mqtt.Client
+	qos     byte
+	retain  bool
+	timeout time.Duration
+
+	mu     sync.Mutex
+	topics map[string]struct{}
+}
+
+// NewMQTT builds a transport that publishes messages with qos and retain flag
+func NewMQTT(client mqtt.Client, qos byte, retain bool) *MQTT {
+	return &MQTT{
+		client:  client,
+		qos:     qos,
+		retain:  retain,
+		timeout: DefaultTimeout,
+		topics:  make(map[string]struct{}),
+	}
+}
+
+func (m *MQTT) Subscribe(topic string, handler Handler) error {
+	err := service.RegisterCallback(m.client, topic, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topics[topic] = struct{}{}
+	return nil
+}
+
+func (m *MQTT) Unsubscribe(topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	for _, topic := range topics {
+		delete(m.topics, topic)
+	}
+	m.mu.Unlock()
+
+	token := m.client.Unsubscribe(topics...)
+	if !token.WaitTimeout(m.timeout) {
+		return fmt.Errorf("unable to unsubscribe from topics %v: no acknowledgment from broker after %v", topics, m.timeout)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("unable to unsubscribe from topics %v: %w", topics, token.Error())
+	}
+	return nil
+}
+
+// Publish sends payload to broker. When qos > 0, it waits for broker acknowledgment.
+func (m *MQTT) Publish(topic string, payload []byte) error {
+	token := m.client.Publish(topic, m.qos, m.retain, payload)
+	if m.qos == 0 {
+		// No acknowledgment expected from broker
+		return nil
+	}
+	if !token.WaitTimeout(m.timeout) {
+		return fmt.Errorf("no acknowledgment from broker after %v", m.timeout)
+	}
+	return token.Error()
+}
+
+// Close unsubscribes remaining topics and disconnects client
+func (m *MQTT) Close() error {
+	m.mu.Lock()
+	topics := make([]string, 0, len(m.topics))
+	for topic := range m.topics {
+		topics = append(topics, topic)
+	}
+	m.mu.Unlock()
+
+	err := m.Unsubscribe(topics...)
+	if err != nil {
+		zap.S().Errorf("unable to unsubscribe before disconnect: %v", err)
+	}
+	m.client.Disconnect(50)
+	return err
+}
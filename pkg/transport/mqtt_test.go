@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"testing"
+	"time"
+)
+
+type fakeToken struct {
+	completed bool
+	err       error
+}
+
+func (f *fakeToken) Wait() bool                       { return f.completed }
+func (f *fakeToken) WaitTimeout(_ time.Duration) bool { return f.completed }
+func (f *fakeToken) Done() <-chan struct{} {
+	c := make(chan struct{})
+	if f.completed {
+		close(c)
+	}
+	return c
+}
+func (f *fakeToken) Error() error { return f.err }
+
+type fakeClient struct {
+	mqtt.Client
+	token          *fakeToken
+	qos            byte
+	retain         bool
+	publishedTopic string
+}
+
+func (f *fakeClient) Publish(topic string, qos byte, retained bool, _ interface{}) mqtt.Token {
+	f.publishedTopic = topic
+	f.qos = qos
+	f.retain = retained
+	return f.token
+}
+
+func TestMQTT_Publish(t *testing.T) {
+	errBroker := errors.New("broker error")
+	cases := []struct {
+		name    string
+		qos     byte
+		retain  bool
+		token   fakeToken
+		wantErr bool
+	}{
+		{"qos 0 without ack", 0, false, fakeToken{completed: false}, false},
+		{"qos 0 retained", 0, true, fakeToken{completed: true}, false},
+		{"qos 1 acked", 1, false, fakeToken{completed: true}, false},
+		{"qos 1 timeout", 1, false, fakeToken{completed: false}, true},
+		{"qos 2 error", 2, true, fakeToken{completed: true, err: errBroker}, true},
+	}
+
+	for _, c := range cases {
+		token := c.token
+		client := fakeClient{token: &token}
+		tr := NewMQTT(&client, c.qos, c.retain)
+		err := tr.Publish("topic/road", []byte("payload"))
+		if (err != nil) != c.wantErr {
+			t.Errorf("[%v] Publish() error: %v, wants error: %v", c.name, err, c.wantErr)
+		}
+		if client.publishedTopic != "topic/road" || client.qos != c.qos || client.retain != c.retain {
+			t.Errorf("[%v] bad publish parameters: topic=%v qos=%v retain=%v, wants topic=topic/road qos=%v retain=%v",
+				c.name, client.publishedTopic, client.qos, client.retain, c.qos, c.retain)
+		}
+	}
+}
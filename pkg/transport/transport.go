@@ -0,0 +1,48 @@
+// Package transport abstracts the message bus used to exchange events between parts
+package transport
+
+import "errors"
+
+var ErrClosed = errors.New("transport closed")
+
+// Handler processes a message received on topic
+type Handler func(topic string, payload []byte)
+
+// Transport publishes messages and delivers messages of subscribed topics to handlers
+type Transport interface {
+	// Subscribe registers handler to call for each message received on topic.
+	// Topic can contain mqtt wildcards ('+' for one level, '#' for all remaining levels).
+	Subscribe(topic string, handler Handler) error
+	// Unsubscribe stops messages delivery for topics
+	Unsubscribe(topics ...string) error
+	Publish(topic string, payload []byte) error
+	// Close unsubscribes all topics and releases resources
+	Close() error
+}
+
+// Match returns true if topic matches filter, filter can contain mqtt wildcards
+func Match(filter, topic string) bool {
+	for {
+		filterLevel, filterRest, filterMore := cut(filter)
+		topicLevel, topicRest, topicMore := cut(topic)
+		switch {
+		case filterLevel == "#":
+			return true
+		case filterLevel != "+" && filterLevel != topicLevel:
+			return false
+		case !filterMore || !topicMore:
+			// '#' also matches parent level (ex: 'a/#' matches 'a')
+			return filterMore == topicMore || (filterMore && filterRest == "#")
+		}
+		filter, topic = filterRest, topicRest
+	}
+}
+
+func cut(topic string) (level, rest string, more bool) {
+	for i := 0; i < len(topic); i++ {
+		if topic[i] == '/' {
+			return topic[:i], topic[i+1:], true
+		}
+	}
+	return topic, "", false
+}
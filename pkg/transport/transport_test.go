@@ -0,0 +1,27 @@
+package transport
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		expected      bool
+	}{
+		{"robocar/camera", "robocar/camera", true},
+		{"robocar/camera", "robocar/road", false},
+		{"robocar/camera", "robocar", false},
+		{"robocar", "robocar/camera", false},
+		{"robocar/+", "robocar/camera", true},
+		{"robocar/+", "robocar/camera/raw", false},
+		{"+/camera", "robocar/camera", true},
+		{"robocar/#", "robocar/camera/raw", true},
+		{"robocar/#", "robocar", true},
+		{"robocar/#", "other/camera", false},
+		{"#", "robocar/camera", true},
+	}
+	for _, c := range cases {
+		if m := Match(c.filter, c.topic); m != c.expected {
+			t.Errorf("Match(%v, %v): %v, wants %v", c.filter, c.topic, m, c.expected)
+		}
+	}
+}
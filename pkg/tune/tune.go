@@ -0,0 +1,299 @@
+// Package tune searches parameter values that maximize a detection score
+package tune
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxValues is the max number of values of a parameter range
+const MaxValues = 100000
+
+// Param is a tunable parameter and the range of values to explore, from Min to Max by Step
+type Param struct {
+	Name           string
+	Min, Max, Step float64
+}
+
+// ParseParam parses a parameter range written as 'name=min:max:step'
+func ParseParam(s string) (Param, error) {
+	name, rng, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return Param{}, fmt.Errorf("invalid parameter '%v', should be name=min:max:step", s)
+	}
+	fields := strings.Split(rng, ":")
+	if len(fields) != 3 {
+		return Param{}, fmt.Errorf("invalid range '%v' of parameter %v, should be min:max:step", rng, name)
+	}
+	values := make([]float64, 0, 3)
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return Param{}, fmt.Errorf("invalid range '%v' of parameter %v: %w", rng, name, err)
+		}
+		values = append(values, v)
+	}
+	p := Param{Name: name, Min: values[0], Max: values[1], Step: values[2]}
+	return p, p.Validate()
+}
+
+// Validate checks range consistency
+func (p Param) Validate() error {
+	for _, v := range []float64{p.Min, p.Max, p.Step} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("invalid range of parameter %v: min %v, max %v and step %v should be finite", p.Name, p.Min, p.Max, p.Step)
+		}
+	}
+	if p.Min > p.Max || p.Step <= 0 {
+		return fmt.Errorf("invalid range of parameter %v: min %v should be <= max %v and step %v > 0", p.Name, p.Min, p.Max, p.Step)
+	}
+	if p.count() > MaxValues {
+		return fmt.Errorf("invalid range of parameter %v: more than %v values, increase step %v", p.Name, MaxValues, p.Step)
+	}
+	return nil
+}
+
+// count returns the number of values of range, 0 if range is invalid. It is capped to MaxValues+1, so that oversized
+// ranges are detected without enumerating them.
+func (p Param) count() int {
+	if math.IsNaN(p.Min) || math.IsNaN(p.Max) || math.IsNaN(p.Step) || p.Min > p.Max || p.Step <= 0 {
+		return 0
+	}
+	// Tolerance absorbs float division errors, values being rounded anyway
+	n := math.Floor((p.Max-p.Min)/p.Step+1e-9) + 1
+	if n > MaxValues || math.IsInf(n, 0) || math.IsNaN(n) {
+		return MaxValues + 1
+	}
+	return int(n)
+}
+
+func (p Param) String() string {
+	return fmt.Sprintf("%v=%v:%v:%v", p.Name, p.Min, p.Max, p.Step)
+}
+
+// Values returns all values of range, at most MaxValues
+func (p Param) Values() []float64 {
+	n := p.count()
+	if n > MaxValues {
+		n = MaxValues
+	}
+	values := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		// Rounded to get exact integers and clean decimals despite float steps
+		v := math.Round((p.Min+float64(i)*p.Step)*1e9) / 1e9
+		if v > p.Max {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// Point gives a value to each tuned parameter
+type Point map[string]float64
+
+func (p Point) String() string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, fmt.Sprintf("%v=%v", name, p[name]))
+	}
+	return strings.Join(fields, " ")
+}
+
+func (p Point) with(name string, v float64) Point {
+	point := make(Point, len(p))
+	for n, value := range p {
+		point[n] = value
+	}
+	point[name] = v
+	return point
+}
+
+// Score is the measured quality of a configuration
+type Score struct {
+	IoU     float64       `json:"iou"`
+	Latency time.Duration `json:"latency"`
+}
+
+// Objective measures configuration of point
+type Objective func(point Point) (Score, error)
+
+// Trial is a measured point
+type Trial struct {
+	Point Point `json:"params"`
+	Score
+	// Value ranks trials, higher is better
+	Value float64 `json:"value"`
+}
+
+// Tuner searches best point of declared parameters. Evaluated points are cached, each one is measured only once
+// whatever search methods are used.
+type Tuner struct {
+	params        []Param
+	objective     Objective
+	latencyWeight float64
+	maxTrials     int
+	trials        map[string]*Trial
+}
+
+// DefaultLatencyWeight makes 1ms of latency as costly as 0.001 of IoU
+const DefaultLatencyWeight = 0.001
+
+// DefaultMaxTrials is the max number of points of a grid search
+const DefaultMaxTrials = 1000
+
+type Option func(t *Tuner)
+
+// WithLatencyWeight sets the IoU loss equivalent to 1ms of latency
+func WithLatencyWeight(weight float64) Option {
+	return func(t *Tuner) {
+		t.latencyWeight = weight
+	}
+}
+
+// WithMaxTrials limits the size of grid search
+func WithMaxTrials(maxTrials int) Option {
+	return func(t *Tuner) {
+		t.maxTrials = maxTrials
+	}
+}
+
+func NewTuner(params []Param, objective Objective, options ...Option) *Tuner {
+	t := Tuner{
+		params:        params,
+		objective:     objective,
+		latencyWeight: DefaultLatencyWeight,
+		maxTrials:     DefaultMaxTrials,
+		trials:        make(map[string]*Trial),
+	}
+	for _, o := range options {
+		o(&t)
+	}
+	return &t
+}
+
+// Measure returns trial of point, objective is called only if point has not been measured yet
+func (t *Tuner) Measure(point Point) (*Trial, error) {
+	key := point.String()
+	if trial, ok := t.trials[key]; ok {
+		return trial, nil
+	}
+	score, err := t.objective(point)
+	if err != nil {
+		return nil, fmt.Errorf("unable to measure %v: %w", key, err)
+	}
+	trial := Trial{
+		Point: point,
+		Score: score,
+		Value: score.IoU - t.latencyWeight*float64(score.Latency)/float64(time.Millisecond),
+	}
+	t.trials[key] = &trial
+	return &trial, nil
+}
+
+// GridSize returns the number of points of grid search, math.MaxInt if it overflows. Values are counted, not
+// enumerated.
+func (t *Tuner) GridSize() int {
+	size := 1
+	for _, p := range t.params {
+		n := p.count()
+		if n > 0 && size > math.MaxInt/n {
+			return math.MaxInt
+		}
+		size *= n
+	}
+	return size
+}
+
+// Grid measures all combinations of parameters values
+func (t *Tuner) Grid() error {
+	if size := t.GridSize(); size > t.maxTrials {
+		return fmt.Errorf("grid of %v points exceeds max trials %v, reduce ranges or use random search", size, t.maxTrials)
+	}
+	points := []Point{{}}
+	for _, p := range t.params {
+		next := make([]Point, 0, len(points)*len(p.Values()))
+		for _, point := range points {
+			for _, v := range p.Values() {
+				next = append(next, point.with(p.Name, v))
+			}
+		}
+		points = next
+	}
+	for _, point := range points {
+		if _, err := t.Measure(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Random measures n points whose values are randomly picked in parameters ranges
+func (t *Tuner) Random(n int, rnd *rand.Rand) error {
+	for i := 0; i < n; i++ {
+		point := make(Point, len(t.params))
+		for _, p := range t.params {
+			values := p.Values()
+			point[p.Name] = values[rnd.Intn(len(values))]
+		}
+		if _, err := t.Measure(point); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CoordinateDescent improves start point one parameter at a time: all values of a parameter are measured, others
+// being fixed, and the best one is kept. It stops after maxRounds rounds over all parameters or when a round doesn't
+// improve value. Start point should give a value to each parameter.
+func (t *Tuner) CoordinateDescent(start Point, maxRounds int) (*Trial, error) {
+	best, err := t.Measure(start)
+	if err != nil {
+		return nil, err
+	}
+	for round := 0; round < maxRounds; round++ {
+		improved := false
+		for _, p := range t.params {
+			for _, v := range p.Values() {
+				trial, err := t.Measure(best.Point.with(p.Name, v))
+				if err != nil {
+					return nil, err
+				}
+				if trial.Value > best.Value {
+					best = trial
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+	return best, nil
+}
+
+// Ranked returns measured trials, best first
+func (t *Tuner) Ranked() []Trial {
+	trials := make([]Trial, 0, len(t.trials))
+	for _, trial := range t.trials {
+		trials = append(trials, *trial)
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		if trials[i].Value != trials[j].Value {
+			return trials[i].Value > trials[j].Value
+		}
+		// Deterministic order of ties
+		return trials[i].Point.String() < trials[j].Point.String()
+	})
+	return trials
+}
@@ -0,0 +1,149 @@
+package tune
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseParam(t *testing.T) {
+	cases := []struct {
+		name      string
+		param     string
+		expected  []float64
+		wantError bool
+	}{
+		{name: "integers", param: "threshold=150:200:25", expected: []float64{150, 175, 200}},
+		{name: "decimals", param: "approx_poly_epsilon_factor=0.01:0.03:0.01", expected: []float64{0.01, 0.02, 0.03}},
+		{name: "max out of steps", param: "horizon=0:25:10", expected: []float64{0, 10, 20}},
+		{name: "single value", param: "kernel_size=4:4:1", expected: []float64{4}},
+		{name: "no name", param: "=1:2:1", wantError: true},
+		{name: "no range", param: "horizon", wantError: true},
+		{name: "missing step", param: "horizon=1:2", wantError: true},
+		{name: "not a number", param: "horizon=1:2:x", wantError: true},
+		{name: "min > max", param: "horizon=3:2:1", wantError: true},
+		{name: "null step", param: "horizon=1:2:0", wantError: true},
+		{name: "infinite max", param: "horizon=0:inf:1", wantError: true},
+		{name: "infinite min", param: "horizon=-Inf:0:1", wantError: true},
+		{name: "NaN step", param: "horizon=0:10:NaN", wantError: true},
+		{name: "NaN max", param: "horizon=0:nan:1", wantError: true},
+		{name: "too many values", param: "horizon=0:100:1e-9", wantError: true},
+		{name: "max values", param: "horizon=1:100000:1", expected: values(1, 100000)},
+	}
+	for _, c := range cases {
+		p, err := ParseParam(c.param)
+		if (err != nil) != c.wantError {
+			t.Errorf("[%v] bad error: %v", c.name, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(p.Values(), c.expected) {
+			t.Errorf("[%v] bad values: %v, wants %v", c.name, p.Values(), c.expected)
+		}
+	}
+}
+
+// values returns integers from min to max
+func values(min, max int) []float64 {
+	v := make([]float64, 0, max-min+1)
+	for i := min; i <= max; i++ {
+		v = append(v, float64(i))
+	}
+	return v
+}
+
+// paraboloid is best at x=3, y=0.2, each point has latency of x ms
+func paraboloid(calls *int) Objective {
+	return func(p Point) (Score, error) {
+		*calls++
+		dx, dy := p["x"]-3, (p["y"]-0.2)*10
+		return Score{IoU: 1 - (dx*dx+dy*dy)/100, Latency: time.Duration(p["x"] * float64(time.Millisecond))}, nil
+	}
+}
+
+var params = []Param{{Name: "x", Min: 0, Max: 6, Step: 1}, {Name: "y", Min: 0, Max: 0.5, Step: 0.1}}
+
+func TestTuner_Grid(t *testing.T) {
+	var calls int
+	tuner := NewTuner(params, paraboloid(&calls), WithLatencyWeight(0))
+	if err := tuner.Grid(); err != nil {
+		t.Fatalf("unable to run grid search: %v", err)
+	}
+	if calls != 42 || tuner.GridSize() != 42 {
+		t.Errorf("bad number of measures: %v, grid size %v, wants 42", calls, tuner.GridSize())
+	}
+	ranked := tuner.Ranked()
+	if best := ranked[0]; best.Point.String() != "x=3 y=0.2" || best.IoU != 1. {
+		t.Errorf("bad best trial: %+v", best)
+	}
+	for i := 1; i < len(ranked); i++ {
+		if ranked[i].Value > ranked[i-1].Value {
+			t.Errorf("trials not ranked: %+v before %+v", ranked[i-1], ranked[i])
+		}
+	}
+
+	// Measures are cached
+	if err := tuner.Grid(); err != nil || calls != 42 {
+		t.Errorf("points measured twice: %v calls, err %v", calls, err)
+	}
+
+	if err := NewTuner(params, paraboloid(&calls), WithMaxTrials(10)).Grid(); err == nil {
+		t.Errorf("grid larger than max trials should fail")
+	}
+
+	// Oversized grids are rejected without being built
+	huge := []Param{{Name: "x", Min: 0, Max: 100, Step: 1e-9}, {Name: "y", Min: 0, Max: 1e12, Step: 1}, params[1]}
+	tuner = NewTuner(huge, paraboloid(&calls))
+	if size := tuner.GridSize(); size <= DefaultMaxTrials {
+		t.Errorf("bad size of oversized grid: %v", size)
+	}
+	if err := tuner.Grid(); err == nil {
+		t.Errorf("oversized grid should fail")
+	}
+}
+
+func TestTuner_LatencyWeight(t *testing.T) {
+	var calls int
+	tuner := NewTuner(params, paraboloid(&calls), WithLatencyWeight(0.1))
+	if err := tuner.Grid(); err != nil {
+		t.Fatalf("unable to run grid search: %v", err)
+	}
+	// Each ms costs more IoU than it brings
+	if best := tuner.Ranked()[0]; best.Point.String() != "x=0 y=0.2" {
+		t.Errorf("bad best trial with latency weight: %+v", best)
+	}
+}
+
+func TestTuner_Random(t *testing.T) {
+	var calls int
+	tuner := NewTuner(params, paraboloid(&calls))
+	if err := tuner.Random(20, rand.New(rand.NewSource(1))); err != nil {
+		t.Fatalf("unable to run random search: %v", err)
+	}
+	trials := tuner.Ranked()
+	if len(trials) == 0 || len(trials) > 20 || calls != len(trials) {
+		t.Errorf("bad number of trials: %v for %v measures", len(trials), calls)
+	}
+	for _, trial := range trials {
+		x, y := trial.Point["x"], trial.Point["y"]
+		if x < 0 || x > 6 || x != math.Round(x) || y < 0 || y > 0.5 {
+			t.Errorf("point %v out of ranges", trial.Point)
+		}
+	}
+}
+
+func TestTuner_CoordinateDescent(t *testing.T) {
+	var calls int
+	tuner := NewTuner(params, paraboloid(&calls), WithLatencyWeight(0))
+	best, err := tuner.CoordinateDescent(Point{"x": 6, "y": 0.5}, 5)
+	if err != nil {
+		t.Fatalf("unable to run coordinate descent: %v", err)
+	}
+	if best.Point.String() != "x=3 y=0.2" {
+		t.Errorf("bad best trial: %+v", best)
+	}
+	if calls >= tuner.GridSize() {
+		t.Errorf("coordinate descent should measure less points than grid: %v", calls)
+	}
+}
@@ -0,0 +1,181 @@
+// Package webui serves a web page to tune road detector parameters with sliders while watching live detection
+// stages
+package webui
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+// ErrSaveDisabled is returned by SaveFunc when configuration can't be persisted
+var ErrSaveDisabled = errors.New("no config file to save settings, start rc-road with -config")
+
+// maxConfigSize limits size of configuration documents posted to handler
+const maxConfigSize = 64 << 10
+
+//go:embed index.html
+var indexPage []byte
+
+// Detector is the road detector tuned from web page, implemented by part.RoadPart
+type Detector interface {
+	Config() part.DetectorConfig
+	// ApplyConfig applies a json document of detector parameters and returns effective configuration
+	ApplyConfig(doc []byte) (part.DetectorConfig, error)
+}
+
+// SaveFunc persists detector configuration
+type SaveFunc func(config part.DetectorConfig) error
+
+// Param is a detector parameter tuned by a slider
+type Param struct {
+	// Name is the json path of parameter in part.DetectorConfig, '.' separated for nested fields
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Step  float64 `json:"step"`
+}
+
+// Params are the sliders of web page
+var Params = []Param{
+	{Name: "horizon", Label: "Horizon (rows)", Min: 0, Max: 240, Step: 1},
+	{Name: "threshold", Label: "Threshold", Min: 0, Max: 255, Step: 1},
+	{Name: "threshold_max_value", Label: "Threshold max value", Min: 1, Max: 255, Step: 1},
+	{Name: "kernel_size", Label: "Kernel size", Min: 1, Max: 15, Step: 1},
+	{Name: "morpho_iterations", Label: "Morpho iterations", Min: 0, Max: 10, Step: 1},
+	{Name: "approx_poly_epsilon_factor", Label: "Epsilon factor", Min: 0.001, Max: 0.1, Step: 0.001},
+	{Name: "trust_region.min_x", Label: "Trust region min x", Min: 0, Max: 320, Step: 1},
+	{Name: "trust_region.max_x", Label: "Trust region max x", Min: 0, Max: 320, Step: 1},
+	{Name: "trust_region.min_y", Label: "Trust region min y", Min: 0, Max: 240, Step: 1},
+	{Name: "trust_region.max_y", Label: "Trust region max y", Min: 0, Max: 240, Step: 1},
+}
+
+// Stream is a MJPEG image stream displayed on web page
+type Stream struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// layout is the json document that describes web page content
+type layout struct {
+	Params  []Param  `json:"params"`
+	Streams []Stream `json:"streams"`
+}
+
+// Handler serves web page on '/' and its json api:
+//
+//   - GET /api/layout: sliders and streams of page
+//   - GET /api/config: current detector configuration
+//   - POST /api/config: applies json document of detector parameters, fields missing from document are unchanged
+//   - POST /api/save: saves current detector configuration
+//
+// Configuration responses are part.ConfigAck documents. Handler is usually mounted with http.StripPrefix.
+type Handler struct {
+	detector Detector
+	save     SaveFunc
+	layout   layout
+	mux      *http.ServeMux
+}
+
+// NewHandler returns handler that tunes detector and displays streams. save may return ErrSaveDisabled.
+func NewHandler(detector Detector, save SaveFunc, streams []Stream) *Handler {
+	h := Handler{
+		detector: detector,
+		save:     save,
+		layout:   layout{Params: Params, Streams: streams},
+		mux:      http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/", h.serveIndex)
+	h.mux.HandleFunc("/api/layout", h.serveLayout)
+	h.mux.HandleFunc("/api/config", h.serveConfig)
+	h.mux.HandleFunc("/api/save", h.serveSave)
+	return &h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(indexPage); err != nil {
+		zap.S().Debugf("unable to write tuning page: %v", err)
+	}
+}
+
+func (h *Handler) serveLayout(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, &h.layout)
+}
+
+func (h *Handler) serveConfig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, &part.ConfigAck{Status: part.ConfigApplied, Config: h.detector.Config()})
+	case http.MethodPost:
+		doc, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxConfigSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to read body: %v", err), http.StatusBadRequest)
+			return
+		}
+		config, err := h.detector.ApplyConfig(doc)
+		if err != nil {
+			zap.S().Warnf("reject configuration from tuning page: %v", err)
+			writeJSON(w, http.StatusBadRequest, &part.ConfigAck{Status: part.ConfigRejected, Error: err.Error(), Config: config})
+			return
+		}
+		zap.S().Debugf("configuration from tuning page applied: %+v", config)
+		writeJSON(w, http.StatusOK, &part.ConfigAck{Status: part.ConfigApplied, Config: config})
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveSave(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	config := h.detector.Config()
+	if err := h.save(config); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSaveDisabled) {
+			status = http.StatusConflict
+		} else {
+			zap.S().Errorf("unable to save configuration from tuning page: %v", err)
+		}
+		writeJSON(w, status, &part.ConfigAck{Status: part.ConfigRejected, Error: err.Error(), Config: config})
+		return
+	}
+	zap.S().Infof("configuration saved from tuning page: %+v", config)
+	writeJSON(w, http.StatusOK, &part.ConfigAck{Status: part.ConfigApplied, Config: config})
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.S().Debugf("unable to write %T response: %v", v, err)
+	}
+}
@@ -0,0 +1,108 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cyrilix/robocar-road/pkg/part"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDetector applies configurations like part.RoadPart
+type fakeDetector struct {
+	config part.DetectorConfig
+}
+
+func (f *fakeDetector) Config() part.DetectorConfig {
+	return f.config
+}
+
+func (f *fakeDetector) ApplyConfig(doc []byte) (part.DetectorConfig, error) {
+	config, err := part.ParseDetectorConfig(doc, f.config)
+	if err != nil {
+		return f.config, err
+	}
+	if err := config.Validate(); err != nil {
+		return f.config, err
+	}
+	f.config = config
+	return config, nil
+}
+
+func TestHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		method, path   string
+		body           string
+		saveErr        error
+		expectedStatus int
+		// expected is a part of expected body
+		expected string
+		// check validates effective and saved detector configurations, if not nil
+		check func(config part.DetectorConfig, saved *part.DetectorConfig) bool
+	}{
+		{name: "page", method: http.MethodGet, path: "/", expectedStatus: http.StatusOK, expected: "<title>rc-road tuning</title>"},
+		{name: "unknown path", method: http.MethodGet, path: "/other", expectedStatus: http.StatusNotFound},
+		{name: "layout", method: http.MethodGet, path: "/api/layout", expectedStatus: http.StatusOK,
+			expected: `{"name":"mask","url":"/stream/mask"}`},
+		{name: "current config", method: http.MethodGet, path: "/api/config", expectedStatus: http.StatusOK,
+			expected: `"threshold":180`},
+		{name: "apply config", method: http.MethodPost, path: "/api/config", body: `{"threshold": 150, "trust_region": {"min_x": 10}}`,
+			expectedStatus: http.StatusOK, expected: `"status":"applied"`,
+			check: func(config part.DetectorConfig, saved *part.DetectorConfig) bool {
+				return config.Threshold == 150 && config.TrustRegion.MinX == 10 && config.TrustRegion.MaxX == 115 && saved == nil
+			}},
+		{name: "invalid config", method: http.MethodPost, path: "/api/config", body: `{"kernel_size": 0}`,
+			expectedStatus: http.StatusBadRequest, expected: `"status":"rejected"`,
+			check: func(config part.DetectorConfig, _ *part.DetectorConfig) bool { return config.KernelSize == 4 }},
+		{name: "unknown parameter", method: http.MethodPost, path: "/api/config", body: `{"kernel": 3}`,
+			expectedStatus: http.StatusBadRequest, expected: `"status":"rejected"`},
+		{name: "bad config method", method: http.MethodDelete, path: "/api/config", expectedStatus: http.StatusMethodNotAllowed},
+		{name: "save", method: http.MethodPost, path: "/api/save", expectedStatus: http.StatusOK, expected: `"status":"applied"`,
+			check: func(config part.DetectorConfig, saved *part.DetectorConfig) bool {
+				return saved != nil && *saved == config
+			}},
+		{name: "save disabled", method: http.MethodPost, path: "/api/save", saveErr: ErrSaveDisabled,
+			expectedStatus: http.StatusConflict, expected: "-config"},
+		{name: "save error", method: http.MethodPost, path: "/api/save", saveErr: errors.New("disk full"),
+			expectedStatus: http.StatusInternalServerError, expected: "disk full"},
+		{name: "bad save method", method: http.MethodGet, path: "/api/save", expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		detector := fakeDetector{config: part.DefaultDetectorConfig()}
+		var saved *part.DetectorConfig
+		save := func(config part.DetectorConfig) error {
+			if c.saveErr != nil {
+				return c.saveErr
+			}
+			saved = &config
+			return nil
+		}
+		h := NewHandler(&detector, save, []Stream{{Name: "road", URL: "/stream/road"}, {Name: "mask", URL: "/stream/mask"}})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		resp := w.Result()
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != c.expectedStatus {
+			t.Errorf("[%v] bad status: %v, wants %v (%s)", c.name, resp.StatusCode, c.expectedStatus, body)
+		}
+		if !strings.Contains(string(body), c.expected) {
+			t.Errorf("[%v] bad body: %s, wants to contain %v", c.name, body, c.expected)
+		}
+		if strings.HasPrefix(c.path, "/api/config") || strings.HasPrefix(c.path, "/api/save") {
+			var ack part.ConfigAck
+			if resp.StatusCode != http.StatusMethodNotAllowed && json.Unmarshal(body, &ack) != nil {
+				t.Errorf("[%v] response is not a config ack: %s", c.name, body)
+			}
+		}
+		if c.check != nil && !c.check(detector.config, saved) {
+			t.Errorf("[%v] bad configuration: %+v, saved: %+v", c.name, detector.config, saved)
+		}
+	}
+}